@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tass-io/scheduler/pkg/predictmodel"
+	"github.com/tass-io/scheduler/pkg/predictmodel/store"
+	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
+	"github.com/tass-io/scheduler/pkg/workflow"
+)
+
+var (
+	graphWorkflowPath string
+	graphFormat       string
+	graphModelPath    string
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Render a Workflow as Graphviz DOT or Mermaid.",
+	Long: "Render a Workflow as Graphviz DOT or Mermaid, " +
+		"the flow probabilities and the average exec/coldstart time can be overlaid by a prediction model file.",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workflows, err := k8sutils.LoadWorkflowsFromFile(graphWorkflowPath)
+		if err != nil {
+			return err
+		}
+		if len(workflows) == 0 {
+			return errors.New("no workflow found in " + graphWorkflowPath)
+		}
+		var model *predictmodel.Model
+		if graphModelPath != "" {
+			sts, err := store.LoadStatistics(graphModelPath)
+			if err != nil {
+				return err
+			}
+			model = predictmodel.NewMarkovPolicy().GetModel(sts)
+		}
+		for _, wf := range workflows {
+			graph, err := workflow.RenderGraph(wf, workflow.GraphFormat(graphFormat), model)
+			if err != nil {
+				return err
+			}
+			fmt.Print(graph)
+		}
+		return nil
+	},
+}
+
+func init() {
+	graphCmd.Flags().StringVarP(&graphWorkflowPath, "workflow", "w", "./workflow.yaml", "the Workflow file to render")
+	graphCmd.Flags().StringVarP(&graphFormat, "output", "o", string(workflow.DOT), "the graph format, dot or mermaid")
+	graphCmd.Flags().StringVar(&graphModelPath, "model", "",
+		"the prediction model file to overlay, e.g. /tass/model/<workflow>.yaml")
+}
@@ -50,6 +50,8 @@ var (
 			workflow.InitManager()
 			// start the manager based on the startup parameters
 			workflow.GetManager().Start()
+			// create the minimum instances of the functions after the event framework starts
+			go fnscheduler.GetFunctionScheduler().WarmUp()
 
 			r := gin.Default()
 			// pprof.Register(r)
@@ -120,24 +122,88 @@ func basicFlags() {
 	viper.BindPFlag(env.StaticMiddleware, rootCmd.Flags().Lookup(env.StaticMiddleware))
 	rootCmd.Flags().BoolP(env.QPSMiddleware, "q", false, "whether to use QPSMiddleware")
 	viper.BindPFlag(env.QPSMiddleware, rootCmd.Flags().Lookup(env.QPSMiddleware))
+	rootCmd.Flags().Bool(env.ConcurrencyAutoscaler, false, "whether to autoscale by the in-flight requests")
+	viper.BindPFlag(env.ConcurrencyAutoscaler, rootCmd.Flags().Lookup(env.ConcurrencyAutoscaler))
+	rootCmd.Flags().Float64(env.TargetConcurrency, 10, "the expected in-flight requests of a process for autoscaling")
+	viper.BindPFlag(env.TargetConcurrency, rootCmd.Flags().Lookup(env.TargetConcurrency))
+	rootCmd.Flags().Duration(env.StableWindow, time.Minute, "the window of the averaged concurrency in stable mode")
+	viper.BindPFlag(env.StableWindow, rootCmd.Flags().Lookup(env.StableWindow))
+	rootCmd.Flags().Duration(env.PanicWindow, 6*time.Second, "the window of the averaged concurrency in panic mode")
+	viper.BindPFlag(env.PanicWindow, rootCmd.Flags().Lookup(env.PanicWindow))
+	rootCmd.Flags().Float64(env.PanicThreshold, 2, "the ratio of desired to running processes that enters panic mode")
+	viper.BindPFlag(env.PanicThreshold, rootCmd.Flags().Lookup(env.PanicThreshold))
 	rootCmd.Flags().DurationP(env.TTL, "T", 20*time.Second, "set process default ttl")
 	viper.BindPFlag(env.TTL, rootCmd.Flags().Lookup(env.TTL))
+	rootCmd.Flags().Duration(env.CrashBackoff, time.Second, "the initial delay before replacing a crashed process")
+	viper.BindPFlag(env.CrashBackoff, rootCmd.Flags().Lookup(env.CrashBackoff))
+	rootCmd.Flags().Duration(env.CrashBackoffLimit, time.Minute, "the max delay before replacing a crash looping process")
+	viper.BindPFlag(env.CrashBackoffLimit, rootCmd.Flags().Lookup(env.CrashBackoffLimit))
+	rootCmd.Flags().Duration(env.DrainTimeout, time.Minute,
+		"how long a scaled down process serves its in-flight requests before released, 0 means no limit")
+	viper.BindPFlag(env.DrainTimeout, rootCmd.Flags().Lookup(env.DrainTimeout))
+	rootCmd.Flags().String(env.CgroupRoot, "",
+		"the cgroup v2 group where each process gets a child group with its limits, disabled if empty")
+	viper.BindPFlag(env.CgroupRoot, rootCmd.Flags().Lookup(env.CgroupRoot))
+	rootCmd.Flags().String(env.Sandbox, "",
+		"the default sandbox settings of the function processes in JSON, not sandboxed if empty")
+	viper.BindPFlag(env.Sandbox, rootCmd.Flags().Lookup(env.Sandbox))
+	rootCmd.Flags().String(env.CodeCacheDir, env.TassFileRoot+"cache/", "the directory of the local function code cache")
+	viper.BindPFlag(env.CodeCacheDir, rootCmd.Flags().Lookup(env.CodeCacheDir))
+	rootCmd.Flags().String(env.CodeCacheSize, "1Gi", "the max size of the local function code cache, disabled if 0")
+	viper.BindPFlag(env.CodeCacheSize, rootCmd.Flags().Lookup(env.CodeCacheSize))
+	rootCmd.Flags().Int(env.RuntimePoolSize, 0,
+		"the number of pre-warmed runtime processes waiting for a Function plugin, disabled if 0")
+	viper.BindPFlag(env.RuntimePoolSize, rootCmd.Flags().Lookup(env.RuntimePoolSize))
+	rootCmd.Flags().String(env.FunctionConfigPath, "", "the local file of the function-level scheduling settings")
+	viper.BindPFlag(env.FunctionConfigPath, rootCmd.Flags().Lookup(env.FunctionConfigPath))
+	rootCmd.Flags().Int(env.MaxConcurrency, 0, "the default max concurrent requests of a process, 0 means unlimited")
+	viper.BindPFlag(env.MaxConcurrency, rootCmd.Flags().Lookup(env.MaxConcurrency))
+	rootCmd.Flags().Int(env.QueueSize, 100, "the default max number of requests waiting for saturated processes")
+	viper.BindPFlag(env.QueueSize, rootCmd.Flags().Lookup(env.QueueSize))
+	rootCmd.Flags().Duration(env.QueueTimeout, 10*time.Second, "the default max time a request waits in the queue")
+	viper.BindPFlag(env.QueueTimeout, rootCmd.Flags().Lookup(env.QueueTimeout))
+	rootCmd.Flags().Int(env.MaxRequestsPerInstance, 0,
+		"the default number of requests after which a process is replaced, 0 means unlimited")
+	viper.BindPFlag(env.MaxRequestsPerInstance, rootCmd.Flags().Lookup(env.MaxRequestsPerInstance))
+	rootCmd.Flags().Duration(env.MaxInstanceLifetime, 0, "the default lifetime after which a process is replaced, 0 means unlimited")
+	viper.BindPFlag(env.MaxInstanceLifetime, rootCmd.Flags().Lookup(env.MaxInstanceLifetime))
 	rootCmd.Flags().DurationP(env.LSDSWait, "t", 200*time.Millisecond, "lsds wait a period of time for instance start")
 	viper.BindPFlag(env.LSDSWait, rootCmd.Flags().Lookup(env.LSDSWait))
+	rootCmd.Flags().Duration(env.ExecutionTTL, 10*time.Minute, "how long a finished async execution is retained")
+	viper.BindPFlag(env.ExecutionTTL, rootCmd.Flags().Lookup(env.ExecutionTTL))
+	rootCmd.Flags().Int(env.ExecutionCapacity, 1000, "the max number of async executions retained in memory")
+	viper.BindPFlag(env.ExecutionCapacity, rootCmd.Flags().Lookup(env.ExecutionCapacity))
+	rootCmd.Flags().Int(env.SyncExecutionCapacity, 1000, "the max number of running sync executions recorded in memory")
+	viper.BindPFlag(env.SyncExecutionCapacity, rootCmd.Flags().Lookup(env.SyncExecutionCapacity))
+	rootCmd.Flags().String(env.ExecutionHistory, "local", "where to persist the finished executions, local or none")
+	viper.BindPFlag(env.ExecutionHistory, rootCmd.Flags().Lookup(env.ExecutionHistory))
+	rootCmd.Flags().Int(env.ExecutionHistoryCapacity, 10000, "the max number of executions persisted in the history")
+	viper.BindPFlag(env.ExecutionHistoryCapacity, rootCmd.Flags().Lookup(env.ExecutionHistoryCapacity))
 }
 
 func policyFlags() {
 	// policyFlag should not use single dash with a short letter
 	rootCmd.Flags().String(env.RemoteCallPolicy, "simple", "policy name to use")
 	viper.BindPFlag(env.RemoteCallPolicy, rootCmd.Flags().Lookup(env.RemoteCallPolicy))
-	rootCmd.Flags().String(env.InstanceScorePolicy, "default", "settings about instance.Score")
+	rootCmd.Flags().String(env.InstanceScorePolicy, "default",
+		"settings about instance.Score, default, least-outstanding, ewma, round-robin or p2c")
 	viper.BindPFlag(env.InstanceScorePolicy, rootCmd.Flags().Lookup(env.InstanceScorePolicy))
-	rootCmd.Flags().String(env.CreatePolicy, "default", "settings about fnscheduler.canCreate")
+	rootCmd.Flags().String(env.CreatePolicy, "default", "settings about fnscheduler.canCreate, default or resource")
 	viper.BindPFlag(env.CreatePolicy, rootCmd.Flags().Lookup(env.CreatePolicy))
+	rootCmd.Flags().String(env.ScaleDownPolicy, "default",
+		"settings about the scale-down victims, default (lowest score), idle-longest, fewest-in-flight or oldest-first")
+	viper.BindPFlag(env.ScaleDownPolicy, rootCmd.Flags().Lookup(env.ScaleDownPolicy))
+	rootCmd.Flags().String(env.KeepAlivePolicy, "fixed",
+		"settings about the instance keep-alive, fixed (the TTL) or histogram (adaptive by the inter-arrival times)")
+	viper.BindPFlag(env.KeepAlivePolicy, rootCmd.Flags().Lookup(env.KeepAlivePolicy))
+	rootCmd.Flags().String(env.NodeCPU, "", "the cpu capacity for the resource create policy, e.g. 4 or 3500m, detected if empty")
+	viper.BindPFlag(env.NodeCPU, rootCmd.Flags().Lookup(env.NodeCPU))
+	rootCmd.Flags().String(env.NodeMemory, "", "the memory capacity for the resource create policy, e.g. 8Gi, detected if empty")
+	viper.BindPFlag(env.NodeMemory, rootCmd.Flags().Lookup(env.NodeMemory))
 }
 
 func storageFlags() {
-	rootCmd.PersistentFlags().StringP(env.RedisIP, "I", "10.0.2.79", "redis ip to init function")
+	rootCmd.PersistentFlags().StringP(env.RedisIP, "I", "", "redis ip to init function, required by the redis code store")
 	viper.BindPFlag(env.RedisIP, rootCmd.PersistentFlags().Lookup(env.RedisIP))
 	rootCmd.PersistentFlags().StringP(env.RedisPort, "P", "6379", "redis port to init function")
 	viper.BindPFlag(env.RedisPort, rootCmd.PersistentFlags().Lookup(env.RedisPort))
@@ -145,6 +211,20 @@ func storageFlags() {
 	viper.BindPFlag(env.RedisPassword, rootCmd.PersistentFlags().Lookup(env.RedisPassword))
 	rootCmd.PersistentFlags().Int32P(env.DefaultDb, "D", 0, "redis default db to init function")
 	viper.BindPFlag(env.DefaultDb, rootCmd.PersistentFlags().Lookup(env.DefaultDb))
+	rootCmd.PersistentFlags().String(env.CodeStore, "redis", "the function code store, redis, local, s3 or http")
+	viper.BindPFlag(env.CodeStore, rootCmd.PersistentFlags().Lookup(env.CodeStore))
+	rootCmd.PersistentFlags().String(env.CodeStorePath, env.TassFileRoot+"store/", "the directory of the local code store")
+	viper.BindPFlag(env.CodeStorePath, rootCmd.PersistentFlags().Lookup(env.CodeStorePath))
+	rootCmd.PersistentFlags().String(env.CodeStoreURL, "", "the base url of the http code store or the endpoint of the s3 code store")
+	viper.BindPFlag(env.CodeStoreURL, rootCmd.PersistentFlags().Lookup(env.CodeStoreURL))
+	rootCmd.PersistentFlags().String(env.S3Bucket, "", "the bucket of the s3 code store")
+	viper.BindPFlag(env.S3Bucket, rootCmd.PersistentFlags().Lookup(env.S3Bucket))
+	rootCmd.PersistentFlags().String(env.S3Region, "us-east-1", "the region of the s3 code store")
+	viper.BindPFlag(env.S3Region, rootCmd.PersistentFlags().Lookup(env.S3Region))
+	rootCmd.PersistentFlags().String(env.S3AccessKey, "", "the access key of the s3 code store")
+	viper.BindPFlag(env.S3AccessKey, rootCmd.PersistentFlags().Lookup(env.S3AccessKey))
+	rootCmd.PersistentFlags().String(env.S3SecretKey, "", "the secret key of the s3 code store")
+	viper.BindPFlag(env.S3SecretKey, rootCmd.PersistentFlags().Lookup(env.S3SecretKey))
 }
 
 func tracingFlags() {
@@ -161,6 +241,9 @@ func flagsInit() {
 
 func commandsInit() {
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(graphCmd)
+	rootCmd.AddCommand(uploadCmd)
 	rootCmd.AddCommand(initial.InitCmd)
 }
 
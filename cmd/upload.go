@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/tass-io/scheduler/pkg/store"
+	"github.com/tass-io/scheduler/pkg/utils/base64"
+	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
+)
+
+var (
+	uploadFunctionName string
+	uploadNamespace    string
+)
+
+var uploadCmd = &cobra.Command{
+	Use:   "upload <path>",
+	Short: "Upload the code of a Function to the code store.",
+	Long: "Upload the code of a Function to the code store chosen by --codeStore. " +
+		"The code is stored as a zip archive encoded into base64: a directory is zipped, a zip archive is taken as is " +
+		"and other files like a built plugin.so are zipped with their names.",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		code, err := encodeCode(args[0])
+		if err != nil {
+			return err
+		}
+		if err := store.GetCodeStore().Set(uploadNamespace, uploadFunctionName, code); err != nil {
+			return err
+		}
+		fmt.Printf("function %s/%s uploaded, size: %d, sha256: %s\n",
+			uploadNamespace, uploadFunctionName, len(code), store.Checksum(code))
+		return nil
+	},
+}
+
+var uploadListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List the Function code in the code store.",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		infos, err := store.GetCodeStore().List(uploadNamespace)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tSHA256")
+		for _, info := range infos {
+			digest := info.Digest
+			if digest == "" {
+				// uploaded by the old scripts without the digest
+				digest = "-"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", info.Name, info.Size, digest)
+		}
+		return w.Flush()
+	},
+}
+
+var uploadDeleteCmd = &cobra.Command{
+	Use:          "delete",
+	Short:        "Delete the code of a Function from the code store.",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := store.GetCodeStore().Delete(uploadNamespace, uploadFunctionName); err != nil {
+			return err
+		}
+		fmt.Printf("function %s/%s deleted\n", uploadNamespace, uploadFunctionName)
+		return nil
+	},
+}
+
+// encodeCode zips the directory or the file unless it's a zip archive, and encodes the archive into base64
+func encodeCode(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return base64.EncodeUserDirectory(path)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return base64.EncodeUserCode(path)
+	}
+	return base64.EncodeUserFile(path)
+}
+
+func init() {
+	uploadCmd.PersistentFlags().StringVarP(&uploadNamespace, "namespace", "N", k8sutils.GetSelfNamespace(),
+		"the namespace of the Function")
+	uploadCmd.Flags().StringVarP(&uploadFunctionName, "name", "n", "", "the name of the Function")
+	_ = uploadCmd.MarkFlagRequired("name")
+	uploadDeleteCmd.Flags().StringVarP(&uploadFunctionName, "name", "n", "", "the name of the Function")
+	_ = uploadDeleteCmd.MarkFlagRequired("name")
+	uploadCmd.AddCommand(uploadListCmd)
+	uploadCmd.AddCommand(uploadDeleteCmd)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
+	"github.com/tass-io/scheduler/pkg/workflow"
+)
+
+var (
+	validateWorkflowPath  string
+	validateFunctionPaths []string
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Statically check a Workflow.",
+	// the problems are printed, the usage is not helpful here
+	SilenceUsage: true,
+	Long: "Statically check a Workflow, including the roles, the Flow references, the conditions, " +
+		"the referenced Functions, the unreachable Flows and the cycles.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workflows, err := k8sutils.LoadWorkflowsFromFile(validateWorkflowPath)
+		if err != nil {
+			return err
+		}
+		if len(workflows) == 0 {
+			return errors.New("no workflow found in " + validateWorkflowPath)
+		}
+		functions := map[string]bool{}
+		for _, path := range validateFunctionPaths {
+			fns, err := k8sutils.LoadFunctionsFromFile(path)
+			if err != nil {
+				return err
+			}
+			for _, fn := range fns {
+				functions[fn.Name] = true
+			}
+		}
+		// the Function check is skipped when no Function files are given
+		var fnExists workflow.FunctionExists
+		if len(validateFunctionPaths) > 0 {
+			fnExists = func(name string) bool {
+				return functions[name]
+			}
+		}
+
+		invalid := false
+		for _, wf := range workflows {
+			warnings, err := workflow.Validate(wf, fnExists)
+			for _, warning := range warnings {
+				fmt.Printf("workflow %s warning: %s\n", wf.Name, warning)
+			}
+			var verr *workflow.ValidationError
+			if errors.As(err, &verr) {
+				invalid = true
+				fmt.Printf("workflow %s is invalid:\n", wf.Name)
+				for _, problem := range verr.Problems {
+					fmt.Println("  - " + problem)
+				}
+				continue
+			}
+			fmt.Printf("workflow %s is valid\n", wf.Name)
+		}
+		if invalid {
+			return errors.New("validation failed")
+		}
+		return nil
+	},
+}
+
+func init() {
+	validateCmd.Flags().StringVarP(&validateWorkflowPath, "workflow", "w", "./workflow.yaml", "the Workflow file to check")
+	validateCmd.Flags().StringSliceVarP(&validateFunctionPaths, "functions", "f", []string{},
+		"the Function files which the Workflow refers to")
+}
@@ -0,0 +1,327 @@
+package codecache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/store"
+	"github.com/tass-io/scheduler/pkg/utils/resourceutils"
+	"go.uber.org/zap"
+)
+
+// codecache pkg keeps the function code on the local disk, the artifacts are named by the sha256 digest
+// of the code, so all instances of the same function version share one artifact.
+// The least recently used artifacts are evicted when the total size exceeds the capacity.
+
+// legacyTTL is how long the code without the digest is served from the cache before it's fetched again,
+// the code may be updated in the store and there is no digest to tell it
+const legacyTTL = time.Minute
+
+var (
+	ErrDigestMismatch = errors.New("code digest mismatch")
+
+	cache *Cache
+	once  = &sync.Once{}
+)
+
+// Cache is a content-addressed cache of the function code
+type Cache struct {
+	dir      string
+	capacity int64
+	mu       sync.Locker
+	size     int64
+	// entries is the index of the artifacts, the key is the digest
+	entries map[string]*list.Element
+	// lru is the list of the artifacts, the front is the most recently used one
+	lru *list.List
+	// legacy is the checksum of the code without the digest, the key is namespace/name
+	legacy map[string]legacyCode
+}
+
+// legacyCode is the code without the digest fetched from the store
+type legacyCode struct {
+	checksum  string
+	fetchedAt time.Time
+}
+
+// entry is an artifact in the cache
+type entry struct {
+	digest string
+	size   int64
+}
+
+// GetCache returns the Cache singleton, it returns nil if the cache is disabled or fails to init
+func GetCache() *Cache {
+	once.Do(func() {
+		capacity, err := resourceutils.ParseMemory(viper.GetString(env.CodeCacheSize))
+		if err != nil {
+			zap.S().Errorw("parse code cache size error, the code cache is disabled", "err", err)
+			return
+		}
+		if capacity <= 0 {
+			return
+		}
+		c, err := New(viper.GetString(env.CodeCacheDir), capacity)
+		if err != nil {
+			zap.S().Errorw("init code cache error, the code cache is disabled", "err", err)
+			return
+		}
+		cache = c
+	})
+	return cache
+}
+
+// New returns a Cache in the directory (param1) with the capacity (param2) in bytes,
+// the artifacts left in the directory are verified and loaded, the broken ones are removed
+func New(dir string, capacity int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:      dir,
+		capacity: capacity,
+		mu:       &sync.Mutex{},
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		legacy:   map[string]legacyCode{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load loads the artifacts in the directory, the most recently used is the latest modified one
+func (c *Cache) load() error {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		path := filepath.Join(c.dir, info.Name())
+		if info.IsDir() {
+			continue
+		}
+		digest, err := checksumFile(path)
+		if err != nil || digest != info.Name() {
+			zap.S().Warnw("remove broken code artifact", "path", path, "digest", digest, "err", err)
+			_ = os.Remove(path)
+			continue
+		}
+		c.entries[digest] = c.lru.PushBack(&entry{digest: digest, size: info.Size()})
+		c.size += info.Size()
+	}
+	c.evict("")
+	return nil
+}
+
+// Link links the code of the function to the path (param4), the code is fetched from the code store (param1)
+// and verified if it's not cached. It returns whether it's a cache hit.
+func (c *Cache) Link(codeStore store.CodeStore, ns, name, path string) (bool, error) {
+	digest, err := codeStore.Digest(ns, name)
+	if err != nil {
+		return false, err
+	}
+	key := ns + "/" + name
+	cached := digest
+	if digest == "" {
+		cached = c.legacyChecksum(key)
+	}
+	if cached != "" && c.linkCached(cached, path) {
+		return true, nil
+	}
+	// the store is not locked, the same code may be fetched by several instances at the same time
+	code, err := codeStore.Get(ns, name)
+	if err != nil {
+		return false, err
+	}
+	// the code without the digest is uploaded by the old tools, it's cached by its checksum
+	checksum := store.Checksum(code)
+	if digest != "" && checksum != digest {
+		return false, fmt.Errorf("%w: function %s expects %s but gets %s", ErrDigestMismatch, name, digest, checksum)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if digest == "" {
+		// the code may have been changed in the store since the last fetch
+		c.legacy[key] = legacyCode{checksum: checksum, fetchedAt: time.Now()}
+	}
+	if err := c.put(checksum, code); err != nil {
+		return false, err
+	}
+	return false, c.link(checksum, path)
+}
+
+// legacyChecksum returns the checksum of the code without the digest fetched within the legacyTTL,
+// it returns "" if the code should be fetched again
+func (c *Cache) legacyChecksum(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	code, ok := c.legacy[key]
+	if !ok || time.Since(code.fetchedAt) > legacyTTL {
+		return ""
+	}
+	return code.checksum
+}
+
+// linkCached links the artifact to the path if it's cached, the linked code is verified by the digest
+// out of the lock, so a large artifact doesn't block the other functions
+func (c *Cache) linkCached(digest, path string) bool {
+	c.mu.Lock()
+	if !c.lookup(digest) {
+		c.mu.Unlock()
+		return false
+	}
+	err := c.link(digest, path)
+	c.mu.Unlock()
+	if err != nil {
+		zap.S().Warnw("link cached code error, fetch it again", "digest", digest, "err", err)
+		return false
+	}
+	checksum, err := checksumFile(path)
+	if err != nil || checksum != digest {
+		zap.S().Warnw("cached code artifact is broken, fetch it again", "digest", digest, "checksum", checksum, "err", err)
+		_ = os.Remove(path)
+		c.mu.Lock()
+		if elem, ok := c.entries[digest]; ok {
+			c.remove(elem)
+		}
+		c.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// lookup returns whether the artifact is cached and has the expected size, the broken artifact is removed
+func (c *Cache) lookup(digest string) bool {
+	elem, ok := c.entries[digest]
+	if !ok {
+		return false
+	}
+	e := elem.Value.(*entry)
+	info, err := os.Stat(c.path(digest))
+	if err != nil || info.Size() != e.size {
+		zap.S().Warnw("cached code artifact is broken", "digest", digest, "err", err)
+		c.remove(elem)
+		return false
+	}
+	c.lru.MoveToFront(elem)
+	now := time.Now()
+	_ = os.Chtimes(c.path(digest), now, now)
+	return true
+}
+
+// put writes the code as an artifact and evicts the least recently used ones if the cache is full,
+// the artifact is read-only because it's shared by the instances
+func (c *Cache) put(digest, code string) error {
+	// the code may be put by another instance of the same function just now
+	if c.lookup(digest) {
+		return nil
+	}
+	f, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.WriteString(f, code); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0444); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path(digest)); err != nil {
+		return err
+	}
+	c.entries[digest] = c.lru.PushFront(&entry{digest: digest, size: int64(len(code))})
+	c.size += int64(len(code))
+	c.evict(digest)
+	return nil
+}
+
+// evict removes the least recently used artifacts until the cache is not full,
+// the artifact with the digest (param1) is kept even if it's larger than the capacity
+func (c *Cache) evict(keep string) {
+	for elem := c.lru.Back(); elem != nil && c.size > c.capacity; {
+		prev := elem.Prev()
+		if e := elem.Value.(*entry); e.digest != keep {
+			zap.S().Debugw("evict code artifact", "digest", e.digest, "size", e.size)
+			c.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+// remove deletes the artifact, the instances linked to it are not affected
+func (c *Cache) remove(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.lru.Remove(elem)
+	delete(c.entries, e.digest)
+	c.size -= e.size
+	if err := os.Remove(c.path(e.digest)); err != nil && !os.IsNotExist(err) {
+		zap.S().Warnw("remove code artifact error", "digest", e.digest, "err", err)
+	}
+}
+
+// link hard links the artifact to the path, it falls back to copy if the path is on another device
+func (c *Cache) link(digest, path string) error {
+	_ = os.Remove(path)
+	if err := os.Link(c.path(digest), path); err == nil {
+		return nil
+	}
+	src, err := os.Open(c.path(digest))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0444)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// path returns the path of the artifact
+func (c *Cache) path(digest string) string {
+	return filepath.Join(c.dir, digest)
+}
+
+// checksumFile returns the hex sha256 digest of the file
+func checksumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
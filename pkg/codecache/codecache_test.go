@@ -0,0 +1,170 @@
+package codecache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tass-io/scheduler/pkg/store"
+)
+
+// fakeStore is a CodeStore counting the fetches
+type fakeStore struct {
+	codes   map[string]string
+	digests map[string]string
+	fetched map[string]int
+}
+
+func (s *fakeStore) Get(ns, name string) (string, error) {
+	s.fetched[name]++
+	return s.codes[name], nil
+}
+
+func (s *fakeStore) Set(ns, name, code string) error {
+	return store.ErrReadOnlyStore
+}
+
+func (s *fakeStore) Digest(ns, name string) (string, error) {
+	return s.digests[name], nil
+}
+
+func (s *fakeStore) List(ns string) ([]store.CodeInfo, error) {
+	return nil, store.ErrNotSupported
+}
+
+func (s *fakeStore) Delete(ns, name string) error {
+	return store.ErrReadOnlyStore
+}
+
+func TestCache(t *testing.T) {
+	Convey("test the code cache links the code by the digest", t, func() {
+		dir, err := ioutil.TempDir("", "codecache")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		codes := map[string]string{"a": "aaaa", "b": "bbbb", "c": "cccc"}
+		digests := map[string]string{}
+		for name, code := range codes {
+			digests[name] = store.Checksum(code)
+		}
+		fake := &fakeStore{codes: codes, digests: digests, fetched: map[string]int{}}
+		fetched := fake.fetched
+		read := func(path string) string {
+			content, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+
+		cache, err := New(filepath.Join(dir, "cache"), 8)
+		So(err, ShouldBeNil)
+
+		Convey("the second instance hits the cache", func() {
+			hit, err := cache.Link(fake, "default", "a", filepath.Join(dir, "1"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeFalse)
+			hit, err = cache.Link(fake, "default", "a", filepath.Join(dir, "2"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeTrue)
+			So(fetched["a"], ShouldEqual, 1)
+			So(read(filepath.Join(dir, "1")), ShouldEqual, "aaaa")
+			So(read(filepath.Join(dir, "2")), ShouldEqual, "aaaa")
+		})
+
+		Convey("the least recently used code is evicted", func() {
+			_, _ = cache.Link(fake, "default", "a", filepath.Join(dir, "1"))
+			_, _ = cache.Link(fake, "default", "b", filepath.Join(dir, "2"))
+			// a is used again, so b is the least recently used one
+			hit, _ := cache.Link(fake, "default", "a", filepath.Join(dir, "3"))
+			So(hit, ShouldBeTrue)
+			_, _ = cache.Link(fake, "default", "c", filepath.Join(dir, "4"))
+			So(cache.size, ShouldEqual, 8)
+			So(cache.entries, ShouldContainKey, digests["a"])
+			So(cache.entries, ShouldNotContainKey, digests["b"])
+			// the evicted code is still available to the instance linked to it
+			So(read(filepath.Join(dir, "2")), ShouldEqual, "bbbb")
+			hit, _ = cache.Link(fake, "default", "b", filepath.Join(dir, "5"))
+			So(hit, ShouldBeFalse)
+			So(fetched["b"], ShouldEqual, 2)
+		})
+
+		Convey("the code not matching the digest is rejected", func() {
+			digests["a"] = store.Checksum("other")
+			_, err := cache.Link(fake, "default", "a", filepath.Join(dir, "1"))
+			So(errors.Is(err, ErrDigestMismatch), ShouldBeTrue)
+			So(cache.entries, ShouldBeEmpty)
+		})
+
+		Convey("the code without the digest is cached by its checksum", func() {
+			delete(digests, "a")
+			hit, err := cache.Link(fake, "default", "a", filepath.Join(dir, "1"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeFalse)
+			So(cache.entries, ShouldContainKey, store.Checksum("aaaa"))
+			hit, err = cache.Link(fake, "default", "a", filepath.Join(dir, "2"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeTrue)
+			So(fetched["a"], ShouldEqual, 1)
+			So(read(filepath.Join(dir, "2")), ShouldEqual, "aaaa")
+			// the code may be updated in the store, so it's fetched again after the legacyTTL
+			code := cache.legacy["default/a"]
+			code.fetchedAt = time.Now().Add(-2 * legacyTTL)
+			cache.legacy["default/a"] = code
+			codes["a"] = "dddd"
+			hit, err = cache.Link(fake, "default", "a", filepath.Join(dir, "3"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeFalse)
+			So(fetched["a"], ShouldEqual, 2)
+			So(read(filepath.Join(dir, "3")), ShouldEqual, "dddd")
+		})
+
+		Convey("the changed code without the digest replaces the evicted one", func() {
+			delete(digests, "a")
+			_, err := cache.Link(fake, "default", "a", filepath.Join(dir, "1"))
+			So(err, ShouldBeNil)
+			cache.mu.Lock()
+			cache.remove(cache.entries[store.Checksum("aaaa")])
+			cache.mu.Unlock()
+			codes["a"] = "dddd"
+			hit, err := cache.Link(fake, "default", "a", filepath.Join(dir, "2"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeFalse)
+			So(read(filepath.Join(dir, "2")), ShouldEqual, "dddd")
+			So(cache.legacy["default/a"].checksum, ShouldEqual, store.Checksum("dddd"))
+			hit, err = cache.Link(fake, "default", "a", filepath.Join(dir, "3"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeTrue)
+		})
+
+		Convey("the broken artifact of the same size is detected on hit", func() {
+			_, _ = cache.Link(fake, "default", "a", filepath.Join(dir, "1"))
+			broken := cache.path(digests["a"])
+			So(os.Chmod(broken, 0644), ShouldBeNil)
+			So(ioutil.WriteFile(broken, []byte("xxxx"), 0644), ShouldBeNil)
+			hit, err := cache.Link(fake, "default", "a", filepath.Join(dir, "2"))
+			So(err, ShouldBeNil)
+			So(hit, ShouldBeFalse)
+			So(fetched["a"], ShouldEqual, 2)
+			So(read(filepath.Join(dir, "2")), ShouldEqual, "aaaa")
+			So(read(broken), ShouldEqual, "aaaa")
+		})
+
+		Convey("the artifacts are verified when reloaded", func() {
+			_, _ = cache.Link(fake, "default", "a", filepath.Join(dir, "1"))
+			_, _ = cache.Link(fake, "default", "b", filepath.Join(dir, "2"))
+			broken := cache.path(digests["b"])
+			So(os.Chmod(broken, 0644), ShouldBeNil)
+			So(ioutil.WriteFile(broken, []byte("broken"), 0644), ShouldBeNil)
+
+			reloaded, err := New(cache.dir, 8)
+			So(err, ShouldBeNil)
+			So(reloaded.entries, ShouldContainKey, digests["a"])
+			So(reloaded.entries, ShouldNotContainKey, digests["b"])
+			So(reloaded.size, ShouldEqual, 4)
+			_, err = os.Stat(broken)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
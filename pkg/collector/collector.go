@@ -27,6 +27,15 @@ type Collector struct {
 	wf      string
 	ch      chan *record
 	records map[string]*store.Object
+	// codePrepares is the code preparing phase of the cold starts, the key is the function name
+	codePrepares map[string]*CodePrepare
+}
+
+// CodePrepare records the time cost of preparing the function code when a process instance starts,
+// it's split by whether the code is in the local code cache
+type CodePrepare struct {
+	Hits   []time.Duration
+	Misses []time.Duration
 }
 
 // RecordType is the type of a record, which indicates the different phases of a function.
@@ -36,6 +45,10 @@ type RecordType string
 const (
 	RecordColdStart RecordType = "coldstart"
 	RecordExec      RecordType = "exec"
+	// RecordCodeCacheHit and RecordCodeCacheMiss are the code preparing phase of a cold start,
+	// they are recorded by function because the flow is unknown when a process instance starts
+	RecordCodeCacheHit  RecordType = "codecache-hit"
+	RecordCodeCacheMiss RecordType = "codecache-miss"
 )
 
 type record struct {
@@ -72,6 +85,8 @@ func newCollector(workflow string) {
 		wf:      workflow,
 		ch:      make(chan *record, 100),
 		records: map[string]*store.Object{},
+
+		codePrepares: map[string]*CodePrepare{},
 	}
 }
 
@@ -106,6 +121,10 @@ func (c *Collector) startCollector() {
 		case <-c.ctx.Done():
 			return
 		case r := <-c.ch:
+			if r.t == RecordCodeCacheHit || r.t == RecordCodeCacheMiss {
+				c.recordCodePrepare(r)
+				continue
+			}
 			var obj *store.Object
 			obj, ok := c.records[r.flow]
 			if !ok {
@@ -144,6 +163,36 @@ func (c *Collector) startCollector() {
 	}
 }
 
+// recordCodePrepare records the code preparing phase of the function
+func (c *Collector) recordCodePrepare(r *record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp, ok := c.codePrepares[r.fn]
+	if !ok {
+		cp = &CodePrepare{}
+		c.codePrepares[r.fn] = cp
+	}
+	if r.t == RecordCodeCacheHit {
+		cp.Hits = append(cp.Hits, r.d)
+	} else {
+		cp.Misses = append(cp.Misses, r.d)
+	}
+}
+
+// GetCodePrepare returns a copy of the code preparing records of the function since the last publish
+func (c *Collector) GetCodePrepare(fn string) CodePrepare {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp, ok := c.codePrepares[fn]
+	if !ok {
+		return CodePrepare{}
+	}
+	return CodePrepare{
+		Hits:   append([]time.Duration{}, cp.Hits...),
+		Misses: append([]time.Duration{}, cp.Misses...),
+	}
+}
+
 // publish sends thr current metrics to the prediction model
 func (c *Collector) publish(d time.Duration) {
 	ticker := time.NewTicker(d)
@@ -155,6 +204,10 @@ func (c *Collector) publish(d time.Duration) {
 		case <-ticker.C:
 			// c.printMockdata()
 			records := c.fetchAndClearRecords()
+			for fn, cp := range c.fetchAndClearCodePrepares() {
+				zap.S().Infow("function code prepare", "function", fn, "hits", len(cp.Hits), "misses", len(cp.Misses),
+					"avgHit", avg(cp.Hits), "avgMiss", avg(cp.Misses))
+			}
 			err := predictmodel.GetPredictModelManager().PatchRecords(records)
 			if err != nil {
 				zap.S().Error("failed to patch records to prediction model manager", err)
@@ -171,6 +224,14 @@ func (c *Collector) fetchAndClearRecords() map[string]*store.Object {
 	return records
 }
 
+func (c *Collector) fetchAndClearCodePrepares() map[string]*CodePrepare {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	codePrepares := c.codePrepares
+	c.codePrepares = make(map[string]*CodePrepare)
+	return codePrepares
+}
+
 // NOTE: Test help function for watching runtime status.
 func (c *Collector) PrintMockdata() {
 	fmt.Println("=======================COLLECTOR==========================")
@@ -186,6 +247,9 @@ func (c *Collector) PrintMockdata() {
 		}
 		fmt.Println(key, "avg coldstart:", avgColdStart)
 	}
+	for fn, cp := range c.codePrepares {
+		fmt.Printf("function: %v code cache hits: %v misses: %v \n", fn, cp.Hits, cp.Misses)
+	}
 	fmt.Println("==========================================================")
 }
 
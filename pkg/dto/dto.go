@@ -1,5 +1,13 @@
 package dto
 
+import (
+	"time"
+)
+
+// TimeoutHeader is the http header to set the execution timeout of a workflow request, e.g. "30s".
+// When a request is forwarded to other Local Schedulers, the header carries the remaining time.
+const TimeoutHeader = "X-Tass-Timeout"
+
 type WorkflowRequest struct {
 	WorkflowName     string                 `json:"workflowName"`
 	UpstreamFlowName string                 `json:"upstreamFlowName"`
@@ -8,12 +16,76 @@ type WorkflowRequest struct {
 }
 
 type WorkflowResponse struct {
-	Success bool                   `json:"success"`
-	Message string                 `json:"message"`
-	Time    string                 `json:"time"`
-	Result  map[string]interface{} `json:"result"`
+	Success     bool                   `json:"success"`
+	Message     string                 `json:"message"`
+	Time        string                 `json:"time"`
+	Result      map[string]interface{} `json:"result"`
+	ExecutionID string                 `json:"executionId,omitempty"`
+	// ErrorClass is the class of the failure, "function" or "system", so that the caller retries it by its class
+	ErrorClass string `json:"errorClass,omitempty"`
 }
 
 type WorkFlowResult struct {
 	Success bool `json:"success"`
 }
+
+type WorkflowAsyncResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	Time        string `json:"time"`
+	ExecutionID string `json:"executionId"`
+}
+
+type ExecutionResponse struct {
+	Success      bool                   `json:"success"`
+	Message      string                 `json:"message"`
+	ExecutionID  string                 `json:"executionId"`
+	WorkflowName string                 `json:"workflowName"`
+	Status       string                 `json:"status"`
+	StartTime    time.Time              `json:"startTime"`
+	EndTime      *time.Time             `json:"endTime,omitempty"`
+	Input        map[string]interface{} `json:"input,omitempty"`
+	Flows        []FlowRecord           `json:"flows,omitempty"`
+	Branches     []BranchRecord         `json:"branches,omitempty"`
+	ColdStarts   []ColdStartRecord      `json:"coldStarts,omitempty"`
+	Result       map[string]interface{} `json:"result"`
+}
+
+type FlowRecord struct {
+	Flow      string    `json:"flow"`
+	Function  string    `json:"function"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type BranchRecord struct {
+	Flow      string `json:"flow"`
+	Condition string `json:"condition"`
+	Result    bool   `json:"result"`
+}
+
+type ColdStartRecord struct {
+	Flow     string        `json:"flow"`
+	Function string        `json:"function"`
+	Cost     time.Duration `json:"cost"`
+}
+
+type ExecutionListResponse struct {
+	Success    bool                `json:"success"`
+	Message    string              `json:"message"`
+	Executions []ExecutionResponse `json:"executions"`
+}
+
+type KeepAliveWindow struct {
+	PreWarm   string `json:"preWarm"`
+	KeepAlive string `json:"keepAlive"`
+	Adaptive  bool   `json:"adaptive"`
+	Samples   int    `json:"samples"`
+}
+
+type KeepAliveResponse struct {
+	Success   bool                       `json:"success"`
+	Message   string                     `json:"message"`
+	Functions map[string]KeepAliveWindow `json:"functions"`
+}
@@ -2,28 +2,63 @@ package env
 
 // FIXME: unify the naming rule of env vars
 const (
-	LSDSWait                = "LSDSWait"
-	RemoteCallPolicy        = "remoteCallpolicy"
-	Local                   = "local"
-	Port                    = "port"
-	WorkflowRuntimeFilePath = "workflowRuntimeFilePath"
-	WorkflowPath            = "workflowPath"
-	FuntionsPath            = "functionPath"
-	WorkflowName            = "workflowName"
-	SelfName                = "selfName"
-	Environment             = "Environment"
-	RedisIP                 = "RedisIp"
-	RedisPort               = "RedisPort"
-	RedisPassword           = "RedisPassword"
-	DefaultDb               = "DefaultDb"
-	TassFileRoot            = "/tass/"
-	Mock                    = "mock"
-	StaticMiddleware        = "StaticMiddleware"
-	QPSMiddleware           = "QPSMiddleware"
-	InstanceScorePolicy     = "instanceScorePolicy"
-	CreatePolicy            = "createPolicy"
-	TTL                     = "TTL"
-	TraceAgentHostPort      = "TraceAgentHostPort"
-	Prestart                = "prestart"
-	Collector               = "collector"
+	LSDSWait                 = "LSDSWait"
+	RemoteCallPolicy         = "remoteCallpolicy"
+	Local                    = "local"
+	Port                     = "port"
+	WorkflowRuntimeFilePath  = "workflowRuntimeFilePath"
+	WorkflowPath             = "workflowPath"
+	FuntionsPath             = "functionPath"
+	WorkflowName             = "workflowName"
+	SelfName                 = "selfName"
+	Environment              = "Environment"
+	RedisIP                  = "RedisIp"
+	RedisPort                = "RedisPort"
+	RedisPassword            = "RedisPassword"
+	DefaultDb                = "DefaultDb"
+	TassFileRoot             = "/tass/"
+	Mock                     = "mock"
+	StaticMiddleware         = "StaticMiddleware"
+	QPSMiddleware            = "QPSMiddleware"
+	InstanceScorePolicy      = "instanceScorePolicy"
+	CreatePolicy             = "createPolicy"
+	TTL                      = "TTL"
+	TraceAgentHostPort       = "TraceAgentHostPort"
+	Prestart                 = "prestart"
+	Collector                = "collector"
+	ExecutionTTL             = "executionTTL"
+	ExecutionCapacity        = "executionCapacity"
+	SyncExecutionCapacity    = "syncExecutionCapacity"
+	ExecutionHistory         = "executionHistory"
+	ExecutionHistoryCapacity = "executionHistoryCapacity"
+	CrashBackoff             = "crashBackoff"
+	CrashBackoffLimit        = "crashBackoffLimit"
+	MaxConcurrency           = "maxConcurrency"
+	QueueSize                = "queueSize"
+	QueueTimeout             = "queueTimeout"
+	ConcurrencyAutoscaler    = "concurrencyAutoscaler"
+	TargetConcurrency        = "targetConcurrency"
+	StableWindow             = "stableWindow"
+	PanicWindow              = "panicWindow"
+	PanicThreshold           = "panicThreshold"
+	FunctionConfigPath       = "functionConfigPath"
+	NodeCPU                  = "nodeCPU"
+	NodeMemory               = "nodeMemory"
+	CgroupRoot               = "cgroupRoot"
+	Sandbox                  = "sandbox"
+	CodeCacheDir             = "codeCacheDir"
+	CodeCacheSize            = "codeCacheSize"
+	CodeStore                = "codeStore"
+	CodeStorePath            = "codeStorePath"
+	CodeStoreURL             = "codeStoreURL"
+	S3Bucket                 = "s3Bucket"
+	S3Region                 = "s3Region"
+	S3AccessKey              = "s3AccessKey"
+	S3SecretKey              = "s3SecretKey"
+	ScaleDownPolicy          = "scaleDownPolicy"
+	DrainTimeout             = "drainTimeout"
+	MaxRequestsPerInstance   = "maxRequestsPerInstance"
+	MaxInstanceLifetime      = "maxInstanceLifetime"
+	RuntimePoolSize          = "runtimePoolSize"
+	KeepAlivePolicy          = "keepAlivePolicy"
 )
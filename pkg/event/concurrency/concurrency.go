@@ -0,0 +1,220 @@
+package concurrency
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/event"
+	"github.com/tass-io/scheduler/pkg/runner"
+	"github.com/tass-io/scheduler/pkg/runner/helper"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidTarget = errors.New("target concurrency must be positive")
+
+var (
+	ch *concurrencyHandler
+	// tick is the sampling cycle of the in-flight requests
+	tick = time.Second
+)
+
+// concurrencyHandler is an autoscaler like the Knative KPA.
+// It samples the in-flight requests of each function periodly and
+// decides the instance number by the averaged concurrency in the stable window,
+// when the load bursts, it enters panic mode and decides by the shorter panic window.
+type concurrencyHandler struct {
+	config     config
+	autoscaler map[string]*autoscaler
+}
+
+// config is the autoscaling settings
+type config struct {
+	// target is the expected in-flight requests of an instance
+	target         float64
+	stableWindow   time.Duration
+	panicWindow    time.Duration
+	panicThreshold float64
+}
+
+var _ event.Handler = &concurrencyHandler{}
+
+// Init registers the concurrency handler in front of the metrics handler,
+// so that the autoscaler decision overrides the qps and ttl decisions
+func Init() {
+	ch = newConcurrencyHandler()
+	event.Register(event.ConcurrencySource, ch, 1, false)
+}
+
+// newConcurrencyHandler returns a new concurrency handler with the settings from the flags
+func newConcurrencyHandler() *concurrencyHandler {
+	return &concurrencyHandler{
+		config: config{
+			target:         viper.GetFloat64(env.TargetConcurrency),
+			stableWindow:   viper.GetDuration(env.StableWindow),
+			panicWindow:    viper.GetDuration(env.PanicWindow),
+			panicThreshold: viper.GetFloat64(env.PanicThreshold),
+		},
+		autoscaler: make(map[string]*autoscaler),
+	}
+}
+
+// noone should use it, because the concurrency handler pulls the runner periodly
+func (handler *concurrencyHandler) AddEvent(e event.ScheduleEvent) {
+	zap.S().Panic("do not use ConcurrencyHandler.AddEvent")
+}
+
+// GetSource returns ConcurrencySource
+func (handler *concurrencyHandler) GetSource() event.Source {
+	return event.ConcurrencySource
+}
+
+// Start starts a Concurrency EventHandler.
+// It pulls the load of the functions from the master runner every tick,
+// and sends an event to ScheduleHandler when the target of a function changes.
+func (handler *concurrencyHandler) Start() error {
+	if handler.config.target <= 0 {
+		return ErrInvalidTarget
+	}
+	go func() {
+		// note that only the function scheduler implements both runner.Runner & runner.ConcurrencyStatistics
+		statistics, ok := helper.GetMasterRunner().(runner.ConcurrencyStatistics)
+		if !ok {
+			zap.S().Errorw("master runner not implement ConcurrencyStatistics")
+			return
+		}
+		for {
+			time.Sleep(tick)
+			handler.sync(time.Now(), statistics.ConcurrencyStats())
+		}
+	}()
+	return nil
+}
+
+// sync records the load of the functions and sends the changed targets
+func (handler *concurrencyHandler) sync(now time.Time, stats runner.ConcurrencyStatus) {
+	zap.S().Debugw("concurrency event SYNC", "stats", stats)
+	for functionName, load := range stats {
+		as, existed := handler.autoscaler[functionName]
+		if !existed {
+			as = newAutoscaler()
+			handler.autoscaler[functionName] = as
+		}
+		target := as.decide(now, load, handler.config)
+		// the function scales up only if the target exceeds the running instances,
+		// otherwise the target is an upper bound so that the other sources can still scale it down
+		trend := event.Decrease
+		if target > load.Instances {
+			trend = event.Increase
+		}
+		if target == as.sent && trend == as.trend {
+			continue
+		}
+		as.sent, as.trend = target, trend
+		e := event.ScheduleEvent{
+			FunctionName: functionName,
+			Target:       target,
+			Trend:        trend,
+			Source:       event.ConcurrencySource,
+		}
+		zap.S().Debugw("concurrency add event", "event", e, "panic", as.panicking(now))
+		event.GetHandlerBySource(event.ScheduleSource).AddEvent(e)
+	}
+}
+
+// sample is the observed concurrency at a moment
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// autoscaler records the samples and the decision of a function
+type autoscaler struct {
+	samples []sample
+	// panicUntil is the time when the panic mode ends
+	panicUntil time.Time
+	// panicTarget is the max target in the panic mode, the function never scales down in panic mode
+	panicTarget int
+	// target is the latest decision
+	target int
+	// sent and trend are the target and the trend of the latest event
+	sent  int
+	trend event.Trend
+}
+
+// newAutoscaler returns an autoscaler for a function
+func newAutoscaler() *autoscaler {
+	return &autoscaler{
+		samples: []sample{},
+	}
+}
+
+// decide records the load as a new sample and returns the expected instance number.
+// The target never goes below the minimum instances of the function, and it keeps at least one instance
+// if the function has any, because scaling to zero is left to the TTL manager and its keep-alive window
+func (as *autoscaler) decide(now time.Time, load runner.Concurrency, c config) int {
+	as.record(now, float64(load.InFlight+load.Queued), c.stableWindow)
+	desiredStable := int(math.Ceil(as.average(now, c.stableWindow) / c.target))
+	desiredPanic := int(math.Ceil(as.average(now, c.panicWindow) / c.target))
+
+	ready := load.Instances
+	if ready < 1 {
+		ready = 1
+	}
+	if float64(desiredPanic)/float64(ready) >= c.panicThreshold {
+		if !as.panicking(now) {
+			zap.S().Infow("concurrency autoscaler enters panic mode", "desired", desiredPanic, "ready", ready)
+		}
+		// the panic mode lasts for a stable window after the latest burst
+		as.panicUntil = now.Add(c.stableWindow)
+	}
+	if as.panicking(now) {
+		if desiredPanic > as.panicTarget {
+			as.panicTarget = desiredPanic
+		}
+		as.target = as.panicTarget
+	} else {
+		as.panicTarget = 0
+		as.target = desiredStable
+	}
+	floor := load.Minimum
+	if floor < 1 && load.Instances > 0 {
+		floor = 1
+	}
+	if as.target < floor {
+		as.target = floor
+	}
+	return as.target
+}
+
+// panicking returns whether the autoscaler is in panic mode
+func (as *autoscaler) panicking(now time.Time) bool {
+	return now.Before(as.panicUntil)
+}
+
+// record appends a sample and drops the samples out of the window
+func (as *autoscaler) record(now time.Time, value float64, window time.Duration) {
+	as.samples = append(as.samples, sample{at: now, value: value})
+	expired := 0
+	for expired < len(as.samples) && now.Sub(as.samples[expired].at) > window {
+		expired++
+	}
+	as.samples = as.samples[expired:]
+}
+
+// average returns the averaged concurrency of the samples in the window
+func (as *autoscaler) average(now time.Time, window time.Duration) float64 {
+	sum, n := 0.0, 0
+	for _, s := range as.samples {
+		if now.Sub(s.at) <= window {
+			sum += s.value
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
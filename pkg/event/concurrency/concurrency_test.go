@@ -0,0 +1,101 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tass-io/scheduler/pkg/event"
+	"github.com/tass-io/scheduler/pkg/runner"
+	_ "github.com/tass-io/scheduler/pkg/utils/log"
+)
+
+// fakeScheduleHandler records the events sent to the schedule handler
+type fakeScheduleHandler struct {
+	events []event.ScheduleEvent
+}
+
+func (h *fakeScheduleHandler) AddEvent(e event.ScheduleEvent) {
+	h.events = append(h.events, e)
+}
+
+func (h *fakeScheduleHandler) GetSource() event.Source {
+	return event.ScheduleSource
+}
+
+func (h *fakeScheduleHandler) Start() error {
+	return nil
+}
+
+func TestAutoscaler(t *testing.T) {
+	c := config{
+		target:         10,
+		stableWindow:   60 * time.Second,
+		panicWindow:    6 * time.Second,
+		panicThreshold: 2,
+	}
+	start := time.Now()
+
+	Convey("test autoscaler decides by the stable window", t, func() {
+		as := newAutoscaler()
+		for i := 0; i < 10; i++ {
+			as.decide(start.Add(time.Duration(i)*time.Second), runner.Concurrency{Instances: 2, InFlight: 15}, c)
+		}
+		So(as.target, ShouldEqual, 2)
+		So(as.panicking(start.Add(10*time.Second)), ShouldBeFalse)
+
+		// the samples out of the stable window are dropped
+		target := as.decide(start.Add(100*time.Second), runner.Concurrency{Instances: 2, InFlight: 5}, c)
+		So(target, ShouldEqual, 1)
+		So(len(as.samples), ShouldEqual, 1)
+	})
+
+	Convey("test autoscaler enters panic mode on bursts and never scales down in panic", t, func() {
+		as := newAutoscaler()
+		for i := 0; i < 60; i++ {
+			as.decide(start.Add(time.Duration(i)*time.Second), runner.Concurrency{Instances: 1, InFlight: 5}, c)
+		}
+		So(as.target, ShouldEqual, 1)
+
+		// the burst is hidden in the stable window but obvious in the panic window
+		now := start.Add(60 * time.Second)
+		target := as.decide(now, runner.Concurrency{Instances: 1, InFlight: 10, Queued: 130}, c)
+		So(as.panicking(now), ShouldBeTrue)
+		So(target, ShouldEqual, 3)
+
+		now = now.Add(time.Second)
+		target = as.decide(now, runner.Concurrency{Instances: 3, InFlight: 10}, c)
+		So(target, ShouldEqual, 3)
+
+		// the panic mode ends after a stable window without bursts
+		now = now.Add(61 * time.Second)
+		target = as.decide(now, runner.Concurrency{Instances: 3, InFlight: 10}, c)
+		So(as.panicking(now), ShouldBeFalse)
+		So(target, ShouldEqual, 1)
+	})
+
+	Convey("test concurrency handler sends events only when the target changes", t, func() {
+		handler := &fakeScheduleHandler{}
+		event.Register(event.ScheduleSource, handler, 1, true)
+		ch := newConcurrencyHandler()
+		ch.config = c
+		ch.sync(start, runner.ConcurrencyStatus{"a": {Instances: 0, Queued: 1}})
+		ch.sync(start.Add(time.Second), runner.ConcurrencyStatus{"a": {Instances: 1, InFlight: 1}})
+		// the idle function keeps its last instance, the TTL manager scales it to zero
+		ch.sync(start.Add(2*time.Minute), runner.ConcurrencyStatus{"a": {Instances: 1}})
+		ch.sync(start.Add(3*time.Minute), runner.ConcurrencyStatus{"a": {Instances: 0}})
+		So(handler.events, ShouldResemble, []event.ScheduleEvent{
+			{FunctionName: "a", Target: 1, Trend: event.Increase, Source: event.ConcurrencySource},
+			{FunctionName: "a", Target: 1, Trend: event.Decrease, Source: event.ConcurrencySource},
+			{FunctionName: "a", Target: 0, Trend: event.Decrease, Source: event.ConcurrencySource},
+		})
+	})
+
+	Convey("test autoscaler keeps the minimum instances", t, func() {
+		as := newAutoscaler()
+		target := as.decide(start, runner.Concurrency{Instances: 3, Minimum: 2}, c)
+		So(target, ShouldEqual, 2)
+		target = as.decide(start, runner.Concurrency{Instances: 0, Minimum: 0}, c)
+		So(target, ShouldEqual, 0)
+	})
+}
@@ -1,11 +1,17 @@
 package init
 
 import (
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/event/concurrency"
 	"github.com/tass-io/scheduler/pkg/event/metrics"
 	"github.com/tass-io/scheduler/pkg/event/schedule"
 )
 
 func Init() {
 	schedule.Init()
+	if viper.GetBool(env.ConcurrencyAutoscaler) {
+		concurrency.Init()
+	}
 	metrics.Init()
 }
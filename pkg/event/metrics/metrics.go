@@ -125,7 +125,7 @@ func (handler *metricsHandler) decide(functionName string) {
 
 	target := 0
 	trend := event.None
-	// the target of ttl is always Decrease
+	// the target of ttl is Decrease except the pre-warm of the histogram keep-alive policy
 	if qpsEvent.Trend == event.Increase {
 		// when qps is Increase and ttl is Decrease, take the higher Target
 		if ttlEvent.Target > qpsEvent.Target {
@@ -21,8 +21,9 @@ func (f *fakeScheduler) Refresh(functionName string, target int) {
 	f.stats[functionName] = target
 }
 
-func (f *fakeScheduler) ColdStartDone(functionName string) {
+func (f *fakeScheduler) ColdStartDone(functionName string) error {
 	zap.S().Debugw("fake scheduler cold start", "functionName", functionName)
+	return nil
 }
 
 func (f *fakeScheduler) NewInstanceSetIfNotExist(functionName string) {}
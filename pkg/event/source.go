@@ -20,4 +20,7 @@ const (
 	MetricsSource  Source = "Metrics"
 	QPSSource      Source = "QPS"
 	TTLSource      Source = "TTL"
+	// ConcurrencySource is the source of the concurrency autoscaler,
+	// it sends events to ScheduleHandler directly and overrides the metrics decision
+	ConcurrencySource Source = "Concurrency"
 )
@@ -0,0 +1,262 @@
+package execution
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"go.uber.org/zap"
+)
+
+var (
+	store *Store
+	once  = &sync.Once{}
+
+	ErrExecutionNotFound = errors.New("execution not found")
+	ErrStoreFull         = errors.New("execution store is full")
+)
+
+// Status is the status of a workflow execution
+type Status string
+
+const (
+	Running   Status = "Running"
+	Succeeded Status = "Succeeded"
+	Failed    Status = "Failed"
+)
+
+// Execution records a workflow invocation, the Flows visited, and its final result
+type Execution struct {
+	ID           string                 `json:"id"`
+	WorkflowName string                 `json:"workflowName"`
+	Async        bool                   `json:"async"`
+	Status       Status                 `json:"status"`
+	StartTime    time.Time              `json:"startTime"`
+	EndTime      time.Time              `json:"endTime"`
+	Input        map[string]interface{} `json:"input"`
+	Flows        []FlowRecord           `json:"flows"`
+	Branches     []BranchRecord         `json:"branches"`
+	ColdStarts   []ColdStartRecord      `json:"coldStarts"`
+	Result       map[string]interface{} `json:"result"`
+	Message      string                 `json:"message"`
+}
+
+// finished returns whether the execution has completed, no matter succeeded or failed
+func (e *Execution) finished() bool {
+	return e.Status != Running
+}
+
+// copy returns a copy of the execution, the records are copied so that they can be read without the lock
+func (e *Execution) copy() Execution {
+	c := *e
+	c.Flows = append([]FlowRecord{}, e.Flows...)
+	c.Branches = append([]BranchRecord{}, e.Branches...)
+	c.ColdStarts = append([]ColdStartRecord{}, e.ColdStarts...)
+	return c
+}
+
+// Store is a bounded in-memory store for executions.
+// The async and the sync executions have their own capacities, so the sync invocations never take
+// the room of the async ones. A finished execution is retained for ttl, and when the store reaches
+// its capacity, the oldest finished executions are evicted first.
+// The finished executions are also saved in the History, so they can be queried after eviction.
+type Store struct {
+	sync.Locker
+	capacity     int
+	syncCapacity int
+	// running and runningSync are the numbers of the running async and sync executions
+	running     int
+	runningSync int
+	ttl         time.Duration
+	executions  map[string]*Execution
+	// order records the execution ids in increasing order of creation
+	order   []string
+	history History
+}
+
+// GetStore returns the execution store, it's lazily initialized by the startup parameters
+func GetStore() *Store {
+	once.Do(func() {
+		store = NewStore(viper.GetInt(env.ExecutionCapacity), viper.GetInt(env.SyncExecutionCapacity),
+			viper.GetDuration(env.ExecutionTTL), newHistory(viper.GetString(env.ExecutionHistory)))
+	})
+	return store
+}
+
+// NewStore returns a new execution store with the capacities of the async and the sync executions and the ttl,
+// the finished executions are not persisted if the history is nil
+func NewStore(capacity int, syncCapacity int, ttl time.Duration, history History) *Store {
+	return &Store{
+		Locker:       &sync.Mutex{},
+		capacity:     capacity,
+		syncCapacity: syncCapacity,
+		ttl:          ttl,
+		executions:   make(map[string]*Execution, capacity+syncCapacity),
+		order:        []string{},
+		history:      history,
+	}
+}
+
+// Create records a new running async execution for the workflow with its input and returns its id,
+// if the store is full of running async executions, it returns an ErrStoreFull error
+func (s *Store) Create(workflowName string, input map[string]interface{}) (string, error) {
+	return s.create(workflowName, input, true)
+}
+
+// CreateSync records a new running sync execution like Create, but within the capacity of the sync executions
+func (s *Store) CreateSync(workflowName string, input map[string]interface{}) (string, error) {
+	return s.create(workflowName, input, false)
+}
+
+func (s *Store) create(workflowName string, input map[string]interface{}, async bool) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.evict(1)
+	running, capacity := &s.running, s.capacity
+	if !async {
+		running, capacity = &s.runningSync, s.syncCapacity
+	}
+	if *running >= capacity || len(s.executions) >= s.capacity+s.syncCapacity {
+		zap.S().Warnw("execution store is full", "async", async, "capacity", capacity)
+		return "", ErrStoreFull
+	}
+	*running++
+	id := xid.New().String()
+	s.executions[id] = &Execution{
+		ID:           id,
+		WorkflowName: workflowName,
+		Async:        async,
+		Status:       Running,
+		StartTime:    time.Now(),
+		Input:        input,
+	}
+	s.order = append(s.order, id)
+	return id, nil
+}
+
+// Finish records the final result of the execution and saves it in the history
+func (s *Store) Finish(id string, result map[string]interface{}, err error) {
+	s.Lock()
+	e, existed := s.executions[id]
+	if !existed {
+		s.Unlock()
+		zap.S().Warnw("finish an execution not found", "id", id)
+		return
+	}
+	if e.finished() {
+		s.Unlock()
+		zap.S().Warnw("finish an execution twice", "id", id)
+		return
+	}
+	if e.Async {
+		s.running--
+	} else {
+		s.runningSync--
+	}
+	e.EndTime = time.Now()
+	if err != nil {
+		e.Status = Failed
+		e.Message = err.Error()
+	} else {
+		e.Status = Succeeded
+		e.Result = result
+	}
+	finished := e.copy()
+	s.Unlock()
+
+	if s.history == nil {
+		return
+	}
+	if err := s.history.Save(&finished); err != nil {
+		zap.S().Errorw("save execution history error", "id", id, "err", err)
+	}
+}
+
+// Get returns a copy of the execution by the given id,
+// it queries the history if the execution is not in memory
+func (s *Store) Get(id string) (Execution, error) {
+	s.Lock()
+	s.evict(0)
+	e, existed := s.executions[id]
+	if existed {
+		defer s.Unlock()
+		return e.copy(), nil
+	}
+	s.Unlock()
+
+	if s.history == nil {
+		return Execution{}, ErrExecutionNotFound
+	}
+	return s.history.Get(id)
+}
+
+// List returns the executions filtered by the workflow name and the status in decreasing order of start time,
+// the empty filters match all executions
+func (s *Store) List(workflowName string, status Status) ([]Execution, error) {
+	executions := []Execution{}
+	ids := map[string]bool{}
+	s.Lock()
+	s.evict(0)
+	for _, id := range s.order {
+		e := s.executions[id]
+		if match(e, workflowName, status) {
+			executions = append(executions, e.copy())
+			ids[id] = true
+		}
+	}
+	s.Unlock()
+
+	if s.history != nil {
+		history, err := s.history.List(workflowName, status)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range history {
+			if !ids[e.ID] {
+				executions = append(executions, e)
+			}
+		}
+	}
+	sort.Slice(executions, func(i, j int) bool {
+		return executions[i].StartTime.After(executions[j].StartTime)
+	})
+	return executions, nil
+}
+
+// match returns whether the execution matches the filters, the empty filters match all executions
+func match(e *Execution, workflowName string, status Status) bool {
+	return (workflowName == "" || e.WorkflowName == workflowName) && (status == "" || e.Status == status)
+}
+
+// update applies f to the execution under the lock, it does nothing if the execution is not found
+func (s *Store) update(id string, f func(e *Execution)) {
+	s.Lock()
+	defer s.Unlock()
+	if e, existed := s.executions[id]; existed {
+		f(e)
+	}
+}
+
+// evict removes the expired executions, and then removes the oldest finished executions
+// until the store has room for n (param1) more executions. Running executions are never evicted.
+// Only Create makes room, so reading an execution never evicts the ones unexpired.
+// The caller must hold the lock.
+func (s *Store) evict(n int) {
+	now := time.Now()
+	overflow := len(s.executions) - s.capacity - s.syncCapacity + n
+	kept := make([]string, 0, len(s.order))
+	for _, id := range s.order {
+		e := s.executions[id]
+		if e.finished() && (now.Sub(e.EndTime) > s.ttl || overflow > 0) {
+			delete(s.executions, id)
+			overflow--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
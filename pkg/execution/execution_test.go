@@ -0,0 +1,147 @@
+package execution
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStore(t *testing.T) {
+	Convey("test execution store", t, func() {
+		Convey("finish and get an execution", func() {
+			s := NewStore(10, 0, time.Minute, nil)
+			id, err := s.Create("simple", nil)
+			So(err, ShouldBeNil)
+			e, err := s.Get(id)
+			So(err, ShouldBeNil)
+			So(e.Status, ShouldEqual, Running)
+			s.Finish(id, map[string]interface{}{"a": "b"}, nil)
+			e, err = s.Get(id)
+			So(err, ShouldBeNil)
+			So(e.Status, ShouldEqual, Succeeded)
+			So(e.Result, ShouldResemble, map[string]interface{}{"a": "b"})
+
+			failed, _ := s.Create("simple", nil)
+			s.Finish(failed, nil, errors.New("boom"))
+			e, _ = s.Get(failed)
+			So(e.Status, ShouldEqual, Failed)
+			So(e.Message, ShouldEqual, "boom")
+		})
+		Convey("evict the oldest finished execution when full", func() {
+			s := NewStore(2, 0, time.Minute, nil)
+			first, _ := s.Create("simple", nil)
+			second, _ := s.Create("simple", nil)
+			_, err := s.Create("simple", nil)
+			So(err, ShouldEqual, ErrStoreFull)
+			s.Finish(first, nil, nil)
+			// polling the store at the capacity doesn't evict the finished execution
+			_, err = s.Get(first)
+			So(err, ShouldBeNil)
+			executions, _ := s.List("", "")
+			So(len(executions), ShouldEqual, 2)
+			third, err := s.Create("simple", nil)
+			So(err, ShouldBeNil)
+			_, err = s.Get(first)
+			So(err, ShouldEqual, ErrExecutionNotFound)
+			_, err = s.Get(second)
+			So(err, ShouldBeNil)
+			_, err = s.Get(third)
+			So(err, ShouldBeNil)
+		})
+		Convey("sync executions don't take the room of async ones", func() {
+			s := NewStore(1, 1, time.Minute, nil)
+			_, err := s.CreateSync("simple", nil)
+			So(err, ShouldBeNil)
+			_, err = s.CreateSync("simple", nil)
+			So(err, ShouldEqual, ErrStoreFull)
+			id, err := s.Create("simple", nil)
+			So(err, ShouldBeNil)
+			e, _ := s.Get(id)
+			So(e.Async, ShouldBeTrue)
+			_, err = s.Create("simple", nil)
+			So(err, ShouldEqual, ErrStoreFull)
+			s.Finish(id, nil, nil)
+			_, err = s.Create("simple", nil)
+			So(err, ShouldBeNil)
+		})
+		Convey("evict expired executions", func() {
+			s := NewStore(10, 0, 10*time.Millisecond, nil)
+			id, _ := s.Create("simple", nil)
+			s.Finish(id, nil, nil)
+			time.Sleep(20 * time.Millisecond)
+			_, err := s.Get(id)
+			So(err, ShouldEqual, ErrExecutionNotFound)
+		})
+	})
+}
+
+func TestHistory(t *testing.T) {
+	Convey("test execution records and the local history", t, func() {
+		dir, err := ioutil.TempDir("", "executions")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		h := NewLocalHistory(dir, 2)
+		s := NewStore(1, 0, time.Minute, h)
+
+		id, err := s.Create("simple", map[string]interface{}{"a": "b"})
+		So(err, ShouldBeNil)
+		r := GetRecorder(WithRecorder(context.Background(), s.Recorder(id)))
+		index := r.FlowStart("start", "fn_start")
+		r.Branch("start", "root", true)
+		r.ColdStart("start", "fn_start", time.Second)
+		r.FlowEnd(index, errors.New("boom"))
+		s.Finish(id, nil, errors.New("boom"))
+
+		// the first execution is evicted from memory when the second one is created
+		second, _ := s.Create("other", nil)
+		s.Finish(second, map[string]interface{}{"c": "d"}, nil)
+		e, err := s.Get(id)
+		So(err, ShouldBeNil)
+		So(e.Status, ShouldEqual, Failed)
+		So(e.Input, ShouldResemble, map[string]interface{}{"a": "b"})
+		So(len(e.Flows), ShouldEqual, 1)
+		So(e.Flows[0].Function, ShouldEqual, "fn_start")
+		So(e.Flows[0].Error, ShouldEqual, "boom")
+		So(e.Branches, ShouldResemble, []BranchRecord{{Flow: "start", Condition: "root", Result: true}})
+		So(e.ColdStarts, ShouldResemble, []ColdStartRecord{{Flow: "start", Function: "fn_start", Cost: time.Second}})
+
+		executions, err := s.List("", "")
+		So(err, ShouldBeNil)
+		So(len(executions), ShouldEqual, 2)
+		So(executions[0].ID, ShouldEqual, second)
+		executions, _ = s.List("simple", Failed)
+		So(len(executions), ShouldEqual, 1)
+		executions, _ = s.List("simple", Succeeded)
+		So(len(executions), ShouldEqual, 0)
+
+		// the oldest execution is removed from the history over the capacity
+		third, _ := s.Create("simple", nil)
+		s.Finish(third, nil, nil)
+		_, err = s.Get(id)
+		So(err, ShouldEqual, ErrExecutionNotFound)
+		_, err = s.Get("../escape")
+		So(err, ShouldEqual, ErrExecutionNotFound)
+
+		// the executions are written in the background and indexed again after restarting
+		h.(*localHistory).flush()
+		restarted := NewLocalHistory(dir, 2)
+		executions, err = restarted.List("other", Succeeded)
+		So(err, ShouldBeNil)
+		So(len(executions), ShouldEqual, 1)
+		So(executions[0].Result, ShouldResemble, map[string]interface{}{"c": "d"})
+		_, err = restarted.Get(id)
+		So(err, ShouldEqual, ErrExecutionNotFound)
+		files, _ := ioutil.ReadDir(dir)
+		So(len(files), ShouldEqual, 2)
+
+		// nil recorder does nothing
+		var nilRecorder *Recorder
+		So(nilRecorder.FlowStart("start", "fn_start"), ShouldEqual, -1)
+		So(GetRecorder(context.Background()), ShouldBeNil)
+	})
+}
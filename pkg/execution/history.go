@@ -0,0 +1,291 @@
+package execution
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"go.uber.org/zap"
+)
+
+const (
+	// historyDir is the directory of the local history, an execution is saved as "<id>.json"
+	historyDir   = env.TassFileRoot + "executions/"
+	jsonSuffix   = ".json"
+	LocalHistory = "local"
+	NoHistory    = "none"
+)
+
+// History persists the finished executions
+type History interface {
+	// Save saves a finished execution
+	Save(e *Execution) error
+	// Get returns the execution by the given id, it returns ErrExecutionNotFound if not found
+	Get(id string) (Execution, error)
+	// List returns the executions filtered by the workflow name and the status, the empty filters match all
+	List(workflowName string, status Status) ([]Execution, error)
+}
+
+// histories records the History constructors by the name of the startup parameter
+var histories = map[string]func() History{
+	LocalHistory: func() History {
+		return NewLocalHistory(historyDir, viper.GetInt(env.ExecutionHistoryCapacity))
+	},
+	NoHistory: func() History {
+		return nil
+	},
+}
+
+// RegisterHistory registers a custom History which can be chosen by the startup parameter
+func RegisterHistory(name string, f func() History) {
+	histories[name] = f
+}
+
+// newHistory returns the History by the name, the local history is the default
+func newHistory(name string) History {
+	f, existed := histories[name]
+	if !existed {
+		zap.S().Warnw("execution history not found, use the local history instead", "history", name)
+		f = histories[LocalHistory]
+	}
+	return f()
+}
+
+// localHistory saves the executions as JSON files in a local directory,
+// when the number of files exceeds the capacity, the oldest executions are removed.
+// The summaries of the saved executions are indexed in memory, and the files are written in the background,
+// so saving an execution doesn't wait for the disk.
+type localHistory struct {
+	sync.Locker
+	dir      string
+	capacity int
+	// index records the saved executions in increasing order of id, it's loaded from the directory at first use
+	index  []summary
+	loaded bool
+	// pending records the executions saved but not written yet
+	pending map[string]*Execution
+	// ops is the file operations to do in order, the writer is notified by wake
+	ops     []fileOp
+	wake    chan struct{}
+	writing sync.WaitGroup
+	once    sync.Once
+}
+
+// summary is the fields of an execution to filter it without reading the file
+type summary struct {
+	id           string
+	workflowName string
+	status       Status
+}
+
+// fileOp writes the execution to its file, or removes the file when e is nil
+type fileOp struct {
+	id   string
+	e    *Execution
+	data []byte
+}
+
+var _ History = &localHistory{}
+
+// NewLocalHistory returns a History which saves the executions in the directory
+func NewLocalHistory(dir string, capacity int) History {
+	return &localHistory{
+		Locker:   &sync.Mutex{},
+		dir:      dir,
+		capacity: capacity,
+		pending:  make(map[string]*Execution),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Save indexes the execution and removes the oldest ones over the capacity, the files are updated in the background
+func (h *localHistory) Save(e *Execution) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	h.once.Do(func() {
+		go h.write()
+	})
+	h.Lock()
+	defer h.Unlock()
+	if err := h.load(); err != nil {
+		return err
+	}
+	// the ids are xids, so the lexicographical order is the creation order
+	i := sort.Search(len(h.index), func(i int) bool {
+		return h.index[i].id >= e.ID
+	})
+	s := summary{id: e.ID, workflowName: e.WorkflowName, status: e.Status}
+	if i < len(h.index) && h.index[i].id == e.ID {
+		h.index[i] = s
+	} else {
+		h.index = append(h.index, summary{})
+		copy(h.index[i+1:], h.index[i:])
+		h.index[i] = s
+	}
+	h.pending[e.ID] = e
+	h.push(fileOp{id: e.ID, e: e, data: data})
+	for len(h.index) > h.capacity {
+		delete(h.pending, h.index[0].id)
+		h.push(fileOp{id: h.index[0].id})
+		h.index = h.index[1:]
+	}
+	return nil
+}
+
+// Get returns the execution being written or reads it from its JSON file
+func (h *localHistory) Get(id string) (Execution, error) {
+	h.Lock()
+	if err := h.load(); err != nil {
+		h.Unlock()
+		return Execution{}, err
+	}
+	if e, existed := h.pending[id]; existed {
+		h.Unlock()
+		return e.copy(), nil
+	}
+	i := sort.Search(len(h.index), func(i int) bool {
+		return h.index[i].id >= id
+	})
+	existed := i < len(h.index) && h.index[i].id == id
+	h.Unlock()
+	if !existed {
+		return Execution{}, ErrExecutionNotFound
+	}
+	return h.read(id)
+}
+
+// List filters the executions by the index, and only reads the matched ones
+func (h *localHistory) List(workflowName string, status Status) ([]Execution, error) {
+	h.Lock()
+	if err := h.load(); err != nil {
+		h.Unlock()
+		return nil, err
+	}
+	executions := []Execution{}
+	ids := []string{}
+	for _, s := range h.index {
+		if (workflowName != "" && s.workflowName != workflowName) || (status != "" && s.status != status) {
+			continue
+		}
+		if e, existed := h.pending[s.id]; existed {
+			executions = append(executions, e.copy())
+		} else {
+			ids = append(ids, s.id)
+		}
+	}
+	h.Unlock()
+	for _, id := range ids {
+		e, err := h.read(id)
+		if err != nil {
+			zap.S().Warnw("read execution history error", "id", id, "err", err)
+			continue
+		}
+		executions = append(executions, e)
+	}
+	return executions, nil
+}
+
+// load indexes the executions saved in the directory once, the caller must hold the lock
+func (h *localHistory) load() error {
+	if h.loaded {
+		return nil
+	}
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ids := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), jsonSuffix) {
+			ids = append(ids, strings.TrimSuffix(file.Name(), jsonSuffix))
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		e, err := h.read(id)
+		if err != nil {
+			zap.S().Warnw("read execution history error", "id", id, "err", err)
+			continue
+		}
+		h.index = append(h.index, summary{id: id, workflowName: e.WorkflowName, status: e.Status})
+	}
+	h.loaded = true
+	return nil
+}
+
+// push queues a file operation and wakes up the writer, the caller must hold the lock
+func (h *localHistory) push(op fileOp) {
+	h.writing.Add(1)
+	h.ops = append(h.ops, op)
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// write does the queued file operations in order, it runs in the background
+func (h *localHistory) write() {
+	for range h.wake {
+		h.Lock()
+		ops := h.ops
+		h.ops = nil
+		h.Unlock()
+		for _, op := range ops {
+			h.do(op)
+			h.writing.Done()
+		}
+	}
+}
+
+// do does a file operation, the execution written is no longer pending
+func (h *localHistory) do(op fileOp) {
+	path := filepath.Join(h.dir, op.id+jsonSuffix)
+	if op.e == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			zap.S().Warnw("remove execution history error", "id", op.id, "err", err)
+		}
+		return
+	}
+	if err := os.MkdirAll(h.dir, os.ModePerm); err != nil {
+		zap.S().Errorw("create execution history directory error", "dir", h.dir, "err", err)
+	} else if err := ioutil.WriteFile(path, op.data, 0644); err != nil {
+		zap.S().Errorw("write execution history error", "id", op.id, "err", err)
+	}
+	h.Lock()
+	defer h.Unlock()
+	// the execution may be saved again while writing, then it's still pending
+	if h.pending[op.id] == op.e {
+		delete(h.pending, op.id)
+	}
+}
+
+// flush waits for the queued file operations to finish
+func (h *localHistory) flush() {
+	h.writing.Wait()
+}
+
+// read reads an execution from its JSON file
+func (h *localHistory) read(id string) (Execution, error) {
+	e := Execution{}
+	// the id comes from the http path, make sure it doesn't escape the directory
+	if filepath.Base(id) != id {
+		return e, ErrExecutionNotFound
+	}
+	data, err := ioutil.ReadFile(filepath.Join(h.dir, id+jsonSuffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return e, ErrExecutionNotFound
+		}
+		return e, err
+	}
+	err = json.Unmarshal(data, &e)
+	return e, err
+}
@@ -0,0 +1,103 @@
+package execution
+
+import (
+	"context"
+	"time"
+)
+
+// FlowRecord records a Flow visited in an execution
+type FlowRecord struct {
+	Flow      string    `json:"flow"`
+	Function  string    `json:"function"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// BranchRecord records a condition evaluated in a Switch Flow and the branch taken
+type BranchRecord struct {
+	Flow      string `json:"flow"`
+	Condition string `json:"condition"`
+	Result    bool   `json:"result"`
+}
+
+// ColdStartRecord records a cold start hit in an execution
+type ColdStartRecord struct {
+	Flow     string        `json:"flow"`
+	Function string        `json:"function"`
+	Cost     time.Duration `json:"cost"`
+}
+
+type recorderKey struct{}
+
+// Recorder records the details of a running execution into the store,
+// it's carried by the request context, all methods do nothing on a nil Recorder
+type Recorder struct {
+	store *Store
+	id    string
+}
+
+// Recorder returns the Recorder of the execution
+func (s *Store) Recorder(id string) *Recorder {
+	return &Recorder{store: s, id: id}
+}
+
+// WithRecorder returns a context carrying the Recorder
+func WithRecorder(parent context.Context, r *Recorder) context.Context {
+	return context.WithValue(parent, recorderKey{}, r)
+}
+
+// GetRecorder returns the Recorder in the context, it returns nil if the context has no Recorder
+func GetRecorder(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(recorderKey{}).(*Recorder)
+	return r
+}
+
+// FlowStart records that a Flow starts, it returns the index used by FlowEnd
+func (r *Recorder) FlowStart(flow string, function string) int {
+	if r == nil {
+		return -1
+	}
+	index := -1
+	r.store.update(r.id, func(e *Execution) {
+		e.Flows = append(e.Flows, FlowRecord{Flow: flow, Function: function, StartTime: time.Now()})
+		index = len(e.Flows) - 1
+	})
+	return index
+}
+
+// FlowEnd records that the Flow started at the index ends with the error
+func (r *Recorder) FlowEnd(index int, err error) {
+	if r == nil || index < 0 {
+		return
+	}
+	r.store.update(r.id, func(e *Execution) {
+		if index >= len(e.Flows) {
+			return
+		}
+		e.Flows[index].EndTime = time.Now()
+		if err != nil {
+			e.Flows[index].Error = err.Error()
+		}
+	})
+}
+
+// Branch records the result of a condition in a Switch Flow
+func (r *Recorder) Branch(flow string, condition string, result bool) {
+	if r == nil {
+		return
+	}
+	r.store.update(r.id, func(e *Execution) {
+		e.Branches = append(e.Branches, BranchRecord{Flow: flow, Condition: condition, Result: result})
+	})
+}
+
+// ColdStart records a cold start of the function
+func (r *Recorder) ColdStart(flow string, function string, cost time.Duration) {
+	if r == nil {
+		return
+	}
+	r.store.update(r.id, func(e *Execution) {
+		e.ColdStarts = append(e.ColdStarts, ColdStartRecord{Flow: flow, Function: function, Cost: cost})
+	})
+}
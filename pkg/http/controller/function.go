@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tass-io/scheduler/pkg/dto"
+	"github.com/tass-io/scheduler/pkg/runner"
+	"github.com/tass-io/scheduler/pkg/runner/helper"
+)
+
+// ListKeepAlive returns the keep-alive windows chosen for the functions, filtered by the query "function"
+func ListKeepAlive(c *gin.Context) {
+	// note that only the function scheduler chooses the keep-alive windows
+	statistics, ok := helper.GetMasterRunner().(runner.KeepAliveStatistics)
+	if !ok {
+		c.JSON(501, dto.KeepAliveResponse{
+			Success: false,
+			Message: "the runner doesn't keep the instances alive",
+		})
+		return
+	}
+	name := c.Query("function")
+	resp := dto.KeepAliveResponse{
+		Success:   true,
+		Message:   "ok",
+		Functions: map[string]dto.KeepAliveWindow{},
+	}
+	for functionName, window := range statistics.KeepAliveStats() {
+		if name != "" && name != functionName {
+			continue
+		}
+		resp.Functions[functionName] = dto.KeepAliveWindow{
+			PreWarm:   window.PreWarm.String(),
+			KeepAlive: window.KeepAlive.String(),
+			Adaptive:  window.Adaptive,
+			Samples:   window.Samples,
+		}
+	}
+	c.JSON(200, resp)
+}
@@ -1,13 +1,20 @@
 package controller
 
 import (
+	"context"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/opentracing/opentracing-go"
 	"github.com/tass-io/scheduler/pkg/dto"
+	"github.com/tass-io/scheduler/pkg/execution"
+	"github.com/tass-io/scheduler/pkg/predictmodel"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
 	"github.com/tass-io/scheduler/pkg/span"
 	"github.com/tass-io/scheduler/pkg/trace"
+	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
 	"github.com/tass-io/scheduler/pkg/workflow"
 	"go.uber.org/zap"
 )
@@ -27,44 +34,268 @@ func Invoke(c *gin.Context) {
 		})
 	}
 
-	// 2. record opentracing span
+	// 2. the request is cancelled when the client leaves or the deadline exceeds
+	ctx, cancel, err := withRequestTimeout(c.Request.Context(), c.Request.Header)
+	if err != nil {
+		c.JSON(400, dto.WorkflowResponse{
+			Success: false,
+			Time:    time.Since(start).String(),
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cancel()
+
+	// 3. record the execution history, the request still goes on when the store is full.
+	// The hops from other schedulers start at a Flow, they're recorded where the execution starts
+	var id string
+	if request.FlowName == "" {
+		id, err = execution.GetStore().CreateSync(request.WorkflowName, request.Parameters)
+		if err != nil {
+			zap.S().Warnw("invoke without execution record", "err", err)
+		} else {
+			ctx = execution.WithRecorder(ctx, execution.GetStore().Recorder(id))
+		}
+	}
+
+	// 4. record opentracing span
+	sp, root := newWorkflowSpan(c, &request)
+	sp.SetContext(ctx)
+	defer finishRootSpan(root)
+
+	// 5. invoke the busniess logic
+	result, err := workflow.GetManager().Invoke(sp, request.Parameters)
+	if id != "" {
+		execution.GetStore().Finish(id, result, err)
+	}
+	if err != nil {
+		code := 500
+		if err == workflow.ErrWorkflowTimeout {
+			code = 504
+		}
+		c.JSON(code, dto.WorkflowResponse{
+			Success:     false,
+			Time:        time.Since(start).String(),
+			Message:     err.Error(),
+			ExecutionID: id,
+			ErrorClass:  string(instance.ClassifyError(err)),
+		})
+		return
+	}
+	c.JSON(200, dto.WorkflowResponse{
+		Success:     true,
+		Time:        time.Since(start).String(),
+		Message:     "ok",
+		Result:      result,
+		ExecutionID: id,
+	})
+}
+
+// InvokeAsync is called when an asynchronous http request is received,
+// it records a new execution, returns the execution id immediately
+// and invokes the workflow in the background.
+// The status and the result can be queried by GetExecution.
+func InvokeAsync(c *gin.Context) {
+	var request dto.WorkflowRequest
+
+	start := time.Now()
+	if err := c.BindJSON(&request); err != nil {
+		zap.S().Errorw("invoke async bind json error", "err", err)
+		c.JSON(400, dto.WorkflowAsyncResponse{
+			Success: false,
+			Time:    time.Since(start).String(),
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// the execution outlives the http request, so it doesn't inherit the request context
+	ctx, cancel, err := withRequestTimeout(context.Background(), c.Request.Header)
+	if err != nil {
+		c.JSON(400, dto.WorkflowAsyncResponse{
+			Success: false,
+			Time:    time.Since(start).String(),
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id, err := execution.GetStore().Create(request.WorkflowName, request.Parameters)
+	if err != nil {
+		cancel()
+		c.JSON(503, dto.WorkflowAsyncResponse{
+			Success: false,
+			Time:    time.Since(start).String(),
+			Message: err.Error(),
+		})
+		return
+	}
+
+	sp, root := newWorkflowSpan(c, &request)
+	sp.SetContext(execution.WithRecorder(ctx, execution.GetStore().Recorder(id)))
+	go func() {
+		defer cancel()
+		defer finishRootSpan(root)
+		result, err := workflow.GetManager().Invoke(sp, request.Parameters)
+		if err != nil {
+			zap.S().Errorw("async execution error", "id", id, "err", err)
+		}
+		execution.GetStore().Finish(id, result, err)
+	}()
+
+	c.JSON(202, dto.WorkflowAsyncResponse{
+		Success:     true,
+		Time:        time.Since(start).String(),
+		Message:     "accepted",
+		ExecutionID: id,
+	})
+}
+
+// GetExecution returns the record of an execution, including the status, the Flows visited and the result
+func GetExecution(c *gin.Context) {
+	id := c.Param("id")
+	e, err := execution.GetStore().Get(id)
+	if err != nil {
+		code := 500
+		if err == execution.ErrExecutionNotFound {
+			code = 404
+		}
+		c.JSON(code, dto.ExecutionResponse{
+			Success:     false,
+			Message:     err.Error(),
+			ExecutionID: id,
+		})
+		return
+	}
+	c.JSON(200, newExecutionResponse(&e))
+}
+
+// ListExecutions returns the execution records filtered by the queries "workflow" and "status"
+func ListExecutions(c *gin.Context) {
+	executions, err := execution.GetStore().List(c.Query("workflow"), execution.Status(c.Query("status")))
+	if err != nil {
+		c.JSON(500, dto.ExecutionListResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	resp := dto.ExecutionListResponse{
+		Success:    true,
+		Message:    "ok",
+		Executions: make([]dto.ExecutionResponse, 0, len(executions)),
+	}
+	for i := range executions {
+		resp.Executions = append(resp.Executions, newExecutionResponse(&executions[i]))
+	}
+	c.JSON(200, resp)
+}
+
+// newExecutionResponse converts an execution record to the http response
+func newExecutionResponse(e *execution.Execution) dto.ExecutionResponse {
+	resp := dto.ExecutionResponse{
+		Success:      true,
+		Message:      e.Message,
+		ExecutionID:  e.ID,
+		WorkflowName: e.WorkflowName,
+		Status:       string(e.Status),
+		StartTime:    e.StartTime,
+		Input:        e.Input,
+		Result:       e.Result,
+	}
+	if !e.EndTime.IsZero() {
+		resp.EndTime = &e.EndTime
+	}
+	for _, f := range e.Flows {
+		resp.Flows = append(resp.Flows, dto.FlowRecord{
+			Flow:      f.Flow,
+			Function:  f.Function,
+			StartTime: f.StartTime,
+			EndTime:   f.EndTime,
+			Error:     f.Error,
+		})
+	}
+	for _, b := range e.Branches {
+		resp.Branches = append(resp.Branches, dto.BranchRecord{Flow: b.Flow, Condition: b.Condition, Result: b.Result})
+	}
+	for _, cs := range e.ColdStarts {
+		resp.ColdStarts = append(resp.ColdStarts, dto.ColdStartRecord{Flow: cs.Flow, Function: cs.Function, Cost: cs.Cost})
+	}
+	return resp
+}
+
+// GetWorkflowGraph renders the Workflow as a graph, the format is "dot" by default,
+// the prediction model is overlaid when the query "overlay" is true
+func GetWorkflowGraph(c *gin.Context) {
+	name := c.Param("name")
+	wf, existed, err := k8sutils.GetWorkflowByName(name)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if !existed {
+		c.String(404, workflow.ErrWorkflowNotFound.Error())
+		return
+	}
+	var model *predictmodel.Model
+	if overlay, _ := strconv.ParseBool(c.Query("overlay")); overlay {
+		if pm := predictmodel.GetPredictModelManager(); pm != nil && pm.GetWorkflowName() == name {
+			model = pm.GetModel()
+		} else {
+			zap.S().Warnw("no prediction model to overlay", "workflow", name)
+		}
+	}
+	graph, err := workflow.RenderGraph(wf, workflow.GraphFormat(c.DefaultQuery("format", string(workflow.DOT))), model)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	c.String(200, graph)
+}
+
+// withRequestTimeout returns a context with the timeout set by the TimeoutHeader,
+// if the header is not set, it returns a cancelable context of the parent
+func withRequestTimeout(parent context.Context, header http.Header) (context.Context, context.CancelFunc, error) {
+	raw := header.Get(dto.TimeoutHeader)
+	if raw == "" {
+		ctx, cancel := context.WithCancel(parent)
+		return ctx, cancel, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		zap.S().Errorw("invalid timeout header", "timeout", raw, "err", err)
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	return ctx, cancel, nil
+}
+
+// newWorkflowSpan extracts the span context from the request headers and returns the workflow span.
+// When the workflow executes the first Flow, it has no span context, so a new root span is started,
+// the caller should finish the returned root span by finishRootSpan.
+func newWorkflowSpan(c *gin.Context, request *dto.WorkflowRequest) (*span.Span, opentracing.Span) {
 	var root opentracing.Span
 	spanContext, err := trace.GetSpanContextFromHeaders(request.WorkflowName, c.Request.Header)
 	if err != nil {
 		if err == opentracing.ErrSpanContextNotFound {
-			// when the workflow executes the first Flow, it has no span context
 			root = opentracing.GlobalTracer().StartSpan(request.WorkflowName)
 			spanContext = root.Context()
 		} else {
 			zap.S().Errorw("trace get spanContext error", err)
 		}
 	}
-	defer func() {
-		if root != nil {
-			root.Finish()
-			zap.S().Debugw("root finish", "root", root)
-		}
-	}()
 	zap.S().Debugw("get spanContext", "context", spanContext)
 	// functionName can be found in the Workflow model
 	sp := span.NewSpan(request.WorkflowName, request.UpstreamFlowName, request.FlowName, "")
 	sp.SetRoot(spanContext)
 	sp.SetParent(spanContext)
+	return sp, root
+}
 
-	// 3. invoke the busniess logic
-	result, err := workflow.GetManager().Invoke(sp, request.Parameters)
-	if err != nil {
-		c.JSON(500, dto.WorkflowResponse{
-			Success: false,
-			Time:    time.Since(start).String(),
-			Message: err.Error(),
-		})
-		return
+// finishRootSpan finishes the root span if it's started by the local scheduler
+func finishRootSpan(root opentracing.Span) {
+	if root != nil {
+		root.Finish()
+		zap.S().Debugw("root finish", "root", root)
 	}
-	c.JSON(200, dto.WorkflowResponse{
-		Success: true,
-		Time:    time.Since(start).String(),
-		Message: "ok",
-		Result:  result,
-	})
 }
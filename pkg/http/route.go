@@ -6,6 +6,7 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tass-io/scheduler/pkg/dto"
 	"github.com/tass-io/scheduler/pkg/http/controller"
 )
 
@@ -13,7 +14,7 @@ import (
 func RegisterRoute(r *gin.Engine) {
 	r.Use(cors.New(cors.Config{
 		AllowMethods:     []string{"PUT", "POST", "GET", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
+		AllowHeaders:     []string{"Origin", "Content-Type", dto.TimeoutHeader},
 		AllowCredentials: true,
 		AllowOriginFunc: func(origin string) bool {
 			return true
@@ -29,6 +30,17 @@ func registerWorkflowHandler(r *gin.Engine) {
 	workflowRoute := v1.Group("/workflow")
 	{
 		workflowRoute.POST("/", controller.Invoke)
+		workflowRoute.POST("/async", controller.InvokeAsync)
+		workflowRoute.GET("/:name/graph", controller.GetWorkflowGraph)
+	}
+	executionRoute := v1.Group("/executions")
+	{
+		executionRoute.GET("", controller.ListExecutions)
+		executionRoute.GET("/:id", controller.GetExecution)
+	}
+	functionRoute := v1.Group("/functions")
+	{
+		functionRoute.GET("/keepalive", controller.ListKeepAlive)
 	}
 }
 
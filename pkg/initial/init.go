@@ -8,10 +8,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
 	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
 	"github.com/tass-io/scheduler/pkg/store"
 	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
 
@@ -36,32 +38,60 @@ var (
 	}
 )
 
+// sandbox is the sandbox settings passed by the scheduler, nil if the process is not sandboxed
+var sandbox *instance.Sandbox
+
 // functionInit moves function code to it's own directory,
 // redirects stdout and stderr to file and executes function code
 func functionInit(functionName string) {
-	codeBase64, err := store.Get(k8sutils.GetSelfNamespace(), functionName)
+	// the network namespace is unshared by the current thread, which executes the code later
+	runtime.LockOSThread()
+	var err error
+	sandbox, err = instance.SandboxFromEnv()
+	if err != nil {
+		zap.S().Panicw("get sandbox error", "err", err)
+	}
+	codeBase64, err := store.GetCodeStore().Get(k8sutils.GetSelfNamespace(), functionName)
 	if err != nil {
 		zap.S().Panicw("get function content error", "err", err)
 	}
 	codePrepareAndExec(codeBase64, functionName, viper.GetString(env.Environment))
 }
 
-// codePrepareAndExec prepares code and executes it
+// codePrepareAndExec prepares code and executes it,
+// the sandbox is entered after the code is prepared
 func codePrepareAndExec(code string, functionName string, environment string) {
 	codePrepare(code)
+	if sandbox.Has(instance.NetworkNamespace) {
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			zap.S().Panicw("unshare network namespace error", "err", err)
+		}
+	}
+	if err := sandbox.Enter(); err != nil {
+		zap.S().Panicw("enter sandbox error", "err", err)
+	}
 	codeExec(functionName, environment)
 }
 
+// workDirectory returns the directory of the process,
+// it's the private directory assigned by the scheduler if the process is sandboxed
+func workDirectory() string {
+	if sandbox != nil && sandbox.WritableDir != "" {
+		return sandbox.WritableDir
+	}
+	return fmt.Sprintf(env.TassFileRoot+"%d", os.Getpid())
+}
+
 // codePrepare decodes & unzips the code and places the code to the desired location
 // codePrepare is an expensive operation, because it needs to get code from remote storage center
 func codePrepare(code string) {
-	pid := os.Getpid()
-	directoryPath := fmt.Sprintf(env.TassFileRoot+"%d", pid)
+	directoryPath := workDirectory()
 	// clean up first
 	os.RemoveAll(directoryPath)
 	codePath := directoryPath + "/code"
 	codeZipPath := codePath + "/code.zip"
-	err := os.MkdirAll(directoryPath, 0777)
+	// the directory is private to the process, the code directory in it is still open to the sandbox user
+	err := os.MkdirAll(directoryPath, 0700)
 	if err != nil {
 		zap.S().Panicw("code prepare mkdir all error", "err", err)
 	}
@@ -96,8 +126,7 @@ func codePrepare(code string) {
 // codeExec executes the prepared code
 func codeExec(functionName string, environment string) {
 	// todo support customize cmd
-	pid := os.Getpid()
-	directoryPath := fmt.Sprintf(env.TassFileRoot+"%d", pid)
+	directoryPath := workDirectory()
 	codePath := directoryPath + "/code"
 	switch environment {
 	case "JavaScript":
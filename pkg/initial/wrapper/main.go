@@ -20,7 +20,7 @@ import (
 
 var (
 	sigtermChan      = make(chan os.Signal, 1)
-	w                = NewWrapper()
+	w                *Wrapper
 	closeChannelOnce = &sync.Once{}
 )
 
@@ -77,10 +77,15 @@ func NewWrapper() *Wrapper {
 	// 4 is the fd of response channel
 	producerFile := os.NewFile(uintptr(4), "pipe")
 	// the instruction that local scheduler runs the runtime is: main ${PLUGIN_PATH}
-	// so the value of os.Args[1] is the location of plugin.so
-	handler, err := loadPlugin(os.Args[1], "Handler")
-	if err != nil {
-		zap.S().Warnw("user code puglin load error", "err", err)
+	// so the value of os.Args[1] is the location of plugin.so,
+	// a pre-warmed runtime has no plugin path and loads the plugin by a control message later
+	var handler handlerFn
+	if len(os.Args) > 1 {
+		var err error
+		handler, err = loadPlugin(os.Args[1], "Handler")
+		if err != nil {
+			zap.S().Warnw("user code puglin load error", "err", err)
+		}
 	}
 	m := cmap.New()
 	wrapper := &Wrapper{
@@ -101,6 +106,11 @@ func (w *Wrapper) Start() {
 
 	for reqRaw := range reqChan {
 		req := reqRaw.(*instance.FunctionRequest)
+		if req.Control != nil {
+			// the control message is handled in order, so the requests after it see its effect
+			w.producer.GetChannel() <- w.control(*req)
+			continue
+		}
 		// do the invocation
 		go func() {
 			w.requestMap.Set(req.ID, "")
@@ -111,6 +121,32 @@ func (w *Wrapper) Start() {
 	}
 }
 
+// control handles the control message from the scheduler and returns the result
+func (w *Wrapper) control(request instance.FunctionRequest) instance.FunctionResponse {
+	if path := request.Control.LoadPlugin; path != "" {
+		if w.handler != nil {
+			return instance.FunctionResponse{
+				ID:     request.ID,
+				Result: map[string]interface{}{"err": "a plugin has been loaded"},
+			}
+		}
+		handler, err := loadPlugin(path, "Handler")
+		if err != nil {
+			zap.S().Warnw("user code puglin load error", "err", err)
+			return instance.FunctionResponse{
+				ID:     request.ID,
+				Result: map[string]interface{}{"err": err.Error()},
+			}
+		}
+		w.handler = handler
+		zap.S().Infow("user code plugin loaded", "path", path)
+	}
+	return instance.FunctionResponse{
+		ID:     request.ID,
+		Result: map[string]interface{}{},
+	}
+}
+
 // invoke invokes the requests and returns the response
 // todo implementation by go plugin
 func (w *Wrapper) invoke(request instance.FunctionRequest) (res instance.FunctionResponse) {
@@ -178,11 +214,6 @@ func init() {
 	zap.ReplaceGlobals(logger)
 	// let the sigtermChan receive SIGTERM signal
 	signal.Notify(sigtermChan, syscall.SIGTERM)
-	go func() {
-		<-sigtermChan
-		w.Shutdown()
-		w.handleTerminate()
-	}()
 }
 
 // handleTerminate checks the process status, and terminates when it is idle
@@ -208,11 +239,26 @@ func (w *Wrapper) handleTerminate() {
 }
 
 func main() {
-	currentUser, err := user.Current()
+	// the sandbox must be entered before the user code is loaded
+	sandbox, err := instance.SandboxFromEnv()
 	if err != nil {
-		zap.S().Warnw("unable to get current user: %s", err)
+		zap.S().Panicw("get sandbox error", "err", err)
+	}
+	if err := sandbox.Enter(); err != nil {
+		zap.S().Panicw("enter sandbox error", "err", err)
+	}
+	w = NewWrapper()
+	go func() {
+		<-sigtermChan
+		w.Shutdown()
+		w.handleTerminate()
+	}()
+	// the sandbox user may not exist in /etc/passwd
+	if currentUser, err := user.Current(); err != nil {
+		zap.S().Warnw("unable to get current user", "uid", os.Getuid(), "err", err)
+	} else {
+		zap.S().Infow("Hi", "user", currentUser.Name)
+		zap.S().Infow("run the binary user", "user", currentUser.Name)
 	}
-	zap.S().Infow("Hi", "user", currentUser.Name)
-	zap.S().Infow("run the binary user", "user", currentUser.Name)
 	w.Start()
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/tass-io/scheduler/pkg/collector"
 	"github.com/tass-io/scheduler/pkg/event"
 	"github.com/tass-io/scheduler/pkg/event/schedule"
+	"github.com/tass-io/scheduler/pkg/execution"
 	"github.com/tass-io/scheduler/pkg/middleware"
 	"github.com/tass-io/scheduler/pkg/runner/helper"
 	fnschedule "github.com/tass-io/scheduler/pkg/schedule"
@@ -86,9 +87,24 @@ func (cs *coldstartMiddleware) Handle(
 		schedule.GetScheduleHandlerIns().AddEvent(event)
 
 		// TODO: Now use notification directly, a policy is preferred here
-		// TODO: Cold Start error handling
-		fnschedule.GetScheduler().ColdStartDone(functionName)
-		collector.GetCollector().Record(upstream, flowName, functionName, collector.RecordColdStart, time.Since(start))
+		done := make(chan error, 1)
+		go func() {
+			done <- fnschedule.GetScheduler().ColdStartDone(functionName)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				// the instance can't be created locally, the lsds middleware forwards the request to other schedulers
+				zap.S().Warnw("cold start refused", "function", functionName, "err", err)
+				return nil, middleware.Next, nil
+			}
+		case <-sp.GetContext().Done():
+			zap.S().Warnw("cold start waiting context done", "function", functionName, "err", sp.GetContext().Err())
+			return nil, middleware.Error, sp.GetContext().Err()
+		}
+		cost := time.Since(start)
+		collector.GetCollector().Record(upstream, flowName, functionName, collector.RecordColdStart, cost)
+		execution.GetRecorder(sp.GetContext()).ColdStart(flowName, functionName, cost)
 	}
 
 	return nil, middleware.Next, nil
@@ -84,3 +84,8 @@ func (pm *Manager) updateStatistics(sts *store.Statistics) {
 func (pm *Manager) GetModel() *Model {
 	return pm.policy.GetModel(pm.sts)
 }
+
+// GetWorkflowName returns the name of the workflow which the model belongs to
+func (pm *Manager) GetWorkflowName() string {
+	return pm.wf
+}
@@ -155,6 +155,11 @@ func mkfile(base, filename string) error {
 	return nil
 }
 
+// LoadStatistics reads the statistics from a model file generated by the local store
+func LoadStatistics(filename string) (*Statistics, error) {
+	return (&localstore{}).unmarshalStatistics(filename)
+}
+
 func (s *localstore) unmarshalStatistics(filename string) (*Statistics, error) {
 	sts := Statistics{}
 	buf, err := ioutil.ReadFile(filename)
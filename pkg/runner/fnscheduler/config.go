@@ -0,0 +1,229 @@
+package fnscheduler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	"go.uber.org/zap"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// The Function CRD is defined in tass-operator, the scheduler specific settings
+// of a Function are declared as the annotations of the Function.
+// A Function without the annotations uses the default values from the flags.
+// The settings in the local function config file override the annotations.
+const (
+	// MaxConcurrencyAnnotation is the max number of requests a process instance handles at the same time,
+	// 0 means unlimited, e.g. "4"
+	MaxConcurrencyAnnotation = "serverless.tass.io/max-concurrency"
+	// QueueSizeAnnotation is the max number of requests waiting for a saturated Function, e.g. "100"
+	QueueSizeAnnotation = "serverless.tass.io/queue-size"
+	// QueueTimeoutAnnotation is how long a request waits in the queue at most, e.g. "10s"
+	QueueTimeoutAnnotation = "serverless.tass.io/queue-timeout"
+	// MinInstancesAnnotation is the number of instances kept warm even if they are idle, e.g. "1"
+	MinInstancesAnnotation = "serverless.tass.io/min-instances"
+	// MaxInstancesAnnotation is the max number of instances, 0 means unlimited, e.g. "4"
+	MaxInstancesAnnotation = "serverless.tass.io/max-instances"
+	// ScaleToZeroAnnotation is whether the idle Function can release all its instances, e.g. "false"
+	ScaleToZeroAnnotation = "serverless.tass.io/scale-to-zero"
+	// MaxRequestsPerInstanceAnnotation is the number of requests after which a process instance is replaced,
+	// 0 means unlimited, e.g. "10000"
+	MaxRequestsPerInstanceAnnotation = "serverless.tass.io/max-requests-per-instance"
+	// MaxInstanceLifetimeAnnotation is how long a process instance lives before it's replaced,
+	// 0 means unlimited, e.g. "1h"
+	MaxInstanceLifetimeAnnotation = "serverless.tass.io/max-instance-lifetime"
+)
+
+// settings is the content of the local function config file,
+// the key is the function name, e.g.
+//
+//	function_a:
+//	  minInstances: 1
+//	  maxInstances: 4
+//	  scaleToZero: false
+//	  maxConcurrency: 4
+//	  maxRequestsPerInstance: 10000
+//	  maxInstanceLifetime: 1h
+var settings map[string]functionSettings
+
+// functionSettings is the function-level settings in the local function config file,
+// the fields not set keep the values from the annotations
+type functionSettings struct {
+	MaxConcurrency *int    `json:"maxConcurrency,omitempty"`
+	QueueSize      *int    `json:"queueSize,omitempty"`
+	QueueTimeout   *string `json:"queueTimeout,omitempty"`
+	MinInstances   *int    `json:"minInstances,omitempty"`
+	MaxInstances   *int    `json:"maxInstances,omitempty"`
+	ScaleToZero    *bool   `json:"scaleToZero,omitempty"`
+	MaxRequests    *int    `json:"maxRequestsPerInstance,omitempty"`
+	MaxLifetime    *string `json:"maxInstanceLifetime,omitempty"`
+}
+
+// functionConfig is the function-level scheduling settings
+type functionConfig struct {
+	maxConcurrency int
+	queueSize      int
+	queueTimeout   time.Duration
+	minInstances   int
+	maxInstances   int
+	scaleToZero    bool
+	// maxRequests and maxLifetime are the limits for recycling a process instance, 0 means unlimited
+	maxRequests int
+	maxLifetime time.Duration
+}
+
+// defaultFunctionConfig returns the settings from the flags
+func defaultFunctionConfig() functionConfig {
+	return functionConfig{
+		maxConcurrency: viper.GetInt(env.MaxConcurrency),
+		queueSize:      viper.GetInt(env.QueueSize),
+		queueTimeout:   viper.GetDuration(env.QueueTimeout),
+		scaleToZero:    true,
+		maxRequests:    viper.GetInt(env.MaxRequestsPerInstance),
+		maxLifetime:    viper.GetDuration(env.MaxInstanceLifetime),
+	}
+}
+
+// getFunctionConfig returns the settings of the Function,
+// it falls back to the default settings if the Function is not found.
+// This method is extracted as a helper function to mock the settings in test injection.
+var getFunctionConfig = func(functionName string) functionConfig {
+	config := defaultFunctionConfig()
+	function, existed, err := k8sutils.GetFunctionByName(functionName)
+	if err != nil || !existed {
+		zap.S().Warnw("function not found, use the default config", "function", functionName, "err", err)
+	} else {
+		config.parse(function)
+	}
+	if s, existed := settings[functionName]; existed {
+		config.apply(functionName, s)
+	}
+	return config
+}
+
+// loadFunctionSettings reads the local function config file
+func loadFunctionSettings(fileName string) (map[string]functionSettings, error) {
+	filebytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]functionSettings{}
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(filebytes), len(filebytes))
+	if err := decoder.Decode(&result); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parse overrides the settings with the annotations of the Function,
+// the invalid annotations are ignored with a warning
+func (c *functionConfig) parse(function *serverlessv1alpha1.Function) {
+	annotations := function.Annotations
+	parseInt := func(key string, value *int) {
+		raw, existed := annotations[key]
+		if !existed {
+			return
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			zap.S().Warnw("invalid function annotation", "function", function.Name, "key", key, "value", raw)
+			return
+		}
+		*value = n
+	}
+	parseDuration := func(key string, value *time.Duration) {
+		raw, existed := annotations[key]
+		if !existed {
+			return
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			zap.S().Warnw("invalid function annotation", "function", function.Name, "key", key, "value", raw)
+			return
+		}
+		*value = d
+	}
+	parseBool := func(key string, value *bool) {
+		raw, existed := annotations[key]
+		if !existed {
+			return
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			zap.S().Warnw("invalid function annotation", "function", function.Name, "key", key, "value", raw)
+			return
+		}
+		*value = b
+	}
+	parseInt(MaxConcurrencyAnnotation, &c.maxConcurrency)
+	parseInt(QueueSizeAnnotation, &c.queueSize)
+	parseDuration(QueueTimeoutAnnotation, &c.queueTimeout)
+	parseInt(MinInstancesAnnotation, &c.minInstances)
+	parseInt(MaxInstancesAnnotation, &c.maxInstances)
+	parseBool(ScaleToZeroAnnotation, &c.scaleToZero)
+	parseInt(MaxRequestsPerInstanceAnnotation, &c.maxRequests)
+	parseDuration(MaxInstanceLifetimeAnnotation, &c.maxLifetime)
+}
+
+// apply overrides the settings with the local function config file,
+// the invalid settings are ignored with a warning
+func (c *functionConfig) apply(functionName string, s functionSettings) {
+	applyInt := func(key string, setting *int, value *int) {
+		if setting == nil {
+			return
+		}
+		if *setting < 0 {
+			zap.S().Warnw("invalid function setting", "function", functionName, "key", key, "value", *setting)
+			return
+		}
+		*value = *setting
+	}
+	applyDuration := func(key string, setting *string, value *time.Duration) {
+		if setting == nil {
+			return
+		}
+		d, err := time.ParseDuration(*setting)
+		if err != nil || d < 0 {
+			zap.S().Warnw("invalid function setting", "function", functionName, "key", key, "value", *setting)
+			return
+		}
+		*value = d
+	}
+	applyInt("maxConcurrency", s.MaxConcurrency, &c.maxConcurrency)
+	applyInt("queueSize", s.QueueSize, &c.queueSize)
+	applyInt("minInstances", s.MinInstances, &c.minInstances)
+	applyInt("maxInstances", s.MaxInstances, &c.maxInstances)
+	applyInt("maxRequestsPerInstance", s.MaxRequests, &c.maxRequests)
+	applyDuration("queueTimeout", s.QueueTimeout, &c.queueTimeout)
+	applyDuration("maxInstanceLifetime", s.MaxLifetime, &c.maxLifetime)
+	if s.ScaleToZero != nil {
+		c.scaleToZero = *s.ScaleToZero
+	}
+}
+
+// minimum returns the number of instances kept warm,
+// a Function which can't scale to zero keeps at least one instance
+func (c functionConfig) minimum() int {
+	if c.minInstances == 0 && !c.scaleToZero {
+		return 1
+	}
+	return c.minInstances
+}
+
+// bound limits the target number of instances in [minimum, maxInstances]
+func (c functionConfig) bound(target int) int {
+	if c.maxInstances > 0 && target > c.maxInstances {
+		target = c.maxInstances
+	}
+	if min := c.minimum(); target < min {
+		target = min
+	}
+	return target
+}
@@ -0,0 +1,86 @@
+package fnscheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFunctionConfig(t *testing.T) {
+	Convey("test function config parses the annotations", t, func() {
+		config := functionConfig{queueSize: 100, queueTimeout: time.Second, scaleToZero: true}
+		config.parse(&serverlessv1alpha1.Function{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "a",
+				Annotations: map[string]string{
+					MaxConcurrencyAnnotation:         "4",
+					QueueTimeoutAnnotation:           "3s",
+					MinInstancesAnnotation:           "2",
+					MaxInstancesAnnotation:           "-1",
+					ScaleToZeroAnnotation:            "false",
+					MaxRequestsPerInstanceAnnotation: "1000",
+					MaxInstanceLifetimeAnnotation:    "1h",
+				},
+			},
+		})
+		So(config, ShouldResemble, functionConfig{
+			maxConcurrency: 4,
+			queueSize:      100,
+			queueTimeout:   3 * time.Second,
+			minInstances:   2,
+			scaleToZero:    false,
+			maxRequests:    1000,
+			maxLifetime:    time.Hour,
+		})
+	})
+
+	Convey("test function config reads the local file", t, func() {
+		dir, err := ioutil.TempDir("", "fnconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "functions.yaml")
+		content := "a:\n  minInstances: 1\n  maxInstances: 3\n  queueTimeout: 5s\n  maxInstanceLifetime: 30m\nb:\n  scaleToZero: false\n"
+		So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+
+		result, err := loadFunctionSettings(path)
+		So(err, ShouldBeNil)
+		So(result, ShouldContainKey, "a")
+		So(result, ShouldContainKey, "b")
+
+		a := functionConfig{minInstances: 5, scaleToZero: true}
+		a.apply("a", result["a"])
+		So(a, ShouldResemble, functionConfig{
+			queueTimeout: 5 * time.Second,
+			minInstances: 1,
+			maxInstances: 3,
+			scaleToZero:  true,
+			maxLifetime:  30 * time.Minute,
+		})
+		b := functionConfig{scaleToZero: true}
+		b.apply("b", result["b"])
+		So(b.scaleToZero, ShouldBeFalse)
+	})
+
+	Convey("test function config bounds the target", t, func() {
+		testcases := []struct {
+			config functionConfig
+			target int
+			expect int
+		}{
+			{config: functionConfig{scaleToZero: true}, target: 0, expect: 0},
+			{config: functionConfig{scaleToZero: false}, target: 0, expect: 1},
+			{config: functionConfig{minInstances: 2, scaleToZero: true}, target: 0, expect: 2},
+			{config: functionConfig{maxInstances: 3, scaleToZero: true}, target: 5, expect: 3},
+			{config: functionConfig{minInstances: 1, maxInstances: 3}, target: 2, expect: 2},
+		}
+		for _, testcase := range testcases {
+			So(testcase.config.bound(testcase.target), ShouldEqual, testcase.expect)
+		}
+	})
+}
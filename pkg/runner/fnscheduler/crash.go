@@ -0,0 +1,87 @@
+package fnscheduler
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/event"
+	"github.com/tass-io/scheduler/pkg/event/schedule"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+	"go.uber.org/zap"
+)
+
+// watch waits for the instance to exit, if the instance is still in the set when it exits,
+// it's a crash, so the instance is removed from the set and the ttl manager,
+// and a schedule event is sent to restore the instance number after a crash-loop backoff.
+// A draining instance is not replaced because it's being scaled down.
+func (s *instanceSet) watch(ins instance.Instance) {
+	<-ins.Done()
+	s.Lock()
+	if timer, draining := s.draining[ins]; draining {
+		if timer != nil {
+			timer.Stop()
+		}
+		delete(s.draining, ins)
+		s.Unlock()
+		s.ttl.Remove(ins)
+		return
+	}
+	index := -1
+	for i, item := range s.instances {
+		if item == ins {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		// the instance has been released by the scheduler
+		s.Unlock()
+		return
+	}
+	s.instances = append(s.instances[:index], s.instances[index+1:]...)
+	s.forget(ins)
+	target := len(s.instances) + 1
+	delay := s.crashBackoff(time.Now())
+	s.Unlock()
+	s.ttl.Remove(ins)
+
+	zap.S().Warnw("instance crashed and will be replaced", "function", s.functionName,
+		"target", target, "backoff", delay)
+	time.AfterFunc(delay, func() {
+		schedule.GetScheduleHandlerIns().AddEvent(event.ScheduleEvent{
+			FunctionName: s.functionName,
+			Target:       target,
+			Trend:        event.Increase,
+			Source:       event.ScheduleSource,
+		})
+	})
+}
+
+// crashBackoff records a crash and returns how long to wait before creating the replacement.
+// The delay doubles for every crash until it reaches the limit,
+// and it's reset when the function has not crashed for a whole limit period.
+// crashBackoff must be called with the set lock held.
+func (s *instanceSet) crashBackoff(now time.Time) time.Duration {
+	limit := viper.GetDuration(env.CrashBackoffLimit)
+	if now.Sub(s.lastCrash) > limit {
+		s.crashes = 0
+	}
+	delay := viper.GetDuration(env.CrashBackoff)
+	for i := 0; i < s.crashes && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	s.crashes++
+	s.lastCrash = now
+	s.backoffUntil = now.Add(delay)
+	return delay
+}
+
+// inBackoff returns whether the function is crash looping and instance creation should wait.
+// inBackoff must be called with the set lock held.
+func (s *instanceSet) inBackoff() bool {
+	return time.Now().Before(s.backoffUntil)
+}
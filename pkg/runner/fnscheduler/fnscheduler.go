@@ -1,12 +1,15 @@
 package fnscheduler
 
 import (
+	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/spf13/viper"
 	"github.com/tass-io/scheduler/pkg/collector"
 	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/event"
+	eventschedule "github.com/tass-io/scheduler/pkg/event/schedule"
 	"github.com/tass-io/scheduler/pkg/runner"
 	"github.com/tass-io/scheduler/pkg/runner/helper"
 	"github.com/tass-io/scheduler/pkg/runner/instance"
@@ -22,6 +25,8 @@ var (
 
 var _ runner.Runner = &FunctionScheduler{}
 var _ schedule.Scheduler = &FunctionScheduler{}
+var _ runner.ConcurrencyStatistics = &FunctionScheduler{}
+var _ runner.KeepAliveStatistics = &FunctionScheduler{}
 
 // FunctionScheduler implements Runner and Scheduler interface
 type FunctionScheduler struct {
@@ -47,6 +52,16 @@ func Init() {
 		NewInstance = func(functionName string) instance.Instance {
 			return instance.NewMockInstance(functionName)
 		}
+	} else if pool := instance.GetPool(); pool != nil {
+		// the pool is filled before the first cold start
+		zap.S().Infow("runtime pool enabled", "size", viper.GetInt(env.RuntimePoolSize))
+	}
+	if path := viper.GetString(env.FunctionConfigPath); path != "" {
+		var err error
+		settings, err = loadFunctionSettings(path)
+		if err != nil {
+			zap.S().Panicw("load function config file error", "path", path, "err", err)
+		}
 	}
 	fs = &FunctionScheduler{
 		Locker:    &sync.Mutex{},
@@ -76,10 +91,36 @@ func (fs *FunctionScheduler) sync() {
 	}
 }
 
-// canCreateInstance is a policy for determining whether function instance creation is possible
-// todo policy architecture
-func (fs *FunctionScheduler) canCreateInstance() bool {
-	return canCreatePolicies[viper.GetString(env.CreatePolicy)]()
+// canCreateInstance is a policy for determining whether function instance creation is possible,
+// the returned error explains why the creation is refused.
+// The caller must call the release function when the instance exits.
+func (fs *FunctionScheduler) canCreateInstance(functionName string) (func(), error) {
+	return canCreatePolicies[viper.GetString(env.CreatePolicy)](functionName)
+}
+
+// retryRefused sends schedule events for the functions whose instance creation was refused,
+// it's called when the reserved resources are released
+func (fs *FunctionScheduler) retryRefused() {
+	fs.Lock()
+	refused := map[string]int{}
+	for functionName, s := range fs.instances {
+		s.Lock()
+		if s.refused > 0 {
+			refused[functionName] = s.refused
+			s.refused, s.refusal = 0, nil
+		}
+		s.Unlock()
+	}
+	fs.Unlock()
+	for functionName, target := range refused {
+		zap.S().Infow("retry the refused instance creation", "function", functionName, "target", target)
+		eventschedule.GetScheduleHandlerIns().AddEvent(event.ScheduleEvent{
+			FunctionName: functionName,
+			Target:       target,
+			Trend:        event.Increase,
+			Source:       event.ScheduleSource,
+		})
+	}
 }
 
 // runner.Runner interface implementation
@@ -104,21 +145,34 @@ func (fs *FunctionScheduler) Run(span *span.Span, parameters map[string]interfac
 	fs.instances[functionName] = target
 	fs.Unlock()
 	start := time.Now()
-	result, err := target.Invoke(parameters)
+	target.ttl.Arrive(start)
+	result, err := target.Invoke(span.GetContext(), parameters)
 	collector.GetCollector().Record(upstream, flowName, functionName, collector.RecordExec, time.Since(start))
 	return result, err
 }
 
-// ChooseTargetInstance chooses a target instance which has the lowest score
+// ChooseTargetInstance chooses a target instance which has the lowest score,
+// only the random candidates are compared if the score policy limits the choices
 func ChooseTargetInstance(instances []instance.Instance) (target instance.Instance) {
-	min := runner.MaxScore
+	running := []instance.Instance{}
 	for _, item := range instances {
 		if item.IsRunning() {
-			score := item.Score()
-			if min > score {
-				min = score
-				target = item
-			}
+			running = append(running, item)
+		}
+	}
+	if choices := instance.GetScorePolicy().Choices; choices > 0 && len(running) > choices {
+		candidates := make([]instance.Instance, 0, choices)
+		for _, index := range rand.Perm(len(running))[:choices] {
+			candidates = append(candidates, running[index])
+		}
+		running = candidates
+	}
+	min := runner.MaxScore
+	for _, item := range running {
+		score := item.Score()
+		if min > score {
+			min = score
+			target = item
 		}
 	}
 	return
@@ -148,12 +202,35 @@ func (fs *FunctionScheduler) FunctionStats(functionName string) int {
 	return set.Stats()
 }
 
+// ConcurrencyStats returns the load of each function that fnscheduler manages
+func (fs *FunctionScheduler) ConcurrencyStats() runner.ConcurrencyStatus {
+	stats := runner.ConcurrencyStatus{}
+	fs.Lock()
+	defer fs.Unlock()
+	for functionName, s := range fs.instances {
+		stats[functionName] = s.Concurrency()
+	}
+	return stats
+}
+
+// KeepAliveStats returns the keep-alive window chosen for each function that fnscheduler manages
+func (fs *FunctionScheduler) KeepAliveStats() runner.KeepAliveStatus {
+	stats := runner.KeepAliveStatus{}
+	fs.Lock()
+	defer fs.Unlock()
+	for functionName, s := range fs.instances {
+		stats[functionName] = s.ttl.Window()
+	}
+	return stats
+}
+
 // 	schedule.Scheduler interface implementation
 //
 
 // Refresh refreshes information of instances and does scaling.
 // Everytime when ScheduleHandler receives a new event for upstream,
 // it decides the status of the instance, and calls Refresh.
+// The target is limited by the min/max instances settings of the function.
 func (fs *FunctionScheduler) Refresh(functionName string, target int) {
 	zap.S().Debugw("refresh", "function", functionName)
 
@@ -162,18 +239,23 @@ func (fs *FunctionScheduler) Refresh(functionName string, target int) {
 		zap.S().Panicw("instance set not initialized", "function", functionName)
 	}
 
+	if bounded := ins.config.bound(target); bounded != target {
+		zap.S().Infow("refresh target is bounded", "function", functionName, "target", target, "bounded", bounded)
+		target = bounded
+	}
 	ins.Scale(target, functionName)
 	// FIXME: when trigger, the process status may not still running, update the logic here
 	fs.trigger <- struct{}{}
 }
 
-// ColdStartDone returns when the instace cold start stage of the function (param1) is done
-func (fs *FunctionScheduler) ColdStartDone(functionName string) {
+// ColdStartDone returns when the instace cold start stage of the function (param1) is done,
+// the error explains why the instance creation is refused
+func (fs *FunctionScheduler) ColdStartDone(functionName string) error {
 	ins, existed := fs.instances[functionName]
 	if !existed {
 		zap.S().Panicw("instance set not initialized", "function", functionName)
 	}
-	ins.functionColdStartDone()
+	return ins.functionColdStartDone()
 }
 
 // NewInstanceSetIfNotExist creates a new instance set structure for the given function (param1)
@@ -186,6 +268,29 @@ func (fs *FunctionScheduler) NewInstanceSetIfNotExist(functionName string) {
 	_, existed := fs.instances[functionName]
 	if !existed {
 		newSet := newInstanceSet(functionName)
+		newSet.config = getFunctionConfig(functionName)
+		newSet.ttl.SetMinimum(newSet.config.minimum())
 		fs.instances[functionName] = newSet
 	}
 }
+
+// WarmUp creates the minimum instances for the functions which must not cold start,
+// it's called after the event framework starts
+func (fs *FunctionScheduler) WarmUp() {
+	names := map[string]struct{}{}
+	for _, functionName := range k8sutils.ListFunctionNames() {
+		names[functionName] = struct{}{}
+	}
+	for functionName := range settings {
+		names[functionName] = struct{}{}
+	}
+	for functionName := range names {
+		min := getFunctionConfig(functionName).minimum()
+		if min == 0 {
+			continue
+		}
+		zap.S().Infow("warm up function", "function", functionName, "min", min)
+		fs.NewInstanceSetIfNotExist(functionName)
+		fs.Refresh(functionName, min)
+	}
+}
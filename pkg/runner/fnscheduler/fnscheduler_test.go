@@ -1,6 +1,8 @@
 package fnscheduler
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -8,7 +10,9 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/spf13/viper"
 	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/event"
 	eventinit "github.com/tass-io/scheduler/pkg/event/init"
+	eventschedule "github.com/tass-io/scheduler/pkg/event/schedule"
 	middlewareinit "github.com/tass-io/scheduler/pkg/middleware/init"
 	"github.com/tass-io/scheduler/pkg/runner"
 	"github.com/tass-io/scheduler/pkg/runner/instance"
@@ -185,3 +189,448 @@ func TestFunctionScheduler_RefreshAndRun(t *testing.T) {
 		})
 	}
 }
+
+// crashMockInstance is a mock instance which blocks all requests until it crashes
+type crashMockInstance struct {
+	done chan struct{}
+}
+
+func (c *crashMockInstance) Invoke(ctx context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	<-c.done
+	return nil, &instance.CrashError{FunctionName: "a", InstanceID: "crash"}
+}
+
+func (c *crashMockInstance) Score() int {
+	return 1
+}
+
+func (c *crashMockInstance) Metrics() instance.Metrics {
+	return instance.Metrics{}
+}
+
+func (c *crashMockInstance) Release() {}
+
+func (c *crashMockInstance) IsRunning() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *crashMockInstance) Start() error {
+	return nil
+}
+
+func (c *crashMockInstance) HasRequests() bool {
+	return false
+}
+
+func (c *crashMockInstance) InitDone() {}
+
+func (c *crashMockInstance) Done() <-chan struct{} {
+	return c.done
+}
+
+// fakeScheduleHandler records the events sent to the schedule handler
+type fakeScheduleHandler struct {
+	events chan event.ScheduleEvent
+}
+
+func (h *fakeScheduleHandler) AddEvent(e event.ScheduleEvent) {
+	h.events <- e
+}
+
+func (h *fakeScheduleHandler) GetSource() event.Source {
+	return event.ScheduleSource
+}
+
+func (h *fakeScheduleHandler) Start() error {
+	return nil
+}
+
+func TestInstanceSet_Crash(t *testing.T) {
+	Convey("test instance set handles crashed instances", t, func() {
+		handler := &fakeScheduleHandler{events: make(chan event.ScheduleEvent, 10)}
+		origin := eventschedule.GetScheduleHandlerIns
+		eventschedule.GetScheduleHandlerIns = func() event.Handler {
+			return handler
+		}
+		defer func() {
+			eventschedule.GetScheduleHandlerIns = origin
+		}()
+		viper.Set(env.CrashBackoff, 10*time.Millisecond)
+		viper.Set(env.CrashBackoffLimit, 100*time.Millisecond)
+
+		crashed := &crashMockInstance{done: make(chan struct{})}
+		healthy := instance.NewMockInstance("a")
+		s := newInstanceSet("a")
+		s.instances = []instance.Instance{crashed, healthy}
+		go s.watch(crashed)
+		go s.watch(healthy)
+
+		Convey("pending requests fail and the instance is replaced", func() {
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := crashed.Invoke(context.Background(), nil)
+				errCh <- err
+			}()
+			close(crashed.done)
+			So(errors.Is(<-errCh, instance.ErrInstanceCrashed), ShouldBeTrue)
+
+			e := <-handler.events
+			So(e, ShouldResemble, event.ScheduleEvent{
+				FunctionName: "a",
+				Target:       2,
+				Trend:        event.Increase,
+				Source:       event.ScheduleSource,
+			})
+			So(s.Stats(), ShouldEqual, 1)
+			So(s.instances, ShouldResemble, []instance.Instance{healthy})
+		})
+
+		Convey("released instances are not replaced", func() {
+			s.Lock()
+			s.instances = []instance.Instance{crashed}
+			s.Unlock()
+			healthy.Release()
+			select {
+			case e := <-handler.events:
+				So(e, ShouldBeNil)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+
+		Convey("crash loops back off exponentially", func() {
+			now := time.Now()
+			delays := []time.Duration{}
+			for i := 0; i < 5; i++ {
+				delays = append(delays, s.crashBackoff(now))
+			}
+			So(delays, ShouldResemble, []time.Duration{
+				10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond,
+				80 * time.Millisecond, 100 * time.Millisecond,
+			})
+			So(s.inBackoff(), ShouldBeTrue)
+			So(s.crashBackoff(now.Add(time.Second)), ShouldEqual, 10*time.Millisecond)
+		})
+	})
+}
+
+// blockingMockInstance is a mock instance which blocks all requests until they're unblocked
+type blockingMockInstance struct {
+	instance.Instance
+	unblock chan struct{}
+}
+
+func (b *blockingMockInstance) Invoke(ctx context.Context, parameters map[string]interface{}) (map[string]interface{}, error) {
+	<-b.unblock
+	return parameters, nil
+}
+
+func TestInstanceSet_Queue(t *testing.T) {
+	Convey("test instance set queues requests when instances are saturated", t, func() {
+		handler := &fakeScheduleHandler{events: make(chan event.ScheduleEvent, 10)}
+		origin := eventschedule.GetScheduleHandlerIns
+		eventschedule.GetScheduleHandlerIns = func() event.Handler {
+			return handler
+		}
+		defer func() {
+			eventschedule.GetScheduleHandlerIns = origin
+		}()
+
+		blocking := &blockingMockInstance{Instance: instance.NewMockInstance("a"), unblock: make(chan struct{})}
+		s := newInstanceSet("a")
+		s.config = functionConfig{maxConcurrency: 1, queueSize: 1, queueTimeout: 100 * time.Millisecond}
+		s.instances = []instance.Instance{blocking}
+
+		invoke := func() chan error {
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := s.Invoke(context.Background(), map[string]interface{}{})
+				errCh <- err
+			}()
+			return errCh
+		}
+		queueLen := func() int {
+			s.Lock()
+			defer s.Unlock()
+			return len(s.queue)
+		}
+
+		first := invoke()
+		time.Sleep(10 * time.Millisecond)
+		second := invoke()
+		time.Sleep(10 * time.Millisecond)
+		So(queueLen(), ShouldEqual, 1)
+		So(<-handler.events, ShouldResemble, event.ScheduleEvent{
+			FunctionName: "a",
+			Target:       2,
+			Trend:        event.Increase,
+			Source:       event.ScheduleSource,
+		})
+
+		Convey("the queue is bounded", func() {
+			So(<-invoke(), ShouldEqual, ErrQueueFull)
+			close(blocking.unblock)
+			So(<-first, ShouldBeNil)
+			So(<-second, ShouldBeNil)
+		})
+
+		Convey("the queued request times out", func() {
+			So(<-second, ShouldEqual, ErrQueueTimeout)
+			So(queueLen(), ShouldEqual, 0)
+			close(blocking.unblock)
+			So(<-first, ShouldBeNil)
+			s.Lock()
+			So(s.inflight, ShouldBeEmpty)
+			s.Unlock()
+		})
+	})
+}
+
+func TestInstanceSet_QueueUnlimited(t *testing.T) {
+	Convey("test instance set queues requests without the concurrency limit", t, func() {
+		handler := &fakeScheduleHandler{events: make(chan event.ScheduleEvent, 10)}
+		origin := eventschedule.GetScheduleHandlerIns
+		eventschedule.GetScheduleHandlerIns = func() event.Handler {
+			return handler
+		}
+		defer func() {
+			eventschedule.GetScheduleHandlerIns = origin
+		}()
+
+		// the instance with the max score is never chosen, so the request is queued
+		s := newInstanceSet("a")
+		s.config = functionConfig{maxConcurrency: 0, queueSize: 1, queueTimeout: 10 * time.Millisecond}
+		s.instances = []instance.Instance{&scoreMockInstance{Instance: instance.NewMockInstance("a"), score: runner.MaxScore}}
+		_, err := s.Invoke(context.Background(), map[string]interface{}{})
+		So(err, ShouldEqual, ErrQueueTimeout)
+		So(<-handler.events, ShouldResemble, event.ScheduleEvent{
+			FunctionName: "a",
+			Target:       2,
+			Trend:        event.Increase,
+			Source:       event.ScheduleSource,
+		})
+	})
+}
+
+// scoreMockInstance is a mock instance with a fixed score
+type scoreMockInstance struct {
+	instance.Instance
+	score int
+}
+
+func (s *scoreMockInstance) Score() int {
+	return s.score
+}
+
+func TestChooseTargetInstance(t *testing.T) {
+	Convey("test choosing the target instance by the score policy", t, func() {
+		defer viper.Set(env.InstanceScorePolicy, "")
+		instances := []instance.Instance{
+			&scoreMockInstance{Instance: instance.NewMockInstance("a"), score: 3},
+			&scoreMockInstance{Instance: instance.NewMockInstance("a"), score: 1},
+			&scoreMockInstance{Instance: instance.NewMockInstance("a"), score: 2},
+		}
+
+		Convey("all instances are compared by default", func() {
+			So(ChooseTargetInstance(instances), ShouldEqual, instances[1])
+		})
+
+		Convey("the p2c policy compares two random instances", func() {
+			viper.Set(env.InstanceScorePolicy, instance.P2CPolicy)
+			chosen := map[instance.Instance]int{}
+			for i := 0; i < 100; i++ {
+				chosen[ChooseTargetInstance(instances)]++
+			}
+			So(chosen, ShouldNotContainKey, instances[0])
+			So(chosen[instances[1]], ShouldBeGreaterThan, 0)
+			So(chosen[instances[2]], ShouldBeGreaterThan, 0)
+		})
+	})
+}
+
+func TestInstanceSet_ScaleDown(t *testing.T) {
+	Convey("test instance set scales down by the policy and drains the victims", t, func() {
+		if event.GetHandlerBySource(event.MetricsSource) == nil {
+			// the ttl manager reports the released instances to the metrics handler
+			event.Register(event.MetricsSource, &fakeScheduleHandler{events: make(chan event.ScheduleEvent, 100)}, 0, true)
+		}
+		defer viper.Set(env.ScaleDownPolicy, "")
+		defer viper.Set(env.DrainTimeout, 0)
+		mocks := []instance.Instance{}
+		for _, score := range []int{2, 3, 1} {
+			mocks = append(mocks, &scoreMockInstance{Instance: instance.NewMockInstance("a"), score: score})
+		}
+		s := newInstanceSet("a")
+		s.instances = append(s.instances, mocks...)
+		now := time.Now()
+		for i, ins := range mocks {
+			s.idleSince[ins] = now.Add(time.Duration(i) * time.Second)
+		}
+		released := func(ins instance.Instance) bool {
+			select {
+			case <-ins.Done():
+				return true
+			default:
+				return false
+			}
+		}
+
+		Convey("the instances with the lowest scores are released by default", func() {
+			s.Scale(1, "a")
+			So(s.instances, ShouldResemble, []instance.Instance{mocks[1]})
+			So(released(mocks[0]), ShouldBeTrue)
+			So(released(mocks[1]), ShouldBeFalse)
+			So(released(mocks[2]), ShouldBeTrue)
+		})
+
+		Convey("the idle-longest policy keeps the busy instances", func() {
+			viper.Set(env.ScaleDownPolicy, IdleLongestScaleDownPolicy)
+			s.occupy(mocks[0])
+			So(s.victims(2), ShouldResemble, []instance.Instance{mocks[1], mocks[2]})
+			viper.Set(env.ScaleDownPolicy, OldestFirstScaleDownPolicy)
+			So(s.victims(2), ShouldResemble, []instance.Instance{mocks[0], mocks[1]})
+		})
+
+		Convey("the busy victim is released after its requests finish", func() {
+			viper.Set(env.ScaleDownPolicy, OldestFirstScaleDownPolicy)
+			s.occupy(mocks[0])
+			s.Scale(2, "a")
+			So(s.instances, ShouldResemble, []instance.Instance{mocks[1], mocks[2]})
+			So(s.draining, ShouldContainKey, mocks[0])
+			So(released(mocks[0]), ShouldBeFalse)
+			s.release(mocks[0])
+			So(released(mocks[0]), ShouldBeTrue)
+			So(s.draining, ShouldBeEmpty)
+		})
+
+		Convey("the busy victim is released when the drain timeout exceeds", func() {
+			viper.Set(env.ScaleDownPolicy, OldestFirstScaleDownPolicy)
+			viper.Set(env.DrainTimeout, 10*time.Millisecond)
+			s.occupy(mocks[0])
+			s.Scale(2, "a")
+			So(released(mocks[0]), ShouldBeFalse)
+			select {
+			case <-mocks[0].Done():
+			case <-time.After(time.Second):
+			}
+			So(released(mocks[0]), ShouldBeTrue)
+			s.release(mocks[0])
+			s.Lock()
+			So(s.inflight, ShouldBeEmpty)
+			So(s.draining, ShouldBeEmpty)
+			s.Unlock()
+		})
+	})
+}
+
+// lifetimeMockInstance is a mock instance started at a given time
+type lifetimeMockInstance struct {
+	instance.Instance
+	startTime time.Time
+}
+
+func (l *lifetimeMockInstance) Metrics() instance.Metrics {
+	return instance.Metrics{StartTime: l.startTime}
+}
+
+func TestInstanceSet_Recycle(t *testing.T) {
+	Convey("test instance set replaces the instances reaching the limits", t, func() {
+		if event.GetHandlerBySource(event.MetricsSource) == nil {
+			event.Register(event.MetricsSource, &fakeScheduleHandler{events: make(chan event.ScheduleEvent, 100)}, 0, true)
+		}
+		viper.Set(env.CreatePolicy, "default")
+		origin := NewInstance
+		created := make(chan instance.Instance, 10)
+		NewInstance = func(functionName string) instance.Instance {
+			ins := instance.NewMockInstance(functionName)
+			created <- ins
+			return ins
+		}
+		defer func() {
+			NewInstance = origin
+		}()
+		old := &lifetimeMockInstance{Instance: instance.NewMockInstance("a"), startTime: time.Now()}
+		s := newInstanceSet("a")
+		s.instances = []instance.Instance{old}
+		released := func() bool {
+			select {
+			case <-old.Done():
+				return true
+			case <-time.After(time.Second):
+				return false
+			}
+		}
+		// replaced waits for the replacement to take over the set
+		replaced := func(replacement instance.Instance) bool {
+			for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				s.Lock()
+				done := len(s.instances) == 1 && s.instances[0] == replacement
+				s.Unlock()
+				if done {
+					return true
+				}
+			}
+			return false
+		}
+
+		Convey("the instance is replaced after the max requests", func() {
+			s.config.maxRequests = 2
+			for i := 0; i < 2; i++ {
+				process, err := s.acquire(context.Background())
+				So(err, ShouldBeNil)
+				So(process, ShouldEqual, old)
+				if i == 0 {
+					s.release(process)
+					So(created, ShouldBeEmpty)
+				}
+			}
+			replacement := <-created
+			So(replaced(replacement), ShouldBeTrue)
+			// the old instance keeps the in-flight request until it finishes
+			s.Lock()
+			So(s.draining, ShouldContainKey, old)
+			s.Unlock()
+			s.release(old)
+			So(released(), ShouldBeTrue)
+			s.Lock()
+			So(s.served, ShouldBeEmpty)
+			So(s.retiring, ShouldBeEmpty)
+			s.Unlock()
+		})
+
+		Convey("the instance is replaced after the max lifetime", func() {
+			s.config.maxLifetime = time.Minute
+			old.startTime = time.Now().Add(-time.Hour)
+			process, err := s.acquire(context.Background())
+			So(err, ShouldBeNil)
+			s.release(process)
+			replacement := <-created
+			So(replaced(replacement), ShouldBeTrue)
+			So(released(), ShouldBeTrue)
+		})
+
+		Convey("the only instance is replaced while no cold start request is waiting", func() {
+			s.instances = []instance.Instance{}
+			s.Scale(1, "a")
+			first := <-created
+			s.config.maxRequests = 1
+			process, err := s.acquire(context.Background())
+			So(err, ShouldBeNil)
+			So(process, ShouldEqual, first)
+			s.release(process)
+			replacement := <-created
+			So(replaced(replacement), ShouldBeTrue)
+			<-first.Done()
+			s.Lock()
+			So(s.retiring, ShouldBeEmpty)
+			So(s.coldStartWaiting, ShouldBeFalse)
+			s.Unlock()
+			// no notification is left for the later cold start requests
+			So(s.coldStartDone, ShouldBeEmpty)
+		})
+	})
+}
@@ -1,24 +1,54 @@
 package fnscheduler
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/avast/retry-go"
+	"github.com/tass-io/scheduler/pkg/runner"
 	"github.com/tass-io/scheduler/pkg/runner/instance"
 	"github.com/tass-io/scheduler/pkg/runner/ttl"
 	"github.com/tass-io/scheduler/pkg/utils/errorutils"
 	"go.uber.org/zap"
 )
 
+var errNilInstance = errors.New("instance is nil")
+
 // instanceSet is the status of the function and its instances
 // todo take care of terminated instances clean
 type instanceSet struct {
 	sync.Locker
-	functionName  string
-	instances     []instance.Instance
-	coldStartDone chan struct{}
-	accesslimit   chan struct{}
-	ttl           *ttl.Manager
+	functionName string
+	instances    []instance.Instance
+	// coldStartDone receives nil when a cold start is done, or the error why the creation is refused,
+	// coldStartWaiting is whether a cold start request is waiting for it
+	coldStartDone    chan error
+	coldStartWaiting bool
+	accesslimit      chan struct{}
+	ttl              *ttl.Manager
+	config           functionConfig
+	// inflight records the number of requests each instance is handling
+	inflight map[instance.Instance]int
+	// queue is the FIFO queue of the requests waiting for a saturated function
+	queue []chan instance.Instance
+	// crashes is the number of recent crashes, it decides the crash-loop backoff
+	crashes      int
+	lastCrash    time.Time
+	backoffUntil time.Time
+	// refused is the target which the create policy refused to reach, 0 means no refusal,
+	// refusal is the reason when no instance is starting
+	refused int
+	refusal error
+	// idleSince records when each instance becomes idle, it's for the scale-down policies
+	idleSince map[instance.Instance]time.Time
+	// draining is the instances removed by scaling down and waiting for their in-flight requests,
+	// the value is the timer of the drain timeout, nil if there is no timeout
+	draining map[instance.Instance]*time.Timer
+	// served is the number of requests each instance has served, retiring is the instances being replaced
+	served   map[instance.Instance]int
+	retiring map[instance.Instance]struct{}
 }
 
 // newInstanceSet returns a new instance set for the input function
@@ -27,34 +57,45 @@ func newInstanceSet(functionName string) *instanceSet {
 		Locker:        &sync.Mutex{},
 		functionName:  functionName,
 		instances:     []instance.Instance{},
-		coldStartDone: make(chan struct{}, 1),
+		coldStartDone: make(chan error, 1),
 		accesslimit:   make(chan struct{}, 1),
 		ttl:           ttl.NewTTLManager(functionName),
+		config:        defaultFunctionConfig(),
+		inflight:      make(map[instance.Instance]int),
+		idleSince:     make(map[instance.Instance]time.Time),
+		draining:      make(map[instance.Instance]*time.Timer),
+		served:        make(map[instance.Instance]int),
+		retiring:      make(map[instance.Instance]struct{}),
 	}
 }
 
 // Invoke is a set-level invocation, it finds a lowest score process to run the function,
 // if no available processes, it returns en error.
+// If all processes are saturated, the request waits in the queue of the set.
 //
 // Invoke is called after middleware, so if there is a cold start case, it has triggered a
 // cold start event. Here Invoke assumes that the instance is already running, if no running
-// instances, it returns an ErrInstanceNotService error
-func (s *instanceSet) Invoke(parameters map[string]interface{}) (map[string]interface{}, error) {
+// instances, it returns an ErrInstanceNotService error.
+// When the context is done, Invoke stops waiting and returns the context error.
+func (s *instanceSet) Invoke(ctx context.Context, parameters map[string]interface{}) (map[string]interface{}, error) {
 	if s.stats() > 0 {
 		// warm start, try to find a lowest latency process to work
 		var result map[string]interface{}
 		var err error
 		err = retry.Do(
 			func() error {
-				s.Lock()
-				process := ChooseTargetInstance(s.instances)
-				s.Unlock()
+				process, err := s.acquire(ctx)
+				if err != nil {
+					// all instances may have crashed or been released after the stats check
+					return err
+				}
+				defer s.release(process)
 				err = s.resetInstanceTimer(process)
 				zap.S().Infow("reset timer", "instance", process)
 				if err != nil {
 					zap.S().Warnw("reset timer failed:", "err", err)
 				}
-				result, err = process.Invoke(parameters)
+				result, err = process.Invoke(ctx, parameters)
 				return err
 			},
 			retry.RetryIf(func(err error) bool {
@@ -66,6 +107,9 @@ func (s *instanceSet) Invoke(parameters map[string]interface{}) (map[string]inte
 				return err == instance.ErrInstanceNotService
 			}),
 			retry.Attempts(3),
+			retry.Context(ctx),
+			// keep the original error so that the caller can tell a context error
+			retry.LastErrorOnly(true),
 		)
 		return result, err
 	}
@@ -84,6 +128,22 @@ func (s *instanceSet) Stats() int {
 	return s.stats()
 }
 
+// Concurrency returns the load of the function
+func (s *instanceSet) Concurrency() runner.Concurrency {
+	s.Lock()
+	defer s.Unlock()
+	inFlight := 0
+	for _, n := range s.inflight {
+		inFlight += n
+	}
+	return runner.Concurrency{
+		Instances: s.stats(),
+		InFlight:  inFlight,
+		Queued:    len(s.queue),
+		Minimum:   s.config.minimum(),
+	}
+}
+
 // stats returns the alive number of instances
 func (s *instanceSet) stats() int {
 	alive := 0
@@ -100,104 +160,146 @@ func (s *instanceSet) stats() int {
 func (s *instanceSet) Scale(target int, functionName string) {
 	s.Lock()
 	defer s.Unlock()
+	s.refused, s.refusal = 0, nil
 	l := len(s.instances)
 	if l > target {
 		zap.S().Debugw("set scale down", "function", s.functionName)
-		// scale down
-		// select the instances with the lowest scores
-		// get scores
-		// the ins.Score is the most important part
-		scores := []int{}
-		for _, ins := range s.instances {
-			scores = append(scores, ins.Score())
-		}
-		targetIndexes := []int{}
-		for rest := 0; rest < l-target; rest++ {
-			smallest := 99999
-			index := -1
-			for i, score := range scores {
-				if score < smallest {
-					index = i
-					smallest = score
-				}
-			}
-			if index == -1 {
-				zap.S().Warnw("cannot find one instance")
-			} else {
-				scores = append(scores[:index], scores[index+1:]...)
-				targetIndexes = append(targetIndexes, index)
-			}
-		}
-		for _, targetIndex := range targetIndexes {
-			ins := s.instances[targetIndex]
-			s.instances = append(s.instances[:targetIndex], s.instances[targetIndex+1:]...)
-			ins.Release()
-			zap.S().Debugw("fnscheduler release instance")
-			s.ttl.Release(ins)
+		// scale down, the victims are chosen by the scale-down policy and drained before released
+		for _, ins := range s.victims(l - target) {
+			s.drain(ins)
 		}
 	} else if l < target {
 		zap.S().Debugw("set scale up", "function", s.functionName)
+		if s.inBackoff() {
+			// the replacement event is sent when the backoff ends
+			zap.S().Warnw("function is crash looping, delay scaling up", "function", s.functionName,
+				"until", s.backoffUntil)
+			return
+		}
 		// scale up
 		for i := 0; i < target-l; i++ {
-			if fs.canCreateInstance() {
-				// 1. preprare data structure for the new process instance
-				newIns := NewInstance(functionName)
-				zap.S().Infow("function scheduler creates instance", "instance", newIns)
-				if newIns == nil {
-					zap.S().Warn("instance is nil")
-					return
-				}
-
-				// 2. start the process instance
-				err := newIns.Start()
-				if err != nil {
-					zap.S().Warnw("function scheduler start instance error", "err", err)
-					continue
+			release, err := fs.canCreateInstance(functionName)
+			if err != nil {
+				// the creation is retried when the resources are released
+				zap.S().Warnw("function scheduler refuses to create instance", "function", functionName, "reason", err)
+				s.refused = target
+				if len(s.instances) == 0 {
+					// no instance is starting, so the cold start requests are told the reason instead of waiting
+					s.refusal = err
+					s.notifyColdStartDone(err)
 				}
-
-				// 3. Notify the coldStartDone channel when the cold start phase is done
-				go func() {
-					newIns.InitDone()
-					zap.S().Debug("an instance initialization done")
-					// this step is important,
-					// ensure that we olny send a signal when a cold start stage done
-					//
-					// the newIns.InitDone() makes sure that the newIns status is running, guarantees
-					// the alive number is at least 1.
-					alive := s.stats()
-					if alive == 1 {
-						s.notifyColdStartDone()
-						zap.S().Debug("an instance cold start done")
-					}
-				}()
-
-				s.instances = append(s.instances, newIns)
-				s.ttl.Append(newIns)
+				return
+			}
+			if _, err := s.startInstance(functionName, release, nil); err == errNilInstance {
+				return
 			}
 		}
 	}
 }
 
-// functionColdStartDone returns when a cold start stage completes
-func (s *instanceSet) functionColdStartDone() {
+// startInstance creates and starts a new instance with the resources reserved by the create policy,
+// the resources are released by the callback (param2) when the instance exits.
+// The ready callback (param3) is called with the set lock held when the instance initialization is done.
+// startInstance must be called with the set lock held
+func (s *instanceSet) startInstance(
+	functionName string, release func(), ready func(newIns instance.Instance)) (instance.Instance, error) {
+
+	// 1. preprare data structure for the new process instance
+	newIns := NewInstance(functionName)
+	zap.S().Infow("function scheduler creates instance", "instance", newIns)
+	if newIns == nil {
+		zap.S().Warn("instance is nil")
+		release()
+		return nil, errNilInstance
+	}
+
+	// 2. start the process instance, it binds a pre-warmed runtime from the pool if there is one
+	err := newIns.Start()
+	if err != nil {
+		zap.S().Warnw("function scheduler start instance error", "err", err)
+		release()
+		return nil, err
+	}
+
+	// 3. Notify the coldStartDone channel when the cold start phase is done
+	go func() {
+		newIns.InitDone()
+		zap.S().Debug("an instance initialization done")
+		// this step is important,
+		// ensure that we olny send a signal when a cold start stage done
+		//
+		// the newIns.InitDone() makes sure that the newIns status is running, guarantees
+		// the alive number is at least 1.
+		s.Lock()
+		defer s.Unlock()
+		// the instance may have crashed before its initialization done
+		if s.stats() == 1 && newIns.IsRunning() {
+			s.notifyColdStartDone(nil)
+			zap.S().Debug("an instance cold start done")
+		}
+		// the new instance takes over the queued requests
+		if ready != nil {
+			ready(newIns)
+		}
+		s.dispatch()
+	}()
+
+	s.instances = append(s.instances, newIns)
+	s.markIdle(newIns)
+	s.ttl.Append(newIns)
+	go s.watch(newIns)
+	go func() {
+		// the reserved resources are released when the instance exits
+		<-newIns.Done()
+		release()
+	}()
+	return newIns, nil
+}
+
+// functionColdStartDone returns when a cold start stage completes,
+// it returns the error why the instance creation is refused if the cold start fails
+func (s *instanceSet) functionColdStartDone() error {
 	// accesslimit channel guarantees that at most one request can access at a time
 	s.accesslimit <- struct{}{}
-	// check again to avoid tocttou problem
-	alive := s.stats()
-	if alive == 0 {
-		s.receiveColdStartDone()
+	defer func() {
+		<-s.accesslimit
+	}()
+	// check again to avoid tocttou problem, the request is marked waiting with the lock held
+	// so that it doesn't miss the notification
+	s.Lock()
+	if s.stats() > 0 {
+		s.Unlock()
+		return nil
+	}
+	if s.refusal != nil {
+		// the creation has been refused before the request waits, it's retried when the resources are released
+		err := s.refusal
+		s.Unlock()
+		return err
 	}
-	<-s.accesslimit
+	s.coldStartWaiting = true
+	s.Unlock()
+	return s.receiveColdStartDone()
 }
 
-// notifyColdStartDone is used to notify the coldStartDone channel
-func (s *instanceSet) notifyColdStartDone() {
-	s.coldStartDone <- struct{}{}
+// notifyColdStartDone tells the waiting cold start request that the cold start is done, or the error
+// why the creation is refused. Nothing is sent if no request is waiting, so it never blocks and
+// no notification is left for a later request.
+// notifyColdStartDone must be called with the set lock held
+func (s *instanceSet) notifyColdStartDone(err error) {
+	if !s.coldStartWaiting {
+		return
+	}
+	s.coldStartWaiting = false
+	select {
+	case s.coldStartDone <- err:
+	default:
+	}
 }
 
 // receiveColdStartDone is used to receive the coldStartDone channel
-func (s *instanceSet) receiveColdStartDone() {
-	<-s.coldStartDone
+func (s *instanceSet) receiveColdStartDone() error {
+	return <-s.coldStartDone
 }
 
 // NewInstance creates a new process instance.
@@ -1,13 +1,20 @@
 package fnscheduler
 
 var (
-	canCreatePolicies = map[string]func() bool{
-		"default": DefaultCanCreateInstancePolicy,
+	canCreatePolicies = map[string]func(functionName string) (release func(), err error){
+		"default":  DefaultCanCreateInstancePolicy,
+		"resource": ResourceCanCreateInstancePolicy,
 	}
 )
 
 // DefaultCanCreateInstancePolicy is a policy to judge whether to create a new process or not
-// DefaultCanCreateInstancePolicy always returns true
-func DefaultCanCreateInstancePolicy() bool {
-	return true
+// DefaultCanCreateInstancePolicy always allows the creation
+func DefaultCanCreateInstancePolicy(functionName string) (func(), error) {
+	return func() {}, nil
+}
+
+// ResourceCanCreateInstancePolicy allows the creation only if the node has enough cpu and memory
+// for the resources the Function requests, the resources are reserved until the release function is called
+func ResourceCanCreateInstancePolicy(functionName string) (func(), error) {
+	return getResourceBudget().reserve(functionName)
 }
@@ -0,0 +1,156 @@
+package fnscheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tass-io/scheduler/pkg/event"
+	"github.com/tass-io/scheduler/pkg/event/schedule"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrQueueFull    = errors.New("all instances are saturated and the queue is full")
+	ErrQueueTimeout = errors.New("request queue timeout")
+)
+
+// acquire chooses the lowest score instance which is not saturated and occupies a slot of it.
+// If all running instances are saturated, the request waits in the FIFO queue until
+// an instance is available, the queue timeout exceeds or the context is done.
+// The caller must call release when the invocation is done.
+func (s *instanceSet) acquire(ctx context.Context) (instance.Instance, error) {
+	s.Lock()
+	if process := s.choose(); process != nil {
+		s.occupy(process)
+		s.Unlock()
+		return process, nil
+	}
+	if s.stats() == 0 {
+		s.Unlock()
+		return nil, instance.ErrInstanceNotService
+	}
+	if len(s.queue) >= s.config.queueSize {
+		s.Unlock()
+		return nil, ErrQueueFull
+	}
+	waiter := make(chan instance.Instance, 1)
+	s.queue = append(s.queue, waiter)
+	depth := len(s.queue)
+	// every maxConcurrency queued requests need one more instance,
+	// an instance without the limit is regarded as serving one request at a time
+	perInstance := s.config.maxConcurrency
+	if perInstance <= 0 {
+		perInstance = 1
+	}
+	target := len(s.instances) + (depth+perInstance-1)/perInstance
+	s.Unlock()
+	if (depth-1)%perInstance == 0 {
+		zap.S().Infow("instances saturated, scale up by the queue", "function", s.functionName,
+			"depth", depth, "target", target)
+		schedule.GetScheduleHandlerIns().AddEvent(event.ScheduleEvent{
+			FunctionName: s.functionName,
+			Target:       target,
+			Trend:        event.Increase,
+			Source:       event.ScheduleSource,
+		})
+	}
+
+	var timeout <-chan time.Time
+	if s.config.queueTimeout > 0 {
+		timer := time.NewTimer(s.config.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	select {
+	case process := <-waiter:
+		return process, nil
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.Lock()
+	defer s.Unlock()
+	if !s.dequeue(waiter) {
+		// an instance has been handed over before the waiter leaves, give it to the next one
+		s.vacate(<-waiter)
+	}
+	return nil, err
+}
+
+// release frees the slot of the instance occupied by acquire
+func (s *instanceSet) release(process instance.Instance) {
+	s.Lock()
+	defer s.Unlock()
+	s.vacate(process)
+}
+
+// choose returns the lowest score running instance which is not saturated,
+// choose must be called with the set lock held
+func (s *instanceSet) choose() instance.Instance {
+	if s.config.maxConcurrency <= 0 {
+		return ChooseTargetInstance(s.instances)
+	}
+	available := make([]instance.Instance, 0, len(s.instances))
+	for _, ins := range s.instances {
+		if s.inflight[ins] < s.config.maxConcurrency {
+			available = append(available, ins)
+		}
+	}
+	return ChooseTargetInstance(available)
+}
+
+// occupy records a new request of the instance and recycles the instance if it reaches the limits,
+// it must be called with the set lock held
+func (s *instanceSet) occupy(process instance.Instance) {
+	if s.inflight == nil {
+		s.inflight = make(map[instance.Instance]int)
+	}
+	s.inflight[process]++
+	s.recycle(process)
+}
+
+// vacate records a finished request of the instance and hands the free slots over to the queue,
+// it must be called with the set lock held
+func (s *instanceSet) vacate(process instance.Instance) {
+	s.inflight[process]--
+	if s.inflight[process] <= 0 {
+		delete(s.inflight, process)
+		if _, draining := s.draining[process]; draining {
+			s.drained(process)
+		} else if _, existed := s.idleSince[process]; existed {
+			s.markIdle(process)
+		}
+	}
+	s.dispatch()
+}
+
+// dispatch hands the available instances over to the queued requests in FIFO order,
+// it must be called with the set lock held
+func (s *instanceSet) dispatch() {
+	for len(s.queue) > 0 {
+		process := s.choose()
+		if process == nil {
+			return
+		}
+		s.occupy(process)
+		waiter := s.queue[0]
+		s.queue = s.queue[1:]
+		waiter <- process
+	}
+}
+
+// dequeue removes the waiter from the queue, it returns false if the waiter is not in the queue.
+// dequeue must be called with the set lock held
+func (s *instanceSet) dequeue(waiter chan instance.Instance) bool {
+	for i, item := range s.queue {
+		if item == waiter {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package fnscheduler
+
+import (
+	"time"
+
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+	"go.uber.org/zap"
+)
+
+// recycle counts a new request of the instance and retires the instance if it reaches
+// the max requests or the max lifetime of the function, it works around the leaking plugins.
+// recycle must be called with the set lock held
+func (s *instanceSet) recycle(ins instance.Instance) {
+	if s.config.maxRequests <= 0 && s.config.maxLifetime <= 0 {
+		return
+	}
+	if s.served == nil {
+		s.served = make(map[instance.Instance]int)
+	}
+	s.served[ins]++
+	if s.config.maxRequests > 0 && s.served[ins] >= s.config.maxRequests {
+		s.retire(ins, "max requests")
+		return
+	}
+	if s.config.maxLifetime > 0 {
+		if start := ins.Metrics().StartTime; !start.IsZero() && time.Since(start) >= s.config.maxLifetime {
+			s.retire(ins, "max lifetime")
+		}
+	}
+}
+
+// retire marks the instance retiring and starts its replacement in the background,
+// so the request which retires the instance doesn't wait for the cold start of the replacement.
+// The instance keeps serving until the replacement is ready, then it's drained and released once
+// its requests finish. If the replacement can't be created, the instance is retired again by its next request.
+// retire must be called with the set lock held
+func (s *instanceSet) retire(ins instance.Instance, reason string) {
+	if _, retiring := s.retiring[ins]; retiring {
+		return
+	}
+	if s.inBackoff() {
+		return
+	}
+	zap.S().Infow("retire instance", "function", s.functionName, "reason", reason, "served", s.served[ins])
+	if s.retiring == nil {
+		s.retiring = make(map[instance.Instance]struct{})
+	}
+	s.retiring[ins] = struct{}{}
+	go s.replace(ins)
+}
+
+// replace creates the replacement of the retiring instance (param1),
+// the instance is no longer retiring if the replacement can't be created
+func (s *instanceSet) replace(ins instance.Instance) {
+	release, err := fs.canCreateInstance(s.functionName)
+	s.Lock()
+	defer s.Unlock()
+	if err != nil {
+		zap.S().Warnw("function scheduler refuses to create the replacement", "function", s.functionName,
+			"reason", err)
+		delete(s.retiring, ins)
+		return
+	}
+	if _, retiring := s.retiring[ins]; !retiring {
+		// the instance has been scaled down or has crashed
+		release()
+		return
+	}
+	_, err = s.startInstance(s.functionName, release, func(newIns instance.Instance) {
+		if _, retiring := s.retiring[ins]; !retiring {
+			// the instance has been scaled down or has crashed
+			return
+		}
+		if !newIns.IsRunning() {
+			delete(s.retiring, ins)
+			return
+		}
+		s.drain(ins)
+	})
+	if err != nil {
+		delete(s.retiring, ins)
+	}
+}
+
+// forget removes the records of the instance which is no longer in the set,
+// it must be called with the set lock held
+func (s *instanceSet) forget(ins instance.Instance) {
+	delete(s.idleSince, ins)
+	delete(s.served, ins)
+	delete(s.retiring, ins)
+}
@@ -0,0 +1,197 @@
+package fnscheduler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
+	"github.com/tass-io/scheduler/pkg/utils/resourceutils"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInsufficientResource = errors.New("insufficient node resource")
+
+	budget     *resourceBudget
+	budgetOnce = &sync.Once{}
+	// memoryLimitFiles are the files to detect the memory capacity, cgroup v2 first
+	memoryLimitFiles = []string{
+		"/sys/fs/cgroup/memory.max",
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	}
+)
+
+// ResourceError explains why the resource create policy refuses the creation
+type ResourceError struct {
+	FunctionName string
+	// Resource is "cpu" in millicores or "memory" in bytes
+	Resource string
+	Request  int64
+	Reserved int64
+	Capacity int64
+}
+
+// Error returns the reason of the refusal
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("%s of function %s requests %s %d, but %d of %d has been reserved",
+		ErrInsufficientResource, e.FunctionName, e.Resource, e.Request, e.Reserved, e.Capacity)
+}
+
+// Is makes errors.Is(err, ErrInsufficientResource) work for all resource errors
+func (e *ResourceError) Is(target error) bool {
+	return target == ErrInsufficientResource
+}
+
+// resourceBudget tracks the node capacity and the resources reserved by the instances
+type resourceBudget struct {
+	sync.Locker
+	// cpu is in millicores and memory is in bytes, 0 means the capacity is unknown and not limited
+	cpu            int64
+	memory         int64
+	reservedCPU    int64
+	reservedMemory int64
+}
+
+// getResourceBudget returns the resource budget of the node, it's initialized at the first call
+func getResourceBudget() *resourceBudget {
+	budgetOnce.Do(func() {
+		budget = newResourceBudget(detectCPU(), detectMemory())
+		zap.S().Infow("resource budget initialized", "cpu", budget.cpu, "memory", budget.memory)
+		if budget.memory == 0 {
+			zap.S().Warn("memory capacity is unknown, the memory requests are not limited")
+		}
+	})
+	return budget
+}
+
+// newResourceBudget returns a budget with the capacity
+func newResourceBudget(cpu, memory int64) *resourceBudget {
+	return &resourceBudget{
+		Locker: &sync.Mutex{},
+		cpu:    cpu,
+		memory: memory,
+	}
+}
+
+// reserve reserves the resources the Function requests,
+// it returns a ResourceError if the node budget is exhausted.
+// The release function gives back the resources and retries the refused creations, it's idempotent.
+func (b *resourceBudget) reserve(functionName string) (func(), error) {
+	cpu, memory, err := functionResource(functionName)
+	if err != nil {
+		return nil, err
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.cpu > 0 && b.reservedCPU+cpu > b.cpu {
+		return nil, &ResourceError{FunctionName: functionName, Resource: "cpu",
+			Request: cpu, Reserved: b.reservedCPU, Capacity: b.cpu}
+	}
+	if b.memory > 0 && b.reservedMemory+memory > b.memory {
+		return nil, &ResourceError{FunctionName: functionName, Resource: "memory",
+			Request: memory, Reserved: b.reservedMemory, Capacity: b.memory}
+	}
+	b.reservedCPU += cpu
+	b.reservedMemory += memory
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			b.Lock()
+			b.reservedCPU -= cpu
+			b.reservedMemory -= memory
+			b.Unlock()
+			if fs != nil {
+				go fs.retryRefused()
+			}
+		})
+	}, nil
+}
+
+// functionResource returns the cpu in millicores and the memory in bytes the Function requests.
+// This method is extracted as a helper function to mock the Function in test injection.
+var functionResource = func(functionName string) (int64, int64, error) {
+	function, existed, err := k8sutils.GetFunctionByName(functionName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !existed {
+		return 0, 0, fmt.Errorf("function %s not found", functionName)
+	}
+	cpu, err := resourceutils.ParseCPU(function.Spec.Resource.ResourceCPU)
+	if err != nil {
+		return 0, 0, err
+	}
+	memory, err := resourceutils.ParseMemory(function.Spec.Resource.ResourceMemory)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cpu, memory, nil
+}
+
+// detectCPU returns the cpu capacity in millicores from the flag or the number of cpus
+func detectCPU() int64 {
+	if raw := viper.GetString(env.NodeCPU); raw != "" {
+		cpu, err := resourceutils.ParseCPU(raw)
+		if err == nil {
+			return cpu
+		}
+		zap.S().Warnw("invalid node cpu, detect it instead", "cpu", raw, "err", err)
+	}
+	return int64(runtime.NumCPU()) * 1000
+}
+
+// detectMemory returns the memory capacity in bytes from the flag, the cgroup limit or the total memory,
+// it returns 0 if the capacity is unknown
+func detectMemory() int64 {
+	if raw := viper.GetString(env.NodeMemory); raw != "" {
+		memory, err := resourceutils.ParseMemory(raw)
+		if err == nil {
+			return memory
+		}
+		zap.S().Warnw("invalid node memory, detect it instead", "memory", raw, "err", err)
+	}
+	total := totalMemory()
+	for _, file := range memoryLimitFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+		// "max" or a huge number means no limit
+		if err != nil || (total > 0 && limit >= total) {
+			break
+		}
+		return limit
+	}
+	return total
+}
+
+// totalMemory reads MemTotal from /proc/meminfo, it returns 0 if it fails
+func totalMemory() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
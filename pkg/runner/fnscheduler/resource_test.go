@@ -0,0 +1,144 @@
+package fnscheduler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/event"
+	eventschedule "github.com/tass-io/scheduler/pkg/event/schedule"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+)
+
+func TestResourceBudget(t *testing.T) {
+	Convey("test resource budget reserves and releases resources", t, func() {
+		origin := functionResource
+		functionResource = func(functionName string) (int64, int64, error) {
+			if functionName == "big" {
+				return 1000, 1024, nil
+			}
+			return 500, 512, nil
+		}
+		defer func() {
+			functionResource = origin
+		}()
+		b := newResourceBudget(1500, 2048)
+
+		releaseBig, err := b.reserve("big")
+		So(err, ShouldBeNil)
+		releaseSmall, err := b.reserve("small")
+		So(err, ShouldBeNil)
+
+		_, err = b.reserve("small")
+		So(errors.Is(err, ErrInsufficientResource), ShouldBeTrue)
+		resourceErr := &ResourceError{}
+		So(errors.As(err, &resourceErr), ShouldBeTrue)
+		So(*resourceErr, ShouldResemble, ResourceError{
+			FunctionName: "small",
+			Resource:     "cpu",
+			Request:      500,
+			Reserved:     1500,
+			Capacity:     1500,
+		})
+
+		// releasing twice gives back the resources only once
+		releaseSmall()
+		releaseSmall()
+		So(b.reservedCPU, ShouldEqual, 1000)
+		_, err = b.reserve("small")
+		So(err, ShouldBeNil)
+
+		releaseBig()
+		So(b.reservedCPU, ShouldEqual, 500)
+		So(b.reservedMemory, ShouldEqual, 512)
+
+		Convey("the unknown memory capacity is not limited", func() {
+			b := newResourceBudget(1500, 0)
+			_, err := b.reserve("big")
+			So(err, ShouldBeNil)
+			So(b.reservedMemory, ShouldEqual, 1024)
+		})
+	})
+}
+
+func TestInstanceSet_ColdStartRefused(t *testing.T) {
+	Convey("test the cold start request is told why the creation is refused", t, func() {
+		refusal := &ResourceError{FunctionName: "a", Resource: "memory", Request: 1024, Capacity: 512}
+		refusing := true
+		canCreatePolicies["refuse"] = func(string) (func(), error) {
+			if refusing {
+				return nil, refusal
+			}
+			return func() {}, nil
+		}
+		viper.Set(env.CreatePolicy, "refuse")
+		handler := &fakeScheduleHandler{events: make(chan event.ScheduleEvent, 10)}
+		originHandler := eventschedule.GetScheduleHandlerIns
+		eventschedule.GetScheduleHandlerIns = func() event.Handler {
+			return handler
+		}
+		if event.GetHandlerBySource(event.MetricsSource) == nil {
+			event.Register(event.MetricsSource, &fakeScheduleHandler{events: make(chan event.ScheduleEvent, 100)}, 0, true)
+		}
+		originInstance := NewInstance
+		NewInstance = instance.NewMockInstance
+		originFs := fs
+		fs = &FunctionScheduler{Locker: &sync.Mutex{}, instances: map[string]*instanceSet{}}
+		defer func() {
+			delete(canCreatePolicies, "refuse")
+			viper.Set(env.CreatePolicy, "default")
+			eventschedule.GetScheduleHandlerIns = originHandler
+			NewInstance = originInstance
+			fs = originFs
+		}()
+		s := newInstanceSet("a")
+		fs.instances["a"] = s
+		// wait starts a cold start request and returns when it's waiting
+		wait := func() chan error {
+			done := make(chan error, 1)
+			go func() {
+				done <- s.functionColdStartDone()
+			}()
+			for {
+				s.Lock()
+				waiting := s.coldStartWaiting
+				s.Unlock()
+				if waiting {
+					return done
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		Convey("the waiting request receives the refusal", func() {
+			done := wait()
+			s.Scale(1, "a")
+			So(s.refused, ShouldEqual, 1)
+			err := <-done
+			So(errors.Is(err, ErrInsufficientResource), ShouldBeTrue)
+			So(err, ShouldEqual, refusal)
+		})
+
+		Convey("the refusal without a waiting request is not left for the retry", func() {
+			s.Scale(1, "a")
+			So(s.refused, ShouldEqual, 1)
+			So(s.coldStartDone, ShouldBeEmpty)
+			// the request arriving before the retry is told the reason
+			So(s.functionColdStartDone(), ShouldEqual, refusal)
+
+			// the resources are released, so the creation is retried and succeeds
+			refusing = false
+			fs.retryRefused()
+			retry := <-handler.events
+			So(retry.Target, ShouldEqual, 1)
+			done := wait()
+			s.Scale(retry.Target, "a")
+			So(<-done, ShouldBeNil)
+			So(s.Stats(), ShouldEqual, 1)
+		})
+	})
+}
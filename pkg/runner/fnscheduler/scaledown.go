@@ -0,0 +1,178 @@
+package fnscheduler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultScaleDownPolicy        = "default"
+	IdleLongestScaleDownPolicy    = "idle-longest"
+	FewestInFlightScaleDownPolicy = "fewest-in-flight"
+	OldestFirstScaleDownPolicy    = "oldest-first"
+)
+
+var (
+	scaleDownPolicies = map[string]ScaleDownPolicy{
+		DefaultScaleDownPolicy:        lowestScorePolicy,
+		IdleLongestScaleDownPolicy:    idleLongestPolicy,
+		FewestInFlightScaleDownPolicy: fewestInFlightPolicy,
+		OldestFirstScaleDownPolicy:    oldestFirstPolicy,
+	}
+)
+
+// ScaleDownCandidate is the state of an instance when the function scales down
+type ScaleDownCandidate struct {
+	Instance instance.Instance
+	Score    int
+	// InFlight is the number of requests the instance is handling
+	InFlight int
+	// IdleSince is when the latest request of the instance finished, or when the instance was created
+	IdleSince time.Time
+	StartTime time.Time
+}
+
+// ScaleDownPolicy returns whether the candidate (param1) should be released before the other one (param2),
+// the candidates in the same order are released in the creation order
+type ScaleDownPolicy func(a, b ScaleDownCandidate) bool
+
+// RegisterScaleDownPolicy registers a custom ScaleDownPolicy which can be chosen by the startup parameter,
+// it should be called before the scheduler starts
+func RegisterScaleDownPolicy(name string, policy ScaleDownPolicy) {
+	scaleDownPolicies[name] = policy
+}
+
+// getScaleDownPolicy returns the ScaleDownPolicy chosen by the startup parameter,
+// the default policy is returned if it's unknown
+func getScaleDownPolicy() ScaleDownPolicy {
+	policy, existed := scaleDownPolicies[viper.GetString(env.ScaleDownPolicy)]
+	if !existed {
+		return scaleDownPolicies[DefaultScaleDownPolicy]
+	}
+	return policy
+}
+
+// lowestScorePolicy releases the instance with the lowest score first
+func lowestScorePolicy(a, b ScaleDownCandidate) bool {
+	return a.Score < b.Score
+}
+
+// idleLongestPolicy releases the idle instances first, the one idle for the longest time goes first
+func idleLongestPolicy(a, b ScaleDownCandidate) bool {
+	if (a.InFlight == 0) != (b.InFlight == 0) {
+		return a.InFlight == 0
+	}
+	if a.InFlight != b.InFlight {
+		return a.InFlight < b.InFlight
+	}
+	return a.IdleSince.Before(b.IdleSince)
+}
+
+// fewestInFlightPolicy releases the instance handling the fewest requests first
+func fewestInFlightPolicy(a, b ScaleDownCandidate) bool {
+	if a.InFlight != b.InFlight {
+		return a.InFlight < b.InFlight
+	}
+	return a.IdleSince.Before(b.IdleSince)
+}
+
+// oldestFirstPolicy releases the instance started first, it rotates the processes
+func oldestFirstPolicy(a, b ScaleDownCandidate) bool {
+	return a.StartTime.Before(b.StartTime)
+}
+
+// victims returns n instances to release in the order of the scale-down policy,
+// the retiring instances go first because they're being replaced.
+// victims must be called with the set lock held
+func (s *instanceSet) victims(n int) []instance.Instance {
+	candidates := make([]ScaleDownCandidate, 0, len(s.instances))
+	for _, ins := range s.instances {
+		candidates = append(candidates, ScaleDownCandidate{
+			Instance:  ins,
+			Score:     ins.Score(),
+			InFlight:  s.inflight[ins],
+			IdleSince: s.idleSince[ins],
+			StartTime: ins.Metrics().StartTime,
+		})
+	}
+	policy := getScaleDownPolicy()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		_, a := s.retiring[candidates[i].Instance]
+		_, b := s.retiring[candidates[j].Instance]
+		if a != b {
+			return a
+		}
+		return policy(candidates[i], candidates[j])
+	})
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	victims := make([]instance.Instance, 0, n)
+	for _, candidate := range candidates[:n] {
+		victims = append(victims, candidate.Instance)
+	}
+	return victims
+}
+
+// drain removes the instance from the set so that no more requests are routed to it,
+// the instance is released when its in-flight requests finish or the drain timeout exceeds.
+// drain must be called with the set lock held
+func (s *instanceSet) drain(ins instance.Instance) {
+	for i, item := range s.instances {
+		if item == ins {
+			s.instances = append(s.instances[:i], s.instances[i+1:]...)
+			break
+		}
+	}
+	s.forget(ins)
+	if s.inflight[ins] == 0 {
+		s.releaseInstance(ins)
+		return
+	}
+	zap.S().Infow("drain instance", "function", s.functionName, "inflight", s.inflight[ins])
+	if s.draining == nil {
+		s.draining = make(map[instance.Instance]*time.Timer)
+	}
+	var timer *time.Timer
+	if timeout := viper.GetDuration(env.DrainTimeout); timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			s.Lock()
+			defer s.Unlock()
+			if _, draining := s.draining[ins]; draining {
+				zap.S().Warnw("drain timeout, release the busy instance", "function", s.functionName,
+					"inflight", s.inflight[ins])
+				s.drained(ins)
+			}
+		})
+	}
+	s.draining[ins] = timer
+}
+
+// drained releases the draining instance, it must be called with the set lock held
+func (s *instanceSet) drained(ins instance.Instance) {
+	if timer := s.draining[ins]; timer != nil {
+		timer.Stop()
+	}
+	delete(s.draining, ins)
+	s.releaseInstance(ins)
+}
+
+// releaseInstance terminates the instance removed from the set, it must be called with the set lock held
+func (s *instanceSet) releaseInstance(ins instance.Instance) {
+	ins.Release()
+	zap.S().Debugw("fnscheduler release instance")
+	s.ttl.Release(ins)
+}
+
+// markIdle records when the instance becomes idle, it must be called with the set lock held
+func (s *instanceSet) markIdle(ins instance.Instance) {
+	if s.idleSince == nil {
+		s.idleSince = make(map[instance.Instance]time.Time)
+	}
+	s.idleSince[ins] = time.Now()
+}
@@ -0,0 +1,144 @@
+package instance
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// cpuPeriod is the cpu.max period in microseconds
+	cpuPeriod = 100000
+	// cgroupControllers are the controllers enabled for the child groups
+	cgroupControllers = "+cpu +memory"
+	// usageInterval is the interval to sample the resource usage of a process instance for its metrics
+	usageInterval = 5 * time.Second
+)
+
+// CgroupStats is the resource usage of a process instance read from its cgroup
+type CgroupStats struct {
+	// CPUUsage is the total cpu time in microseconds
+	CPUUsage int64
+	// MemoryCurrent is the memory usage in bytes
+	MemoryCurrent int64
+	// MemoryPeak is the max memory usage in bytes, it's 0 if the kernel doesn't support it
+	MemoryPeak int64
+	// OOMKills is the number of processes killed by the OOM killer in the cgroup
+	OOMKills int64
+}
+
+// cgroup is a cgroup v2 child group of a process instance
+type cgroup struct {
+	path string
+}
+
+// newCgroup creates a child group named name (param2) under the root group (param1),
+// the cpu and memory controllers are enabled in the root group first
+func newCgroup(root, name string) (*cgroup, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	if err := writeCgroupFile(filepath.Join(root, "cgroup.subtree_control"), cgroupControllers); err != nil {
+		// the controllers may not be delegated to the root, the limits fail later if so
+		zap.S().Warnw("enable cgroup controllers error", "root", root, "err", err)
+	}
+	path := filepath.Join(root, name)
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	return &cgroup{path: path}, nil
+}
+
+// setCPU writes cpu.max, millicores (param1) 0 means no limit
+func (c *cgroup) setCPU(millicores int64) error {
+	quota := "max"
+	if millicores > 0 {
+		quota = strconv.FormatInt(millicores*cpuPeriod/1000, 10)
+	}
+	return writeCgroupFile(filepath.Join(c.path, "cpu.max"), fmt.Sprintf("%s %d", quota, cpuPeriod))
+}
+
+// setMemory writes memory.max, bytes (param1) 0 means no limit
+func (c *cgroup) setMemory(bytes int64) error {
+	limit := "max"
+	if bytes > 0 {
+		limit = strconv.FormatInt(bytes, 10)
+	}
+	return writeCgroupFile(filepath.Join(c.path, "memory.max"), limit)
+}
+
+// addProcess moves the process into the cgroup
+func (c *cgroup) addProcess(pid int) error {
+	return writeCgroupFile(filepath.Join(c.path, "cgroup.procs"), strconv.Itoa(pid))
+}
+
+// stats reads the resource usage and the OOM events from the cgroup files
+func (c *cgroup) stats() (CgroupStats, error) {
+	stats := CgroupStats{}
+	cpuStat, err := readCgroupKeyValues(filepath.Join(c.path, "cpu.stat"))
+	if err != nil {
+		return stats, err
+	}
+	stats.CPUUsage = cpuStat["usage_usec"]
+	stats.MemoryCurrent, err = readCgroupInt(filepath.Join(c.path, "memory.current"))
+	if err != nil {
+		return stats, err
+	}
+	// memory.peak is only supported since Linux 5.19
+	stats.MemoryPeak, _ = readCgroupInt(filepath.Join(c.path, "memory.peak"))
+	events, err := readCgroupKeyValues(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return stats, err
+	}
+	stats.OOMKills = events["oom_kill"]
+	return stats, nil
+}
+
+// remove removes the cgroup, it must be called after all processes in the cgroup exit
+func (c *cgroup) remove() error {
+	return os.Remove(c.path)
+}
+
+// writeCgroupFile writes the content to a cgroup file
+func writeCgroupFile(file, content string) error {
+	return ioutil.WriteFile(file, []byte(content), 0644)
+}
+
+// readCgroupInt reads a single value cgroup file like memory.current
+func readCgroupInt(file string) (int64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// readCgroupKeyValues reads a flat keyed cgroup file like cpu.stat and memory.events
+func readCgroupKeyValues(file string) (map[string]int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result := map[string]int64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		result[fields[0]] = value
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,55 @@
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCgroup(t *testing.T) {
+	Convey("test cgroup writes the limits and reads the usage", t, func() {
+		root, err := ioutil.TempDir("", "cgroup")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		read := func(file string) string {
+			content, err := ioutil.ReadFile(file)
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+
+		cg, err := newCgroup(root, "process")
+		So(err, ShouldBeNil)
+		So(read(filepath.Join(root, "cgroup.subtree_control")), ShouldEqual, "+cpu +memory")
+
+		So(cg.setCPU(2000), ShouldBeNil)
+		So(read(filepath.Join(cg.path, "cpu.max")), ShouldEqual, "200000 100000")
+		So(cg.setCPU(0), ShouldBeNil)
+		So(read(filepath.Join(cg.path, "cpu.max")), ShouldEqual, "max 100000")
+		So(cg.setMemory(100*1024*1024), ShouldBeNil)
+		So(read(filepath.Join(cg.path, "memory.max")), ShouldEqual, "104857600")
+		So(cg.addProcess(42), ShouldBeNil)
+		So(read(filepath.Join(cg.path, "cgroup.procs")), ShouldEqual, "42")
+
+		// the kernel provides these files in a real cgroup
+		files := map[string]string{
+			"cpu.stat":       "usage_usec 1500\nuser_usec 1000\nsystem_usec 500\n",
+			"memory.current": "4096\n",
+			"memory.events":  "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		}
+		for name, content := range files {
+			So(ioutil.WriteFile(filepath.Join(cg.path, name), []byte(content), 0644), ShouldBeNil)
+		}
+		stats, err := cg.stats()
+		So(err, ShouldBeNil)
+		So(stats, ShouldResemble, CgroupStats{CPUUsage: 1500, MemoryCurrent: 4096, OOMKills: 1})
+
+		// the usage is sampled into the metrics of the instance
+		i := &processInstance{lock: &sync.Mutex{}, responseMapping: map[string]chan map[string]interface{}{}, cgroup: cg}
+		i.sampleUsage()
+		So(i.Metrics().Usage, ShouldResemble, stats)
+	})
+}
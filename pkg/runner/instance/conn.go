@@ -42,6 +42,8 @@ var (
 type FunctionRequest struct {
 	ID         string                 `json:"id"`
 	Parameters map[string]interface{} `json:"parameters"`
+	// Control is set if the request is a control message to the runtime rather than a function request
+	Control *Control `json:"control,omitempty"`
 }
 
 // NewFunctionRequest returns a new function request with unique id and parameters
@@ -142,6 +144,8 @@ func (c *Consumer) Start() {
 		zap.S().Debug("no more requests")
 		c.noNewInfo = true
 		c.f.Close()
+		// the consumer is the only sender, closing the channel lets the listener know the process is gone
+		close(c.responseChannel)
 	}()
 }
 
@@ -1,17 +1,85 @@
 package instance
 
 import (
+	"context"
 	"errors"
+	"fmt"
 )
 
-var ErrInstanceNotService = errors.New("instance not service")
+var (
+	ErrInstanceNotService = errors.New("instance not service")
+	ErrInstanceCrashed    = errors.New("instance crashed")
+	ErrNoCgroup           = errors.New("instance has no cgroup")
+	ErrInvalidSandbox     = errors.New("invalid sandbox")
+)
+
+// FunctionError is the error returned by the user function,
+// it's distinguished from the errors of the scheduler itself
+type FunctionError struct {
+	Message string
+}
+
+// Error returns the error message from the user function
+func (e *FunctionError) Error() string {
+	return e.Message
+}
+
+// ErrorClass classifies the errors of a Function invocation, it's used to decide whether to retry
+type ErrorClass string
+
+const (
+	// FunctionErrorClass is the error returned by the user function
+	FunctionErrorClass ErrorClass = "function"
+	// SystemErrorClass is the error from the scheduler, like no instances and remote call failures
+	SystemErrorClass ErrorClass = "system"
+)
+
+// ClassifyError returns the ErrorClass of the error
+func ClassifyError(err error) ErrorClass {
+	var fnErr *FunctionError
+	if errors.As(err, &fnErr) {
+		return FunctionErrorClass
+	}
+	return SystemErrorClass
+}
+
+// CrashError is the error returned to the requests which are still pending
+// when the instance exits unexpectedly
+type CrashError struct {
+	FunctionName string
+	InstanceID   string
+	// Err is the exit error of the instance, it may be nil if the instance exits with code 0
+	Err error
+	// OOMKilled is whether the instance is killed by the OOM killer of its cgroup
+	OOMKilled bool
+}
+
+// Error returns the crash message with the exit error
+func (e *CrashError) Error() string {
+	if e.OOMKilled {
+		return fmt.Sprintf("instance %s of function %s is OOM killed: %v", e.InstanceID, e.FunctionName, e.Err)
+	}
+	return fmt.Sprintf("instance %s of function %s crashed: %v", e.InstanceID, e.FunctionName, e.Err)
+}
+
+// Unwrap returns the exit error of the instance
+func (e *CrashError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrInstanceCrashed) work for all crash errors
+func (e *CrashError) Is(target error) bool {
+	return target == ErrInstanceCrashed
+}
 
 // Instance is a function process instance
 type Instance interface {
-	// Invoke invokes an process instance
-	Invoke(parameters map[string]interface{}) (map[string]interface{}, error)
+	// Invoke invokes an process instance, it returns the context error when the context is done
+	Invoke(ctx context.Context, parameters map[string]interface{}) (map[string]interface{}, error)
 	// Score returns the score of the instance, the lower the score, the higher the priority
 	Score() int
+	// Metrics returns the load and the latency of the instance for the score policies
+	Metrics() Metrics
 	// Release terminates the instance
 	Release()
 	// IsRuning returns whether the instance is released or not
@@ -20,6 +88,9 @@ type Instance interface {
 	Start() error
 	// HasRequests returns whether the instance is dealing with requests
 	HasRequests() bool
-	// InitDone returns when the instance initialization done
+	// InitDone returns when the instance initialization done or the instance exits
 	InitDone()
+	// Done returns a channel that's closed when the instance exits,
+	// either after Release or because it crashes
+	Done() <-chan struct{}
 }
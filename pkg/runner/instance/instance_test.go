@@ -1,6 +1,7 @@
 package instance
 
 import (
+	"context"
 	"os/exec"
 	"testing"
 	"time"
@@ -128,7 +129,7 @@ func TestProcessInstance(t *testing.T) {
 			So(err, ShouldBeNil)
 			process.InitDone()
 			for i := 1; i < 50; i++ {
-				result, err := process.Invoke(testcase.request)
+				result, err := process.Invoke(context.Background(), testcase.request)
 				So(err, ShouldBeNil)
 				So(result, ShouldResemble, testcase.expect)
 			}
@@ -1,6 +1,8 @@
 package instance
 
 import (
+	"context"
+
 	"github.com/tass-io/scheduler/pkg/utils/common"
 	"go.uber.org/zap"
 )
@@ -10,6 +12,7 @@ type mockInstance struct {
 	functionName  string
 	released      bool
 	handleRequest bool
+	done          chan struct{}
 }
 
 func NewMockInstance(functionName string) Instance {
@@ -17,10 +20,14 @@ func NewMockInstance(functionName string) Instance {
 		functionName:  functionName,
 		released:      false,
 		handleRequest: false,
+		done:          make(chan struct{}),
 	}
 }
 
-func (m *mockInstance) Invoke(parameters map[string]interface{}) (map[string]interface{}, error) {
+func (m *mockInstance) Invoke(ctx context.Context, parameters map[string]interface{}) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	output, err := common.CopyMap(parameters)
 	output[m.functionName] = m.functionName
 	m.handleRequest = true
@@ -31,8 +38,15 @@ func (m *mockInstance) Score() int {
 	return 1
 }
 
+func (m *mockInstance) Metrics() Metrics {
+	return Metrics{}
+}
+
 func (m *mockInstance) Release() {
 	zap.S().Debugw("instance mock release")
+	if !m.released {
+		close(m.done)
+	}
 	m.released = true
 }
 
@@ -50,4 +64,8 @@ func (m *mockInstance) HasRequests() bool {
 
 func (m *mockInstance) InitDone() {}
 
+func (m *mockInstance) Done() <-chan struct{} {
+	return m.done
+}
+
 var _ Instance = &mockInstance{}
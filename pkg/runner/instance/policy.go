@@ -0,0 +1,145 @@
+package instance
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/runner"
+)
+
+const (
+	DefaultPolicy          = "default"
+	LeastOutstandingPolicy = "least-outstanding"
+	EWMAPolicy             = "ewma"
+	RoundRobinPolicy       = "round-robin"
+	P2CPolicy              = "p2c"
+
+	// latencyDecay is the weight of the latest latency in the EWMA
+	latencyDecay = 0.3
+)
+
+var (
+	policies = map[string]ScorePolicy{
+		DefaultPolicy:          {Score: defaultPolicyfunc},
+		LeastOutstandingPolicy: {Score: leastOutstandingPolicyfunc},
+		EWMAPolicy:             {Score: ewmaPolicyfunc},
+		RoundRobinPolicy:       {Score: roundRobinPolicyfunc},
+		P2CPolicy:              {Score: leastOutstandingPolicyfunc, Choices: 2},
+	}
+	// invokeSequence is increased by each invocation of all instances, it orders the invocations
+	invokeSequence uint64
+	// functionLatency is the EWMA of the invoke latency observed by all instances of each function
+	functionLatency     = map[string]time.Duration{}
+	functionLatencyLock = &sync.Mutex{}
+)
+
+// Metrics is the load and the latency of an instance used by the score policies
+type Metrics struct {
+	StartTime time.Time
+	// Outstanding is the number of the requests the instance is handling
+	Outstanding int
+	// Latency is the EWMA of the observed invoke latency, it's 0 before the first request returns
+	Latency time.Duration
+	// PeerLatency is the EWMA of the invoke latency of all instances of the function,
+	// it's 0 before any request of the function returns
+	PeerLatency time.Duration
+	// LastInvoked is the sequence number of the latest request of the instance, 0 if it's never invoked
+	LastInvoked uint64
+	// Usage is the resource usage sampled from the cgroup of the instance, it's zero without a cgroup
+	Usage CgroupStats
+}
+
+// ScorePolicy calculates the score of an instance by its metrics, the lower the score, the higher the priority
+type ScorePolicy struct {
+	Score func(m Metrics) int
+	// Choices is the number of the running instances picked randomly to compare when choosing the target,
+	// all running instances are compared if it's 0
+	Choices int
+}
+
+// RegisterScorePolicy registers a custom ScorePolicy which can be chosen by the startup parameter,
+// it should be called before the scheduler starts
+func RegisterScorePolicy(name string, policy ScorePolicy) {
+	policies[name] = policy
+}
+
+// GetScorePolicy returns the ScorePolicy chosen by the startup parameter, the default policy is returned if it's unknown
+func GetScorePolicy() ScorePolicy {
+	policy, existed := policies[viper.GetString(env.InstanceScorePolicy)]
+	if !existed {
+		return policies[DefaultPolicy]
+	}
+	return policy
+}
+
+// defaultPolicyfunc is a naive policy to calculate the score,
+// the longer the process instance lives, the higher the score is.
+func defaultPolicyfunc(m Metrics) int {
+	now := time.Now().Unix()
+	birthday := m.StartTime.Unix() // happy birthday for LittleDrizzle. He wrote this line at the birthday (smddx)
+	return clampScore(now - birthday)
+}
+
+// leastOutstandingPolicyfunc prefers the instance handling the fewest requests
+func leastOutstandingPolicyfunc(m Metrics) int {
+	return m.Outstanding
+}
+
+// ewmaPolicyfunc prefers the instance with the lowest latency, the latency is weighted by the outstanding requests
+// so that the fastest instance is not flooded. An instance without any latency observed is assumed
+// as fast as its peers, and the outstanding requests are compared when the function has no latency at all
+func ewmaPolicyfunc(m Metrics) int {
+	latency := m.Latency
+	if latency == 0 {
+		latency = m.PeerLatency
+	}
+	if latency == 0 {
+		return m.Outstanding
+	}
+	return clampScore(latency.Microseconds() * int64(m.Outstanding+1))
+}
+
+// roundRobinPolicyfunc prefers the instance invoked least recently
+func roundRobinPolicyfunc(m Metrics) int {
+	since := atomic.LoadUint64(&invokeSequence) - m.LastInvoked
+	if since >= uint64(runner.MaxScore) {
+		return 0
+	}
+	return runner.MaxScore - 1 - int(since)
+}
+
+// ewma returns the average (param1) updated by the latest latency (param2), the first latency is taken as it is
+func ewma(average, latency time.Duration) time.Duration {
+	if average == 0 {
+		return latency
+	}
+	return time.Duration(latencyDecay*float64(latency) + (1-latencyDecay)*float64(average))
+}
+
+// observeFunctionLatency updates the EWMA of the invoke latency of the function
+func observeFunctionLatency(functionName string, latency time.Duration) {
+	functionLatencyLock.Lock()
+	defer functionLatencyLock.Unlock()
+	functionLatency[functionName] = ewma(functionLatency[functionName], latency)
+}
+
+// getFunctionLatency returns the EWMA of the invoke latency of the function, 0 if nothing is observed
+func getFunctionLatency(functionName string) time.Duration {
+	functionLatencyLock.Lock()
+	defer functionLatencyLock.Unlock()
+	return functionLatency[functionName]
+}
+
+// clampScore keeps the score of a running instance lower than runner.MaxScore
+func clampScore(score int64) int {
+	if score < 0 {
+		return 0
+	}
+	if score >= int64(runner.MaxScore) {
+		return runner.MaxScore - 1
+	}
+	return int(score)
+}
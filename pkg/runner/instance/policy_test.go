@@ -0,0 +1,78 @@
+package instance
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+)
+
+func TestScorePolicy(t *testing.T) {
+	Convey("test the instance score policies", t, func() {
+		defer viper.Set(env.InstanceScorePolicy, "")
+		score := func(name string, m Metrics) int {
+			viper.Set(env.InstanceScorePolicy, name)
+			return GetScorePolicy().Score(m)
+		}
+
+		Convey("the default policy prefers the younger instance", func() {
+			old := Metrics{StartTime: time.Now().Add(-time.Hour)}
+			young := Metrics{StartTime: time.Now()}
+			So(score(DefaultPolicy, young), ShouldBeLessThan, score(DefaultPolicy, old))
+			So(score("unknown", young), ShouldEqual, score(DefaultPolicy, young))
+		})
+
+		Convey("the least-outstanding policy prefers the idle instance", func() {
+			So(score(LeastOutstandingPolicy, Metrics{Outstanding: 1}), ShouldBeLessThan,
+				score(LeastOutstandingPolicy, Metrics{Outstanding: 3}))
+			viper.Set(env.InstanceScorePolicy, P2CPolicy)
+			So(GetScorePolicy().Choices, ShouldEqual, 2)
+		})
+
+		Convey("the ewma policy weights the latency by the outstanding requests", func() {
+			fast := Metrics{Latency: 10 * time.Millisecond, Outstanding: 3}
+			slow := Metrics{Latency: 20 * time.Millisecond}
+			So(score(EWMAPolicy, slow), ShouldBeLessThan, score(EWMAPolicy, fast))
+			So(score(EWMAPolicy, Metrics{}), ShouldEqual, 0)
+		})
+
+		Convey("the ewma policy scores the fresh instance by the latency of its peers", func() {
+			fresh := Metrics{PeerLatency: 10 * time.Millisecond, Outstanding: 3}
+			slow := Metrics{Latency: 20 * time.Millisecond}
+			So(score(EWMAPolicy, slow), ShouldBeLessThan, score(EWMAPolicy, fresh))
+			// nothing is observed for the function, the outstanding requests are compared
+			So(score(EWMAPolicy, Metrics{Outstanding: 1}), ShouldBeLessThan, score(EWMAPolicy, Metrics{Outstanding: 2}))
+		})
+
+		Convey("the round-robin policy prefers the instance invoked least recently", func() {
+			first := Metrics{LastInvoked: atomic.AddUint64(&invokeSequence, 1)}
+			second := Metrics{LastInvoked: atomic.AddUint64(&invokeSequence, 1)}
+			So(score(RoundRobinPolicy, first), ShouldBeLessThan, score(RoundRobinPolicy, second))
+			So(score(RoundRobinPolicy, Metrics{}), ShouldBeLessThan, score(RoundRobinPolicy, first))
+		})
+
+		Convey("the custom policy can be registered", func() {
+			RegisterScorePolicy("constant", ScorePolicy{Score: func(Metrics) int { return 42 }})
+			defer delete(policies, "constant")
+			So(score("constant", Metrics{}), ShouldEqual, 42)
+		})
+
+		Convey("the process instance updates the latency by EWMA", func() {
+			i := &processInstance{lock: &sync.Mutex{}, functionName: "ewma",
+				responseMapping: map[string]chan map[string]interface{}{}}
+			peer := &processInstance{lock: &sync.Mutex{}, functionName: "ewma",
+				responseMapping: map[string]chan map[string]interface{}{}}
+			defer delete(functionLatency, "ewma")
+			i.observeLatency(100 * time.Millisecond)
+			So(i.Metrics().Latency, ShouldEqual, 100*time.Millisecond)
+			i.observeLatency(200 * time.Millisecond)
+			So(i.Metrics().Latency, ShouldEqual, 130*time.Millisecond)
+			So(peer.Metrics().Latency, ShouldEqual, 0)
+			So(peer.Metrics().PeerLatency, ShouldEqual, 130*time.Millisecond)
+		})
+	})
+}
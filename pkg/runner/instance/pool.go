@@ -0,0 +1,210 @@
+package instance
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/rs/xid"
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"go.uber.org/zap"
+)
+
+// The runtime pool keeps some runtime processes which have done the pipe handshake without any plugin loaded.
+// An instance of a Function takes a pre-warmed runtime from the pool and binds it by a control message
+// to load the plugin, so the process spawn and the pipe setup are removed from the cold start.
+// The sandboxed Functions don't use the pool because their namespaces are set when the process is spawned.
+
+var (
+	pool     *Pool
+	poolOnce = &sync.Once{}
+
+	// runtimeCommand returns the command of a runtime process without any plugin.
+	// This method is extracted as a helper function to mock the runtime in test injection.
+	runtimeCommand = func() *exec.Cmd {
+		cmd := exec.Command(env.TassFileRoot + "runtime")
+		// the pooled runtime has the same base namespaces as a forked function process
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: baseCloneflags}
+		return cmd
+	}
+)
+
+// Control is a control message from the scheduler to the runtime
+type Control struct {
+	// LoadPlugin is the path of the plugin which the pre-warmed runtime loads
+	LoadPlugin string `json:"loadPlugin,omitempty"`
+}
+
+// runtimeProcess is a started runtime process with its pipes
+type runtimeProcess struct {
+	uuid     string
+	cmd      *exec.Cmd
+	producer *Producer
+	consumer *Consumer
+	// exited is closed when the process exits, exitErr is set before it's closed
+	exited  chan struct{}
+	exitErr error
+}
+
+// startRuntime starts a runtime process without any plugin
+func startRuntime() (*runtimeProcess, error) {
+	producerRead, producerWrite, err := newPipe()
+	if err != nil {
+		return nil, err
+	}
+	consumerRead, consumerWrite, err := newPipe()
+	if err != nil {
+		_ = producerRead.Close()
+		_ = producerWrite.Close()
+		return nil, err
+	}
+	rt := &runtimeProcess{
+		uuid:   xid.New().String(),
+		cmd:    runtimeCommand(),
+		exited: make(chan struct{}),
+	}
+	rt.producer = NewProducer(producerWrite, &FunctionRequest{})
+	rt.producer.Start()
+	rt.consumer = NewConsumer(consumerRead, &FunctionResponse{})
+	rt.consumer.Start()
+
+	_ = os.MkdirAll(fmt.Sprintf("%slogs/", env.TassFileRoot), 0777)
+	logFileName := fmt.Sprintf("%slogs/%s.log", env.TassFileRoot, rt.uuid)
+	if logFile, err := os.Create(logFileName); err != nil {
+		zap.S().Errorw("init log file error", "err", err)
+	} else {
+		rt.cmd.Stdout = logFile
+	}
+	rt.cmd.ExtraFiles = []*os.File{producerRead, consumerWrite}
+	err = rt.cmd.Start()
+	// the process holds its own copies, closing ours makes the consumer read EOF when the process exits
+	_ = producerRead.Close()
+	_ = consumerWrite.Close()
+	if err != nil {
+		rt.producer.Terminate()
+		return nil, err
+	}
+	go func() {
+		rt.exitErr = rt.cmd.Wait()
+		close(rt.exited)
+	}()
+	return rt, nil
+}
+
+// Pool keeps the pre-warmed runtime processes
+type Pool struct {
+	mu   sync.Locker
+	size int
+	// ready is the runtimes which have done the handshake, the front is the earliest one
+	ready []*runtimeProcess
+	// starting is the number of the runtimes waiting for the handshake
+	starting int
+}
+
+// GetPool returns the Pool singleton and fills it, it returns nil if the pool is disabled
+func GetPool() *Pool {
+	poolOnce.Do(func() {
+		size := viper.GetInt(env.RuntimePoolSize)
+		if size <= 0 {
+			return
+		}
+		pool = newPool(size)
+		pool.fill()
+	})
+	return pool
+}
+
+// newPool returns an empty Pool with the size (param1)
+func newPool(size int) *Pool {
+	return &Pool{
+		mu:    &sync.Mutex{},
+		size:  size,
+		ready: []*runtimeProcess{},
+	}
+}
+
+// Ready returns the number of the pre-warmed runtimes in the pool
+func (p *Pool) Ready() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.ready)
+}
+
+// take takes a pre-warmed runtime and refills the pool, it returns nil if the pool is disabled or empty
+func (p *Pool) take() *runtimeProcess {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	var rt *runtimeProcess
+	if len(p.ready) > 0 {
+		rt = p.ready[0]
+		p.ready = p.ready[1:]
+	}
+	p.mu.Unlock()
+	p.fill()
+	return rt
+}
+
+// fill starts the runtimes until the pool is full
+func (p *Pool) fill() {
+	p.mu.Lock()
+	n := p.size - len(p.ready) - p.starting
+	if n > 0 {
+		p.starting += n
+	}
+	p.mu.Unlock()
+	for i := 0; i < n; i++ {
+		go p.warm()
+	}
+}
+
+// warm starts a runtime and puts it into the pool once its handshake is done.
+// The runtime failing to start is not replaced until the next take, so a broken runtime doesn't loop
+func (p *Pool) warm() {
+	rt, err := startRuntime()
+	if err != nil {
+		p.mu.Lock()
+		p.starting--
+		p.mu.Unlock()
+		zap.S().Warnw("start pre-warmed runtime error", "err", err)
+		return
+	}
+	select {
+	case <-rt.consumer.GetInitDoneChannel():
+	case <-rt.exited:
+		p.mu.Lock()
+		p.starting--
+		p.mu.Unlock()
+		zap.S().Warnw("pre-warmed runtime exits before the handshake", "process", rt.uuid, "err", rt.exitErr)
+		rt.producer.Terminate()
+		return
+	}
+	p.mu.Lock()
+	p.starting--
+	p.ready = append(p.ready, rt)
+	p.mu.Unlock()
+	zap.S().Debugw("runtime pre-warmed", "process", rt.uuid)
+
+	<-rt.exited
+	if p.remove(rt) {
+		zap.S().Warnw("pre-warmed runtime exits in the pool", "process", rt.uuid, "err", rt.exitErr)
+		rt.producer.Terminate()
+	}
+}
+
+// remove removes the runtime from the pool, it returns false if the runtime has been taken
+func (p *Pool) remove(rt *runtimeProcess) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, item := range p.ready {
+		if item == rt {
+			p.ready = append(p.ready[:i], p.ready[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestHelperRuntime is not a real test, it's the mock runtime started by TestPool.
+// It echoes the loaded plugin path to the requests, never answers the "hold" requests
+// and exits with code 3 on the "crash" requests
+func TestHelperRuntime(t *testing.T) {
+	if os.Getenv("TASS_HELPER_RUNTIME") != "1" {
+		return
+	}
+	consumer := NewConsumer(os.NewFile(uintptr(3), "pipe"), &FunctionRequest{})
+	producer := NewProducer(os.NewFile(uintptr(4), "pipe"), &FunctionResponse{})
+	consumer.Start()
+	producer.Start()
+	plugin := ""
+	for raw := range consumer.GetChannel() {
+		req := raw.(*FunctionRequest)
+		result := map[string]interface{}{}
+		if req.Control != nil {
+			if req.Control.LoadPlugin == "/broken.so" {
+				result["err"] = "broken plugin"
+			}
+			plugin = req.Control.LoadPlugin
+		} else if req.Parameters["hold"] == true {
+			continue
+		} else if req.Parameters["crash"] == true {
+			os.Exit(3)
+		} else {
+			result["plugin"] = plugin
+		}
+		producer.GetChannel() <- FunctionResponse{ID: req.ID, Result: result}
+	}
+	os.Exit(0)
+}
+
+// helperRuntimeCommand returns the command of TestHelperRuntime
+func helperRuntimeCommand() *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperRuntime")
+	cmd.Env = append(os.Environ(), "TASS_HELPER_RUNTIME=1")
+	return cmd
+}
+
+// newTestInstance returns a processInstance to attach a runtime in the tests
+func newTestInstance() *processInstance {
+	return &processInstance{
+		functionName:    "a",
+		lock:            &sync.Mutex{},
+		responseMapping: map[string]chan map[string]interface{}{},
+		cleanOnce:       &sync.Once{},
+		stopping:        make(chan struct{}),
+		listened:        make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+}
+
+func TestPool(t *testing.T) {
+	Convey("test the pre-warmed runtime pool", t, func() {
+		origin := runtimeCommand
+		runtimeCommand = helperRuntimeCommand
+		defer func() {
+			runtimeCommand = origin
+		}()
+		p := newPool(2)
+		defer func() {
+			p.mu.Lock()
+			for _, rt := range p.ready {
+				_ = rt.cmd.Process.Kill()
+			}
+			p.mu.Unlock()
+		}()
+		ready := func(n int) bool {
+			for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if p.Ready() == n {
+					return true
+				}
+			}
+			return false
+		}
+		p.fill()
+		So(ready(2), ShouldBeTrue)
+
+		Convey("the runtime serves the function after the plugin is loaded", func() {
+			rt := p.take()
+			So(rt, ShouldNotBeNil)
+			// the pool is refilled
+			So(ready(2), ShouldBeTrue)
+			i := newTestInstance()
+			i.attach(rt, "/plugin.so")
+			i.InitDone()
+			So(i.IsRunning(), ShouldBeTrue)
+			result, err := i.Invoke(context.Background(), map[string]interface{}{})
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, map[string]interface{}{"plugin": "/plugin.so"})
+			i.Release()
+			<-i.Done()
+		})
+
+		Convey("the runtime failing to load the plugin exits", func() {
+			i := newTestInstance()
+			i.attach(p.take(), "/broken.so")
+			i.InitDone()
+			<-i.Done()
+			So(i.IsRunning(), ShouldBeFalse)
+		})
+
+		Convey("the runtime exiting in the pool is removed", func() {
+			p.mu.Lock()
+			_ = p.ready[0].cmd.Process.Kill()
+			p.mu.Unlock()
+			So(ready(1), ShouldBeTrue)
+		})
+	})
+
+	Convey("test the pooled runtime has the base namespaces", t, func() {
+		cmd := runtimeCommand()
+		So(cmd.SysProcAttr, ShouldNotBeNil)
+		So(cmd.SysProcAttr.Cloneflags, ShouldEqual, uintptr(baseCloneflags))
+	})
+}
+
+func TestProcessInstance_Crash(t *testing.T) {
+	Convey("test the pending requests fail when the runtime process crashes", t, func() {
+		origin := runtimeCommand
+		runtimeCommand = helperRuntimeCommand
+		defer func() {
+			runtimeCommand = origin
+		}()
+		rt, err := startRuntime()
+		So(err, ShouldBeNil)
+		i := newTestInstance()
+		i.attach(rt, "/plugin.so")
+		i.InitDone()
+		So(i.IsRunning(), ShouldBeTrue)
+
+		held := make(chan error, 1)
+		go func() {
+			_, err := i.Invoke(context.Background(), map[string]interface{}{"hold": true})
+			held <- err
+		}()
+		// the crash request is sent after the held one is pending
+		for !i.HasRequests() {
+			time.Sleep(time.Millisecond)
+		}
+		_, err = i.Invoke(context.Background(), map[string]interface{}{"crash": true})
+		So(errors.Is(err, ErrInstanceCrashed), ShouldBeTrue)
+		crash := &CrashError{}
+		So(errors.As(err, &crash), ShouldBeTrue)
+		So(crash.FunctionName, ShouldEqual, "a")
+		So(crash.OOMKilled, ShouldBeFalse)
+		exitErr := &exec.ExitError{}
+		So(errors.As(err, &exitErr), ShouldBeTrue)
+		So(exitErr.ExitCode(), ShouldEqual, 3)
+		So(errors.Is(<-held, ErrInstanceCrashed), ShouldBeTrue)
+
+		<-i.Done()
+		So(i.IsRunning(), ShouldBeFalse)
+		_, err = i.Invoke(context.Background(), map[string]interface{}{})
+		So(err, ShouldEqual, ErrInstanceNotService)
+	})
+}
@@ -1,21 +1,28 @@
 package instance
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/rs/xid"
 	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/codecache"
+	"github.com/tass-io/scheduler/pkg/collector"
 	"github.com/tass-io/scheduler/pkg/env"
 	"github.com/tass-io/scheduler/pkg/runner"
 	"github.com/tass-io/scheduler/pkg/store"
+	"github.com/tass-io/scheduler/pkg/utils/base64"
 	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
+	"github.com/tass-io/scheduler/pkg/utils/resourceutils"
 	"go.uber.org/zap"
 )
 
@@ -32,19 +39,8 @@ const (
 
 var (
 	selfExec = "/proc/self/exe"
-	policies = map[string]func(*processInstance) int{
-		"default": defaultPolicyfunc,
-	}
 )
 
-// defaultPolicyfunc is a naive policy to calculate the score,
-// the longer the process instance lives, the higher the score is.
-func defaultPolicyfunc(i *processInstance) int {
-	now := time.Now().Unix()
-	birthday := i.startTime.Unix() // happy birthday for LittleDrizzle. He wrote this line at the birthday (smddx)
-	return int(now - birthday)
-}
-
 // processInstance records the status of the process instance.
 // this struct implements all methods in Instance interface
 type processInstance struct {
@@ -53,7 +49,7 @@ type processInstance struct {
 	lock         sync.Locker
 	functionName string
 	status       Status
-	cpu          string // todo we thought about implementing resource limitation either at instance create
+	cpu          string // cpu and memory are applied to the cgroup of the process if the cgroup root is set
 	memory       string
 	environment  string
 	producer     *Producer
@@ -62,8 +58,23 @@ type processInstance struct {
 	// this field is a temporary place to store the value of the function result.
 	// The key of the map is the request id.
 	responseMapping map[string]chan map[string]interface{}
+	latency         time.Duration // the EWMA of the invoke latency
+	lastInvoked     uint64        // the sequence number of the latest request
 	cmd             *exec.Cmd
+	cgroup          *cgroup
+	usage           CgroupStats     // the latest resource usage sampled from the cgroup
+	sandbox         *Sandbox        // nil if the Function is not sandboxed
+	runtime         *runtimeProcess // the pre-warmed runtime bound to the Function, nil if forked for it
 	cleanOnce       *sync.Once
+	// stopping is closed when the producer is about to be terminated, it wakes up the blocked senders.
+	// sending is held by the senders so that the producer channel is closed after they leave
+	stopping chan struct{}
+	sending  sync.RWMutex
+	// listened is closed when the listener has delivered all responses of the process
+	listened chan struct{}
+	// done is closed when the process exits, exitErr is set before it's closed
+	done    chan struct{}
+	exitErr error
 }
 
 // Score returns the score of the Process.
@@ -72,8 +83,22 @@ func (i *processInstance) Score() int {
 	if i.status != Running {
 		return runner.MaxScore
 	}
-	policyName := viper.GetString(env.InstanceScorePolicy)
-	return policies[policyName](i)
+	return GetScorePolicy().Score(i.Metrics())
+}
+
+// Metrics returns the load and the latency of the process
+func (i *processInstance) Metrics() Metrics {
+	peer := getFunctionLatency(i.functionName)
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return Metrics{
+		StartTime:   i.startTime,
+		Outstanding: i.getWaitNum(),
+		Latency:     i.latency,
+		PeerLatency: peer,
+		LastInvoked: i.lastInvoked,
+		Usage:       i.usage,
+	}
 }
 
 // NewProcessInstance creates a new process status structure.
@@ -92,6 +117,11 @@ func NewProcessInstance(functionName string) Instance {
 		zap.S().Warnw("function infomartion not found", "functionName", functionName)
 		return nil
 	}
+	sandbox, err := getSandbox(function)
+	if err != nil {
+		zap.S().Warnw("new process instances get sandbox error", "functionName", functionName, "err", err)
+		return nil
+	}
 	return &processInstance{
 		startTime:       time.Now(),
 		uuid:            xid.New().String(),
@@ -101,7 +131,11 @@ func NewProcessInstance(functionName string) Instance {
 		memory:          function.Spec.Resource.ResourceMemory,
 		environment:     string(function.Spec.Environment),
 		responseMapping: make(map[string]chan map[string]interface{}, 10),
+		sandbox:         sandbox,
 		cleanOnce:       &sync.Once{},
+		stopping:        make(chan struct{}),
+		listened:        make(chan struct{}),
+		done:            make(chan struct{}),
 	}
 }
 
@@ -117,8 +151,17 @@ func newPipe() (*os.File, *os.File, error) {
 // Start inits and starts producer/consumer, and starts the real work process
 func (i *processInstance) Start() (err error) {
 	i.lock.Lock()
-	defer i.lock.Unlock()
 	i.status = Init
+	i.lock.Unlock()
+	if i.sandbox == nil {
+		if rt := GetPool().take(); rt != nil {
+			// binding fetches the code from the code store, so it's done without the lock
+			i.bind(rt)
+			return
+		}
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
 	producerRead, producerWrite, err := newPipe()
 	if err != nil {
 		return
@@ -135,17 +178,88 @@ func (i *processInstance) Start() (err error) {
 	if err != nil {
 		return
 	}
+	// the process holds its own copies, closing ours makes the consumer read EOF when the process exits
+	_ = producerRead.Close()
+	_ = consumerWrite.Close()
 	i.startListen()
 	return
 }
 
+// bind binds the pre-warmed runtime to the Function, the runtime loads the plugin by a control message
+// and the initialization is done when the plugin is loaded
+func (i *processInstance) bind(rt *runtimeProcess) {
+	// the instance takes the id of the runtime so that the log file matches
+	i.lock.Lock()
+	i.uuid = rt.uuid
+	i.lock.Unlock()
+	directoryPath := fmt.Sprintf("%s%s", env.TassFileRoot, i.uuid)
+	pluginPath := directoryPath + "/plugin.so"
+	i.codePrepare(directoryPath, pluginPath)
+	i.attach(rt, pluginPath)
+}
+
+// attach takes over the process and the pipes of the runtime and sends the control message to load the plugin,
+// the message is sent in the background so that a busy runtime never blocks the caller
+func (i *processInstance) attach(rt *runtimeProcess, pluginPath string) {
+	i.lock.Lock()
+	i.runtime = rt
+	i.cmd = rt.cmd
+	i.producer = rt.producer
+	i.consumer = rt.consumer
+	// the runtime has been started before the Function is known, it's moved into the cgroup before the plugin is loaded
+	i.joinCgroup()
+	id := xid.New().String()
+	respCh := make(chan map[string]interface{}, 1)
+	i.responseMapping[id] = respCh
+	i.lock.Unlock()
+	go i.handleCmdExit()
+	i.startListen()
+
+	zap.S().Infow("bind pre-warmed runtime", "process", i.uuid, "fn", i.functionName)
+	go func() {
+		defer i.removeResponse(id)
+		// the producer is terminated once the instance is released, like Invoke does
+		i.sending.RLock()
+		select {
+		case i.producer.GetChannel() <- FunctionRequest{ID: id, Control: &Control{LoadPlugin: pluginPath}}:
+			i.sending.RUnlock()
+		case <-i.stopping:
+			i.sending.RUnlock()
+			return
+		case <-i.done:
+			i.sending.RUnlock()
+			return
+		}
+		select {
+		case result := <-respCh:
+			if errStr, ok := result["err"]; ok {
+				// the initialization never completes, the instance crashes
+				zap.S().Errorw("pre-warmed runtime loads plugin error", "process", i.uuid, "err", errStr)
+				_ = i.cmd.Process.Kill()
+				return
+			}
+			i.consumer.GetInitDoneChannel() <- struct{}{}
+		case <-i.done:
+		}
+	}()
+}
+
 // startListen ranges the consumer channels and records the response data.
 func (i *processInstance) startListen() {
 	go func() {
 		for respRaw := range i.consumer.GetChannel() {
 			resp := respRaw.(*FunctionResponse)
-			i.responseMapping[resp.ID] <- resp.Result
+			i.lock.Lock()
+			respCh, existed := i.responseMapping[resp.ID]
+			i.lock.Unlock()
+			if !existed {
+				// the caller has left because of the context cancellation
+				zap.S().Infow("drop the response of a cancelled request", "process", i.uuid, "id", resp.ID)
+				continue
+			}
+			respCh <- resp.Result
 		}
+		close(i.listened)
 	}()
 }
 
@@ -159,10 +273,25 @@ func (i *processInstance) StartProcess(request *os.File, response *os.File, func
 	initParam := fmt.Sprintf("init -n %s -I %s -P %s -S %s -E %s", functionName,
 		viper.GetString(env.RedisIP), viper.GetString(env.RedisPort),
 		viper.GetString(env.RedisPassword), i.environment)
-	cmd := exec.Command(selfExec, strings.Split(initParam, " ")...)
-	// It is different from docker, we do not create mount namespace and network namespace
+	args := strings.Split(initParam, " ")
+	// the init process reads the code from the same code store
+	for _, key := range []string{env.CodeStore, env.CodeStorePath, env.CodeStoreURL,
+		env.S3Bucket, env.S3Region, env.S3AccessKey, env.S3SecretKey} {
+		if value := viper.GetString(key); value != "" {
+			args = append(args, "--"+key, value)
+		}
+	}
+	cmd := exec.Command(selfExec, args...)
+	// the init process fetches the code from the store first,
+	// so it unshares the network namespace by itself after that
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC,
+		Cloneflags: i.sandbox.cloneflags() &^ syscall.CLONE_NEWNET,
+	}
+	// the init process uses the directory as its code directory if sandboxed,
+	// because its pid is always 1 in the pid namespace
+	directoryPath := fmt.Sprintf("%s%s", env.TassFileRoot, i.uuid)
+	if err = i.sandbox.setup(cmd, directoryPath); err != nil {
+		return
 	}
 
 	_ = os.MkdirAll(fmt.Sprintf("%slogs/", env.TassFileRoot), 0777)
@@ -189,9 +318,12 @@ func (i *processInstance) startProcessDirect(request *os.File, response *os.File
 	pluginPath := directoryPath + "/plugin.so"
 	i.codePrepare(directoryPath, pluginPath)
 	cmd := exec.Command(binaryPath, pluginPath)
-	// It is different from docker, we do not create mount namespace and network namespace
+	// the mount, pid and network namespaces are created only if the Function is sandboxed
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC,
+		Cloneflags: i.sandbox.cloneflags(),
+	}
+	if err = i.sandbox.setup(cmd, directoryPath); err != nil {
+		return
 	}
 
 	_ = os.MkdirAll(fmt.Sprintf("%slogs/", env.TassFileRoot), 0777)
@@ -208,45 +340,206 @@ func (i *processInstance) startProcessDirect(request *os.File, response *os.File
 	// NOTE: Start starts the specified command but does not wait for it to complete.
 	err = cmd.Start()
 	i.cmd = cmd
+	if err != nil {
+		return
+	}
+	// the process is moved into its cgroup right after it starts, like the pooled runtime in attach
+	i.joinCgroup()
 	go i.handleCmdExit()
 	return
 }
 
-// codePrepare decodes & unzips the code and places the code to the desired location
-// codePrepare is an expensive operation, because it needs to get code from remote storage center
-func (i *processInstance) codePrepare(directoryPath, pluginPath string) {
-	// 1. get plugin
-	code, err := store.Get(k8sutils.GetSelfNamespace(), i.functionName)
+// newCgroup creates the cgroup v2 child group of the process and applies the Function resources
+// as cpu.max and memory.max, it returns nil if the cgroup root is not set or the cgroup fails.
+// The limits are best effort, the process runs without them if the cgroup fails.
+func (i *processInstance) newCgroup() *cgroup {
+	root := viper.GetString(env.CgroupRoot)
+	if root == "" {
+		return nil
+	}
+	cg, err := newCgroup(root, i.uuid)
 	if err != nil {
-		zap.S().Panicw("get function content error", "err", err)
+		zap.S().Warnw("create cgroup error", "process", i.uuid, "err", err)
+		return nil
+	}
+	if cpu, err := resourceutils.ParseCPU(i.cpu); err != nil {
+		zap.S().Warnw("parse function cpu error", "process", i.uuid, "cpu", i.cpu, "err", err)
+	} else if err := cg.setCPU(cpu); err != nil {
+		zap.S().Warnw("set cgroup cpu.max error", "process", i.uuid, "err", err)
+	}
+	if memory, err := resourceutils.ParseMemory(i.memory); err != nil {
+		zap.S().Warnw("parse function memory error", "process", i.uuid, "memory", i.memory, "err", err)
+	} else if err := cg.setMemory(memory); err != nil {
+		zap.S().Warnw("set cgroup memory.max error", "process", i.uuid, "err", err)
+	}
+	return cg
+}
+
+// joinCgroup moves the started process into its cgroup and samples its usage,
+// the process runs without the limits if it fails
+func (i *processInstance) joinCgroup() {
+	cg := i.newCgroup()
+	if cg == nil {
+		return
 	}
-	// 2. create process related files
-	err = os.MkdirAll(directoryPath, 0777)
+	if err := cg.addProcess(i.cmd.Process.Pid); err != nil {
+		zap.S().Warnw("add process to cgroup error", "process", i.uuid, "err", err)
+		_ = cg.remove()
+		return
+	}
+	i.useCgroup(cg)
+}
+
+// useCgroup records the cgroup the process runs in and samples its usage for the metrics until the process exits
+func (i *processInstance) useCgroup(cg *cgroup) {
+	i.cgroup = cg
+	go func() {
+		ticker := time.NewTicker(usageInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				i.sampleUsage()
+			case <-i.done:
+				return
+			}
+		}
+	}()
+}
+
+// sampleUsage reads the resource usage of the process from its cgroup for the metrics
+func (i *processInstance) sampleUsage() {
+	usage, err := i.Usage()
+	if err != nil {
+		zap.S().Debugw("read cgroup usage error", "process", i.uuid, "err", err)
+		return
+	}
+	i.lock.Lock()
+	i.usage = usage
+	i.lock.Unlock()
+}
+
+// Usage returns the resource usage of the process read from its cgroup
+func (i *processInstance) Usage() (CgroupStats, error) {
+	if i.cgroup == nil {
+		return CgroupStats{}, ErrNoCgroup
+	}
+	return i.cgroup.stats()
+}
+
+// codePrepare decodes & unzips the code and places the plugin to the desired location
+// codePrepare is an expensive operation, because it needs to get code from remote storage center,
+// so the code is linked from the local code cache if it's enabled
+func (i *processInstance) codePrepare(directoryPath, pluginPath string) {
+	start := time.Now()
+	// 1. create process related files, the directory is private to the process
+	err := os.MkdirAll(directoryPath, 0700)
 	if err != nil {
 		zap.S().Panicw("code prepare mkdir all error", "err", err)
 	}
+	// 2. get the code, it's a zip archive encoded into base64
+	code, hit := i.getCode(directoryPath + "/code")
+	plugin, err := base64.DecodeUserFile(code, filepath.Base(pluginPath))
+	if err != nil {
+		zap.S().Panicw("decode plugin error", "err", err)
+	}
 	f, err := os.Create(pluginPath)
 	if err != nil {
 		zap.S().Panicw("code prepare create error", "err", err)
 	}
 	// 3. write plugin to file
-	if _, err := f.Write([]byte(code)); err != nil {
+	if _, err := f.Write(plugin); err != nil {
 		zap.S().Panicw("init write error", "err", err)
 	}
 	if err := f.Sync(); err != nil {
 		zap.S().Panicw("init sync error", "err", err)
 	}
 	_ = f.Close()
+	i.recordCodePrepare(hit, time.Since(start))
 }
 
-// handleCmdExit cleans the process when receives a exit code
-func (i *processInstance) handleCmdExit() {
-	err := i.cmd.Wait()
+// getCode gets the code of the Function from the code store,
+// it's linked to the path (param1) from the local code cache if the cache is enabled.
+// It returns whether it's a cache hit
+func (i *processInstance) getCode(path string) (string, bool) {
+	codeStore := store.GetCodeStore()
+	if cache := codecache.GetCache(); cache != nil {
+		hit, err := cache.Link(codeStore, k8sutils.GetSelfNamespace(), i.functionName, path)
+		if err == nil {
+			code, err := ioutil.ReadFile(path)
+			if err == nil {
+				return string(code), hit
+			}
+		}
+		zap.S().Warnw("code cache link error, fetch the code directly", "process", i.uuid, "err", err)
+	}
+	code, err := codeStore.Get(k8sutils.GetSelfNamespace(), i.functionName)
 	if err != nil {
-		zap.S().Errorw("processInstance cmd exit error", "processId", i.uuid, "fn", i.functionName, "err", err)
-		i.cleanUp()
+		zap.S().Panicw("get function content error", "err", err)
+	}
+	return code, false
+}
+
+// recordCodePrepare reports the code preparing phase to the collector
+func (i *processInstance) recordCodePrepare(hit bool, d time.Duration) {
+	c := collector.GetCollector()
+	if c == nil {
+		return
+	}
+	t := collector.RecordCodeCacheMiss
+	if hit {
+		t = collector.RecordCodeCacheHit
+	}
+	c.Record("", "", i.functionName, t, d)
+}
+
+// handleCmdExit cleans the process when receives a exit code.
+// If the process is not released by the scheduler, it's a crash.
+// The pending requests fail with a CrashError once the remaining responses are delivered.
+func (i *processInstance) handleCmdExit() {
+	err := i.wait()
+	oomKilled := i.cleanCgroup()
+	i.lock.Lock()
+	crashed := i.status != Terminating
+	i.status = Terminated
+	i.exitErr = &CrashError{FunctionName: i.functionName, InstanceID: i.uuid, Err: err, OOMKilled: oomKilled}
+	i.lock.Unlock()
+	if crashed {
+		zap.S().Errorw("processInstance crashed", "processId", i.uuid, "fn", i.functionName, "err", err)
+	} else {
+		zap.S().Infow("processInstance exited", "processId", i.uuid, "fn", i.functionName, "err", err)
+	}
+	i.cleanUp()
+	<-i.listened
+	close(i.done)
+}
+
+// wait waits for the process to exit, the pre-warmed runtime has been waited since it was started
+func (i *processInstance) wait() error {
+	if i.runtime != nil {
+		<-i.runtime.exited
+		return i.runtime.exitErr
 	}
+	return i.cmd.Wait()
+}
 
+// cleanCgroup reads the final usage of the process and removes its cgroup,
+// it returns whether the process is killed by the OOM killer
+func (i *processInstance) cleanCgroup() bool {
+	if i.cgroup == nil {
+		return false
+	}
+	stats, err := i.cgroup.stats()
+	if err != nil {
+		zap.S().Warnw("read cgroup stats error", "process", i.uuid, "err", err)
+	} else {
+		zap.S().Infow("process instance resource usage", "process", i.uuid, "fn", i.functionName,
+			"cpuUsec", stats.CPUUsage, "memoryPeak", stats.MemoryPeak, "oomKills", stats.OOMKills)
+	}
+	if err := i.cgroup.remove(); err != nil {
+		zap.S().Warnw("remove cgroup error", "process", i.uuid, "err", err)
+	}
+	return stats.OOMKills > 0
 }
 
 // Sends a SIGTERM signal to process and triggers `clean up` action
@@ -278,32 +571,83 @@ func (i *processInstance) IsRunning() bool {
 // cleanUp is used at processInstance exception or graceful shut down
 func (i *processInstance) cleanUp() {
 	i.cleanOnce.Do(func() {
+		close(i.stopping)
+		i.sending.Lock()
+		defer i.sending.Unlock()
 		i.producer.Terminate()
 	})
 }
 
 // Invoke generates a functionRequest and is blocked until the function return the result
-// Invoke is a process-level invoke
-func (i *processInstance) Invoke(parameters map[string]interface{}) (result map[string]interface{}, err error) {
+// or the context is done. Invoke is a process-level invoke
+func (i *processInstance) Invoke(
+	ctx context.Context, parameters map[string]interface{}) (result map[string]interface{}, err error) {
+
+	i.lock.Lock()
+	// check the status with the lock held, the producer channel is closed once the status changes
 	if i.status != Running {
+		i.lock.Unlock()
 		zap.S().Infow("process instance Invoke", "status", i.status)
 		return nil, ErrInstanceNotService
 	}
-	i.lock.Lock()
 	id := xid.New().String()
 	req := NewFunctionRequest(id, parameters)
-	i.responseMapping[id] = make(chan map[string]interface{})
-	i.producer.GetChannel() <- *req
+	// the channel is buffered so that a late response never blocks the listener
+	respCh := make(chan map[string]interface{}, 1)
+	i.responseMapping[id] = respCh
+	i.lastInvoked = atomic.AddUint64(&invokeSequence, 1)
+	i.sending.RLock()
 	i.lock.Unlock()
+	defer i.removeResponse(id)
+	// send without the lock, the listener needs the lock to deliver the responses when the pipes are full
+	select {
+	case i.producer.GetChannel() <- *req:
+		i.sending.RUnlock()
+	case <-i.stopping:
+		i.sending.RUnlock()
+		return nil, ErrInstanceNotService
+	case <-ctx.Done():
+		i.sending.RUnlock()
+		return nil, ctx.Err()
+	}
+	start := time.Now()
 	// result is FunctionResponse.Result
-	result = <-i.responseMapping[id]
+	select {
+	case result = <-respCh:
+	case <-i.done:
+		// all responses have been delivered before done is closed
+		select {
+		case result = <-respCh:
+		default:
+			zap.S().Warnw("process instance exits with the request pending", "process", i.uuid, "id", id)
+			return nil, i.exitErr
+		}
+	case <-ctx.Done():
+		zap.S().Warnw("process instance invoke context done", "process", i.uuid, "id", id, "err", ctx.Err())
+		return nil, ctx.Err()
+	}
+	i.observeLatency(time.Since(start))
 	if errStr, ok := result["err"]; ok {
-		err = errors.New(errStr.(string))
+		err = &FunctionError{Message: errStr.(string)}
 	}
-	delete(i.responseMapping, id)
 	return
 }
 
+// removeResponse removes the response channel of the request from the responseMapping
+func (i *processInstance) removeResponse(id string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	delete(i.responseMapping, id)
+}
+
+// observeLatency updates the EWMA of the invoke latency of the instance and the function
+func (i *processInstance) observeLatency(d time.Duration) {
+	observeFunctionLatency(i.functionName, d)
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.latency = ewma(i.latency, d)
+}
+
 // getWaitNum returns the number of response data waiting for dealing with in responseMapping
 func (i *processInstance) getWaitNum() int {
 	return len(i.responseMapping)
@@ -314,14 +658,28 @@ func (i *processInstance) HasRequests() bool {
 	return len(i.responseMapping) > 0
 }
 
-// InitDone returns when the process instance initialization done, or it hangs forever.
+// InitDone returns when the process instance initialization done or the process exits.
 func (i *processInstance) InitDone() {
 	zap.S().Infow("process instance init done", "process", i.uuid)
 	initDoneCh := i.consumer.GetInitDoneChannel()
-	<-initDoneCh
+	select {
+	case <-initDoneCh:
+	case <-i.done:
+		zap.S().Warnw("process instance exits before init done", "process", i.uuid)
+		return
+	}
 
 	// lazy, change the status only when this method is called
-	i.status = Running
+	i.lock.Lock()
+	if i.status == Init {
+		i.status = Running
+	}
+	i.lock.Unlock()
+}
+
+// Done returns a channel that's closed when the process exits
+func (i *processInstance) Done() <-chan struct{} {
+	return i.done
 }
 
 var _ Instance = &processInstance{}
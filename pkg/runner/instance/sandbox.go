@@ -0,0 +1,345 @@
+package instance
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SandboxAnnotation is the sandbox settings of the Function processes in JSON,
+	// it overrides the default settings from the flag, e.g.
+	// {"namespaces":["mount","pid","network"],"uid":1000,"gid":1000,"readOnlyRoot":true,"seccomp":true}
+	SandboxAnnotation = "serverless.tass.io/sandbox"
+	// SandboxEnv is the environment variable passing the sandbox settings to the function process
+	SandboxEnv = "TASS_SANDBOX"
+	// baseCloneflags are the namespaces every function process has
+	baseCloneflags = syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+	// cgroupRoot is masked by an empty read-only tmpfs, so the process can't change the cgroup limits
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// Namespace is a linux namespace the function process runs in
+type Namespace string
+
+const (
+	MountNamespace   Namespace = "mount"
+	PIDNamespace     Namespace = "pid"
+	NetworkNamespace Namespace = "network"
+)
+
+var namespaceCloneflags = map[Namespace]uintptr{
+	MountNamespace:   syscall.CLONE_NEWNS,
+	PIDNamespace:     syscall.CLONE_NEWPID,
+	NetworkNamespace: syscall.CLONE_NEWNET,
+}
+
+// lockedMountFlags maps the ST_* flags of statfs to the mount flags kept by the read-only remount
+var lockedMountFlags = map[int64]uintptr{
+	0x2:    unix.MS_NOSUID,
+	0x4:    unix.MS_NODEV,
+	0x8:    unix.MS_NOEXEC,
+	0x400:  unix.MS_NOATIME,
+	0x800:  unix.MS_NODIRATIME,
+	0x1000: unix.MS_RELATIME,
+}
+
+// Sandbox is the isolation settings of a function process.
+// The namespaces are created when the process is cloned,
+// the others are applied by the process itself by calling Enter before running the user code.
+type Sandbox struct {
+	Namespaces []Namespace `json:"namespaces,omitempty"`
+	// UID and GID are the non-root user the process runs as, the process keeps running as root if unset.
+	// GID is the same as UID if unset
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+	// ReadOnlyRoot remounts the filesystems read-only except the private writable directory,
+	// it masks the cgroup filesystem and the directories of the other processes.
+	// It requires the mount namespace and a non-root UID
+	ReadOnlyRoot bool `json:"readOnlyRoot,omitempty"`
+	// Seccomp installs a filter denying the syscalls a function never needs, e.g. ptrace and mount,
+	// it requires a non-root UID because root can undo the other settings by the allowed syscalls
+	Seccomp bool `json:"seccomp,omitempty"`
+	// WritableDir is the private directory of the process, it's set by the scheduler
+	WritableDir string `json:"writableDir,omitempty"`
+}
+
+// getSandbox returns the sandbox settings of the Function,
+// the annotation overrides the default settings from the flag, it returns nil if neither is set
+func getSandbox(function *serverlessv1alpha1.Function) (*Sandbox, error) {
+	raw := viper.GetString(env.Sandbox)
+	if value, ok := function.Annotations[SandboxAnnotation]; ok {
+		raw = value
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	sandbox := &Sandbox{}
+	if err := json.Unmarshal([]byte(raw), sandbox); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSandbox, err)
+	}
+	if err := sandbox.validate(); err != nil {
+		return nil, err
+	}
+	return sandbox, nil
+}
+
+// validate checks the settings, the mount namespace is added if the root is read-only
+// and the GID defaults to the UID
+func (s *Sandbox) validate() error {
+	for _, ns := range s.Namespaces {
+		if _, ok := namespaceCloneflags[ns]; !ok {
+			return fmt.Errorf("%w: unknown namespace %q", ErrInvalidSandbox, ns)
+		}
+	}
+	if (s.UID != nil && *s.UID <= 0) || (s.GID != nil && *s.GID <= 0) {
+		return fmt.Errorf("%w: uid and gid must be non-root", ErrInvalidSandbox)
+	}
+	if (s.ReadOnlyRoot || s.Seccomp) && s.UID == nil {
+		return fmt.Errorf("%w: the read-only root and seccomp require a non-root uid", ErrInvalidSandbox)
+	}
+	if s.UID != nil && s.GID == nil {
+		gid := *s.UID
+		s.GID = &gid
+	}
+	if s.ReadOnlyRoot && !s.Has(MountNamespace) {
+		s.Namespaces = append(s.Namespaces, MountNamespace)
+	}
+	return nil
+}
+
+// Has returns whether the process runs in the namespace
+func (s *Sandbox) Has(ns Namespace) bool {
+	if s == nil {
+		return false
+	}
+	for _, n := range s.Namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneflags returns the flags creating the namespaces of the process
+func (s *Sandbox) cloneflags() uintptr {
+	flags := uintptr(baseCloneflags)
+	if s == nil {
+		return flags
+	}
+	for _, ns := range s.Namespaces {
+		flags |= namespaceCloneflags[ns]
+	}
+	return flags
+}
+
+// setup passes the settings with the writable directory (param2) to the command,
+// it does nothing if the Function is not sandboxed
+func (s *Sandbox) setup(cmd *exec.Cmd, writableDir string) error {
+	if s == nil {
+		return nil
+	}
+	settings := *s
+	settings.WritableDir = writableDir
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), SandboxEnv+"="+string(b))
+	return nil
+}
+
+// SandboxFromEnv returns the sandbox settings passed by the scheduler, it returns nil if the process is not sandboxed
+func SandboxFromEnv() (*Sandbox, error) {
+	raw := os.Getenv(SandboxEnv)
+	if raw == "" {
+		return nil, nil
+	}
+	sandbox := &Sandbox{}
+	if err := json.Unmarshal([]byte(raw), sandbox); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSandbox, err)
+	}
+	return sandbox, nil
+}
+
+// Enter applies the settings to the current process in order:
+// it mounts a new proc filesystem for the pid namespace, makes the root read-only,
+// drops to the non-root user and installs the seccomp filter at last.
+// It must be called by the function process before loading the user code.
+func (s *Sandbox) Enter() error {
+	if s == nil {
+		return nil
+	}
+	if s.Has(MountNamespace) {
+		// keep the mount changes private to the namespace
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("make mounts private error: %w", err)
+		}
+		if s.Has(PIDNamespace) {
+			if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+				return fmt.Errorf("mount proc error: %w", err)
+			}
+		}
+	}
+	if s.ReadOnlyRoot {
+		if err := s.readOnlyRoot(); err != nil {
+			return err
+		}
+	}
+	if s.WritableDir != "" {
+		_ = os.Setenv("TMPDIR", s.WritableDir)
+	}
+	if err := s.dropCredential(); err != nil {
+		return err
+	}
+	if s.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return fmt.Errorf("install seccomp filter error: %w", err)
+		}
+	}
+	return nil
+}
+
+// readOnlyRoot masks the cgroup filesystem and the directories of the other processes,
+// then it remounts all filesystems read-only except the writable directory and the pseudo filesystems
+func (s *Sandbox) readOnlyRoot() error {
+	if s.WritableDir == "" {
+		return fmt.Errorf("%w: no writable directory for the read-only root", ErrInvalidSandbox)
+	}
+	if err := s.maskProcessDirs(); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", cgroupRoot, "tmpfs", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC,
+		"size=0"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("mask %s error: %w", cgroupRoot, err)
+	}
+	mountPoints, err := readMountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mp := range mountPoints {
+		if mp == s.WritableDir || isPseudoMount(mp) {
+			continue
+		}
+		var st unix.Statfs_t
+		if err := unix.Statfs(mp, &st); err != nil {
+			continue
+		}
+		// the locked flags must be kept when remounting a bind mount
+		flags := uintptr(unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY)
+		for statfsFlag, mountFlag := range lockedMountFlags {
+			if st.Flags&statfsFlag != 0 {
+				flags |= mountFlag
+			}
+		}
+		if err := unix.Mount("", mp, "", flags, ""); err != nil {
+			return fmt.Errorf("remount %s read-only error: %w", mp, err)
+		}
+	}
+	return nil
+}
+
+// maskProcessDirs hides the directories of the other processes by a tmpfs over the parent of the writable directory,
+// the writable directory is bound back into it, so it's a mount point skipped by the remount
+func (s *Sandbox) maskProcessDirs() error {
+	parent, name := filepath.Split(filepath.Clean(s.WritableDir))
+	if parent == "/" {
+		return fmt.Errorf("%w: the writable directory must not be under the root", ErrInvalidSandbox)
+	}
+	// the writable directory is reached by its fd after it's hidden
+	dir, err := os.OpenFile(s.WritableDir, unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return fmt.Errorf("open writable directory error: %w", err)
+	}
+	defer dir.Close()
+	if err := unix.Mount("tmpfs", parent, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=0755"); err != nil {
+		return fmt.Errorf("mask %s error: %w", parent, err)
+	}
+	if err := os.Mkdir(filepath.Join(parent, name), 0700); err != nil {
+		return fmt.Errorf("create writable directory error: %w", err)
+	}
+	source := fmt.Sprintf("/proc/self/fd/%d", dir.Fd())
+	if err := unix.Mount(source, s.WritableDir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind writable directory error: %w", err)
+	}
+	return nil
+}
+
+// dropCredential switches to the non-root user, the writable directory is given to the user first
+func (s *Sandbox) dropCredential() error {
+	if s.UID == nil && s.GID == nil {
+		return nil
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if s.UID != nil {
+		uid = *s.UID
+	}
+	if s.GID != nil {
+		gid = *s.GID
+	}
+	if s.WritableDir != "" {
+		if err := os.Chown(s.WritableDir, uid, gid); err != nil {
+			return fmt.Errorf("chown writable directory error: %w", err)
+		}
+		if err := os.Chmod(s.WritableDir, 0700); err != nil {
+			return fmt.Errorf("chmod writable directory error: %w", err)
+		}
+	}
+	// the group must be changed before the user, otherwise there is no permission
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return fmt.Errorf("setgroups error: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid error: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid error: %w", err)
+	}
+	return nil
+}
+
+// readMountPoints returns the mount points of the current mount namespace
+func readMountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mountPoints := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoints = append(mountPoints, unescapeMountPoint(fields[4]))
+	}
+	return mountPoints, scanner.Err()
+}
+
+// unescapeMountPoint decodes the octal escapes of the space, tab, newline and backslash in mountinfo
+func unescapeMountPoint(mp string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(mp)
+}
+
+// isPseudoMount returns whether the mount point is /proc, /dev or under them,
+// they are left writable for the process to work, e.g. writing /dev/null
+func isPseudoMount(mp string) bool {
+	for _, prefix := range []string{"/proc", "/dev"} {
+		if mp == prefix || strings.HasPrefix(mp, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestHelperSeccomp is not a real test, it's the process started by TestSandbox to check the seccomp filter.
+// It prints the errors of the checked syscalls after the filter is installed
+func TestHelperSeccomp(t *testing.T) {
+	if os.Getenv("TASS_HELPER_SECCOMP") != "1" {
+		return
+	}
+	if err := installSeccomp(); err != nil {
+		fmt.Println("install", err)
+		os.Exit(1)
+	}
+	userns := exec.Command("true")
+	userns.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+	_, _, clone3 := unix.RawSyscall(unix.SYS_CLONE3, 0, 0, 0)
+	_, _, setattr := unix.RawSyscall(sysMountSetattr, 0, 0, 0)
+	fmt.Println("true", exec.Command("true").Run())
+	fmt.Println("userns", errors.Is(userns.Run(), unix.EPERM))
+	fmt.Println("clone3", clone3 == unix.ENOSYS)
+	fmt.Println("mount_setattr", setattr == unix.EPERM)
+	os.Exit(0)
+}
+
+// TestHelperSandbox is not a real test, it's the process started by TestSandbox to check the read-only root.
+// It prints what the process sees after entering the sandbox
+func TestHelperSandbox(t *testing.T) {
+	if os.Getenv("TASS_HELPER_SANDBOX") != "1" {
+		return
+	}
+	sandbox, err := SandboxFromEnv()
+	if err == nil {
+		err = sandbox.Enter()
+	}
+	if err != nil {
+		fmt.Println("enter", err)
+		os.Exit(1)
+	}
+	siblings, _ := ioutil.ReadDir(filepath.Dir(sandbox.WritableDir))
+	cgroups, _ := ioutil.ReadDir(cgroupRoot)
+	fmt.Println("uid", os.Getuid())
+	fmt.Println("siblings", len(siblings), siblings[0].Name())
+	fmt.Println("cgroups", len(cgroups))
+	fmt.Println("writable", ioutil.WriteFile(filepath.Join(sandbox.WritableDir, "a"), []byte("a"), 0600))
+	// /tmp is writable by all users, TMPDIR is the writable directory in the sandbox
+	fmt.Println("readonly", ioutil.WriteFile("/tmp/tass-sandbox", []byte("a"), 0600) != nil)
+	os.Exit(0)
+}
+
+func TestSandbox(t *testing.T) {
+	Convey("test the sandbox settings of a function", t, func() {
+		defer viper.Set(env.Sandbox, "")
+		function := func(annotation string) *serverlessv1alpha1.Function {
+			f := &serverlessv1alpha1.Function{}
+			if annotation != "" {
+				f.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{SandboxAnnotation: annotation}}
+			}
+			return f
+		}
+
+		Convey("not sandboxed by default", func() {
+			sandbox, err := getSandbox(function(""))
+			So(err, ShouldBeNil)
+			So(sandbox, ShouldBeNil)
+			So(sandbox.cloneflags(), ShouldEqual, syscall.CLONE_NEWUTS|syscall.CLONE_NEWIPC)
+			cmd := exec.Command("true")
+			So(sandbox.setup(cmd, "/tass/a"), ShouldBeNil)
+			So(cmd.Env, ShouldBeNil)
+			So(sandbox.Enter(), ShouldBeNil)
+		})
+
+		Convey("the annotation overrides the flag", func() {
+			viper.Set(env.Sandbox, `{"namespaces":["pid"]}`)
+			sandbox, err := getSandbox(function(""))
+			So(err, ShouldBeNil)
+			So(sandbox.cloneflags(), ShouldEqual, syscall.CLONE_NEWUTS|syscall.CLONE_NEWIPC|syscall.CLONE_NEWPID)
+
+			sandbox, err = getSandbox(function(`{"namespaces":["network"],"uid":1000,"gid":1000,"readOnlyRoot":true,"seccomp":true}`))
+			So(err, ShouldBeNil)
+			So(sandbox.Has(NetworkNamespace), ShouldBeTrue)
+			So(sandbox.Has(PIDNamespace), ShouldBeFalse)
+			// the read-only root requires the mount namespace
+			So(sandbox.Has(MountNamespace), ShouldBeTrue)
+			So(*sandbox.UID, ShouldEqual, 1000)
+			So(sandbox.Seccomp, ShouldBeTrue)
+
+			// the gid defaults to the uid
+			sandbox, err = getSandbox(function(`{"uid":1000,"seccomp":true}`))
+			So(err, ShouldBeNil)
+			So(*sandbox.GID, ShouldEqual, 1000)
+		})
+
+		Convey("the settings are passed to the process with the writable directory", func() {
+			sandbox, err := getSandbox(function(`{"uid":1000,"gid":1000,"readOnlyRoot":true}`))
+			So(err, ShouldBeNil)
+			cmd := exec.Command("true")
+			So(sandbox.setup(cmd, "/tass/a"), ShouldBeNil)
+			So(sandbox.WritableDir, ShouldBeEmpty)
+			var raw string
+			for _, e := range cmd.Env {
+				if strings.HasPrefix(e, SandboxEnv+"=") {
+					raw = strings.TrimPrefix(e, SandboxEnv+"=")
+				}
+			}
+			So(raw, ShouldNotBeEmpty)
+			defer os.Unsetenv(SandboxEnv)
+			So(os.Setenv(SandboxEnv, raw), ShouldBeNil)
+			passed, err := SandboxFromEnv()
+			So(err, ShouldBeNil)
+			So(passed.WritableDir, ShouldEqual, "/tass/a")
+			So(*passed.GID, ShouldEqual, 1000)
+			So(passed.ReadOnlyRoot, ShouldBeTrue)
+		})
+
+		Convey("invalid settings are rejected", func() {
+			for _, annotation := range []string{
+				`{"namespaces":["user"]}`,
+				`{"uid":0}`,
+				`{"gid":-1}`,
+				// root can undo the read-only root and escape the seccomp filter
+				`{"readOnlyRoot":true}`,
+				`{"seccomp":true,"gid":1000}`,
+				`not json`,
+			} {
+				_, err := getSandbox(function(annotation))
+				So(errors.Is(err, ErrInvalidSandbox), ShouldBeTrue)
+			}
+		})
+	})
+
+	Convey("test the seccomp filter jumps to the right returns", t, func() {
+		filter := seccompFilter(auditArches["amd64"])
+		n := len(deniedSyscalls)
+		So(len(filter), ShouldEqual, 5+n+7)
+		allow, deny, enosys := len(filter)-3, len(filter)-2, len(filter)-1
+		So(filter[allow].K, ShouldEqual, seccompRetAllow)
+		So(filter[deny].K, ShouldEqual, seccompRetErrno|uint32(unix.EPERM))
+		So(filter[enosys].K, ShouldEqual, seccompRetErrno|uint32(unix.ENOSYS))
+		// a jump of k from the instruction i goes to i+1+k
+		So(4+1+int(filter[4].Jt), ShouldEqual, deny)
+		for i := 5; i < 5+n; i++ {
+			So(i+1+int(filter[i].Jt), ShouldEqual, deny)
+			So(i+1+int(filter[i].Jf), ShouldEqual, i+1)
+		}
+		clone3, clone, flags := 5+n, 6+n, 8+n
+		So(clone3+1+int(filter[clone3].Jt), ShouldEqual, enosys)
+		So(clone+1+int(filter[clone].Jf), ShouldEqual, allow)
+		So(flags+1+int(filter[flags].Jt), ShouldEqual, deny)
+		So(flags+1+int(filter[flags].Jf), ShouldEqual, allow)
+	})
+
+	Convey("test the seccomp filter in a real process", t, func() {
+		if _, ok := auditArches[runtime.GOARCH]; !ok {
+			return
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperSeccomp")
+		cmd.Env = append(os.Environ(), "TASS_HELPER_SECCOMP=1")
+		out, err := cmd.Output()
+		So(err, ShouldBeNil)
+		So(string(out), ShouldStartWith, "true <nil>\nuserns true\nclone3 true\nmount_setattr true\n")
+	})
+
+	Convey("test the read-only root in a real process", t, func() {
+		if os.Getuid() != 0 {
+			return
+		}
+		root, err := ioutil.TempDir("", "sandbox")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		for _, dir := range []string{"own", "other"} {
+			So(os.Mkdir(filepath.Join(root, dir), 0700), ShouldBeNil)
+		}
+		uid := 1000
+		sandbox := &Sandbox{UID: &uid, ReadOnlyRoot: true}
+		So(sandbox.validate(), ShouldBeNil)
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperSandbox")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: sandbox.cloneflags()}
+		So(sandbox.setup(cmd, filepath.Join(root, "own")), ShouldBeNil)
+		cmd.Env = append(cmd.Env, "TASS_HELPER_SANDBOX=1")
+		out, err := cmd.Output()
+		So(string(out), ShouldEqual, "uid 1000\nsiblings 1 own\ncgroups 0\nwritable <nil>\nreadonly true\n")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("test the mount points from mountinfo", t, func() {
+		So(unescapeMountPoint(`/mnt/a\040b`), ShouldEqual, "/mnt/a b")
+		So(isPseudoMount("/proc"), ShouldBeTrue)
+		So(isPseudoMount("/dev/shm"), ShouldBeTrue)
+		So(isPseudoMount("/sys/fs/cgroup"), ShouldBeFalse)
+		So(isPseudoMount("/devices"), ShouldBeFalse)
+		So(isPseudoMount("/tass"), ShouldBeFalse)
+	})
+}
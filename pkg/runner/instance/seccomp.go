@@ -0,0 +1,122 @@
+package instance
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// the seccomp constants which are not exported by golang.org/x/sys/unix
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetAllow        = 0x7fff0000
+	seccompRetErrno        = 0x00050000
+	seccompRetKillProcess  = 0x80000000
+	// sysMountSetattr is the same on all architectures, it's not in golang.org/x/sys/unix yet
+	sysMountSetattr = 442
+	// x32SyscallBit marks the x32 syscalls on amd64, they are denied to avoid bypassing the filter
+	x32SyscallBit = 0x40000000
+	// seccompDataNr, seccompDataArch and seccompDataArg0 are the offsets in struct seccomp_data,
+	// seccompDataArg0 is the low 32 bits of the first argument on the little-endian architectures
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+)
+
+var (
+	errSeccompUnsupported = errors.New("seccomp is not supported on " + runtime.GOARCH)
+
+	// auditArches are the AUDIT_ARCH values of the supported architectures
+	auditArches = map[string]uint32{
+		"amd64": 0xc000003e,
+		"arm64": 0xc00000b7,
+	}
+
+	// deniedSyscalls fail with EPERM in the function process
+	deniedSyscalls = []uint32{
+		unix.SYS_PTRACE,
+		unix.SYS_PROCESS_VM_READV,
+		unix.SYS_PROCESS_VM_WRITEV,
+		unix.SYS_MOUNT,
+		unix.SYS_UMOUNT2,
+		sysMountSetattr,
+		unix.SYS_FSOPEN,
+		unix.SYS_FSCONFIG,
+		unix.SYS_FSMOUNT,
+		unix.SYS_FSPICK,
+		unix.SYS_MOVE_MOUNT,
+		unix.SYS_OPEN_TREE,
+		unix.SYS_PIVOT_ROOT,
+		unix.SYS_CHROOT,
+		unix.SYS_SETNS,
+		unix.SYS_UNSHARE,
+		unix.SYS_REBOOT,
+		unix.SYS_KEXEC_LOAD,
+		unix.SYS_KEXEC_FILE_LOAD,
+		unix.SYS_INIT_MODULE,
+		unix.SYS_FINIT_MODULE,
+		unix.SYS_DELETE_MODULE,
+		unix.SYS_SWAPON,
+		unix.SYS_SWAPOFF,
+		unix.SYS_BPF,
+		unix.SYS_PERF_EVENT_OPEN,
+		unix.SYS_USERFAULTFD,
+		unix.SYS_KEYCTL,
+		unix.SYS_ADD_KEY,
+		unix.SYS_REQUEST_KEY,
+		unix.SYS_OPEN_BY_HANDLE_AT,
+		unix.SYS_ACCT,
+		unix.SYS_SETTIMEOFDAY,
+		unix.SYS_CLOCK_SETTIME,
+	}
+)
+
+// seccompFilter returns the BPF program of the denylist,
+// the process is killed if the syscall is from another architecture.
+// clone creating a user namespace is denied, clone3 fails with ENOSYS because its flags can't be checked,
+// so the libc falls back to clone
+func seccompFilter(arch uint32) []unix.SockFilter {
+	n := len(deniedSyscalls)
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompDataArch},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: arch},
+		{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetKillProcess},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompDataNr},
+		// jump over the denylist, the clone checks and the allow to the errno
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: uint8(n + 5), K: x32SyscallBit},
+	}
+	for i, nr := range deniedSyscalls {
+		filter = append(filter, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: uint8(n + 4 - i), K: nr})
+	}
+	return append(filter,
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 5, K: unix.SYS_CLONE3},
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jf: 2, K: unix.SYS_CLONE},
+		unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompDataArg0},
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 1, K: unix.CLONE_NEWUSER},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetAllow},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetErrno | uint32(unix.EPERM)},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetErrno | uint32(unix.ENOSYS)},
+	)
+}
+
+// installSeccomp installs the filter to all threads of the process,
+// no_new_privs is set first so that a non-root process can install it
+func installSeccomp() error {
+	arch, ok := auditArches[runtime.GOARCH]
+	if !ok {
+		return errSeccompUnsupported
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	filter := seccompFilter(arch)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -9,11 +9,13 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/tass-io/scheduler/pkg/dto"
 	"github.com/tass-io/scheduler/pkg/env"
 	"github.com/tass-io/scheduler/pkg/runner"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
 	"github.com/tass-io/scheduler/pkg/span"
 	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
 	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
@@ -126,7 +128,8 @@ func (l *LSDS) chooseTarget(functionName string) (ip string) {
 func (l *LSDS) start() {
 }
 
-// WorkflowRequest sends a request to other LocalScheduler
+// WorkflowRequest sends a request to other LocalScheduler,
+// the request is cancelled with the span context and the remaining time is forwarded by the timeout header
 func WorkflowRequest(sp *span.Span, parameters map[string]interface{}, target string) (dto.WorkflowResponse, error) {
 	client := &http.Client{}
 	invokeRequest := dto.WorkflowRequest{
@@ -139,7 +142,8 @@ func WorkflowRequest(sp *span.Span, parameters map[string]interface{}, target st
 		zap.S().Errorw("workflow request body error", "err", err)
 		return dto.WorkflowResponse{}, err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/v1/workflow", target), strings.NewReader(string(reqByte)))
+	req, err := http.NewRequestWithContext(sp.GetContext(), "POST",
+		fmt.Sprintf("http://%s/v1/workflow", target), strings.NewReader(string(reqByte)))
 	if err != nil {
 		zap.S().Errorw("workflow request request error", "err", err)
 		return dto.WorkflowResponse{}, err
@@ -147,6 +151,9 @@ func WorkflowRequest(sp *span.Span, parameters map[string]interface{}, target st
 	// so the span has same level span in two local scheduler
 	sp.InjectRoot(req.Header)
 	req.Header.Add("Content-Type", "application/json")
+	if deadline, ok := sp.GetContext().Deadline(); ok {
+		req.Header.Add(dto.TimeoutHeader, time.Until(deadline).String())
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		zap.S().Errorw("workflow request response error", "err", err)
@@ -174,9 +181,21 @@ func (l *LSDS) Run(sp *span.Span, parameters map[string]interface{}) (result map
 		zap.S().Errorw("lsds run request error", "error", err)
 		return nil, err
 	}
+	if !resp.Success {
+		zap.S().Errorw("lsds run remote error", "target", target, "message", resp.Message)
+		return nil, remoteError(resp)
+	}
 	return resp.Result, nil
 }
 
+// remoteError converts the failed response to an error of the same class as the remote one
+func remoteError(resp dto.WorkflowResponse) error {
+	if instance.ErrorClass(resp.ErrorClass) == instance.FunctionErrorClass {
+		return &instance.FunctionError{Message: resp.Message}
+	}
+	return errors.New(resp.Message)
+}
+
 // Stats returns lsds own stats in the serverlessv1alpha1.WorkflowRuntime
 func (l *LSDS) Stats() runner.InstanceStatus {
 	wfrt, existed, err := l.getWorkflowRuntimeByName(l.workflowName)
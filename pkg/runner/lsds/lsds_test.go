@@ -7,7 +7,9 @@ import (
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/dto"
 	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
 	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
 	_ "github.com/tass-io/scheduler/pkg/utils/log"
 	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
@@ -293,3 +295,13 @@ func TestLSDS_Sync(t *testing.T) {
 		})
 	}
 }
+
+func TestLSDS_RemoteError(t *testing.T) {
+	Convey("test the remote error keeps its class", t, func() {
+		err := remoteError(dto.WorkflowResponse{Message: "boom", ErrorClass: string(instance.FunctionErrorClass)})
+		So(instance.ClassifyError(err), ShouldEqual, instance.FunctionErrorClass)
+		So(err.Error(), ShouldEqual, "boom")
+		err = remoteError(dto.WorkflowResponse{Message: "no instance"})
+		So(instance.ClassifyError(err), ShouldEqual, instance.SystemErrorClass)
+	})
+}
@@ -1,6 +1,9 @@
 package runner
 
 import (
+	"math"
+	"time"
+
 	"github.com/tass-io/scheduler/pkg/span"
 )
 
@@ -8,10 +11,44 @@ import (
 // The key is the function name and the value is the number of running instances
 type InstanceStatus map[string]int
 
+// Concurrency is the load of a function
+type Concurrency struct {
+	// Instances is the number of running instances
+	Instances int
+	// InFlight is the number of requests the instances are handling
+	InFlight int
+	// Queued is the number of requests waiting for saturated instances
+	Queued int
+	// Minimum is the number of instances kept warm by the function settings
+	Minimum int
+}
+
+// ConcurrencyStatus is the load of the Runner.
+// The key is the function name and the value is the load of the function
+type ConcurrencyStatus map[string]Concurrency
+
+// KeepAliveWindow is how long the instances of a function are kept warm
+type KeepAliveWindow struct {
+	// PreWarm is how long after the latest request the function is warmed up again,
+	// the instances are unloaded after their requests when it's not 0
+	PreWarm time.Duration
+	// KeepAlive is how long an instance is kept since it's warmed up or since its latest request
+	KeepAlive time.Duration
+	// Adaptive is false when the window falls back to the global TTL
+	Adaptive bool
+	// Samples is the number of the inter-arrival times recorded
+	Samples int
+}
+
+// KeepAliveStatus is the keep-alive windows of the Runner.
+// The key is the function name and the value is the window chosen for the function
+type KeepAliveStatus map[string]KeepAliveWindow
+
 type InstanceType string
 
 const (
-	MaxScore int = 9999
+	// MaxScore is the score of the instances out of service, the scores of the running ones are lower
+	MaxScore int = math.MaxInt32
 )
 
 var (
@@ -30,3 +67,15 @@ type Runner interface {
 	// if the instanceSet for the function doesn't exist, it returns 0
 	FunctionStats(functionName string) int
 }
+
+// ConcurrencyStatistics is implemented by the Runners which know the in-flight requests of the instances
+type ConcurrencyStatistics interface {
+	// ConcurrencyStats returns the load of each function
+	ConcurrencyStats() ConcurrencyStatus
+}
+
+// KeepAliveStatistics is implemented by the Runners which choose the keep-alive windows of the functions
+type KeepAliveStatistics interface {
+	// KeepAliveStats returns the keep-alive window of each function
+	KeepAliveStats() KeepAliveStatus
+}
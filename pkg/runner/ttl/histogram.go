@@ -0,0 +1,189 @@
+package ttl
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/runner"
+)
+
+// The histogram keep-alive policy follows the hybrid histogram policy of the serverless literature.
+// It records the inter-arrival times of each function, the head percentile of the histogram is the pre-warm window
+// and the tail percentile is the end of the keep-alive window. A function invoked periodically is unloaded
+// after its requests and warmed up again just before the next request is expected.
+
+const (
+	FixedKeepAlivePolicy     = "fixed"
+	HistogramKeepAlivePolicy = "histogram"
+
+	// bucketWidth and bucketNumber are the range of the inter-arrival times recorded by the histogram
+	bucketWidth  = time.Second
+	bucketNumber = 3600
+	// historySize is the number of the latest inter-arrival times kept in the histogram,
+	// the older ones are forgotten so that the histogram follows the changes of the traffic
+	historySize = 1000
+	// minSamples is the number of the inter-arrival times required before the histogram is trusted
+	minSamples = 10
+	// maxOutOfRange is the max ratio of the inter-arrival times out of the histogram range
+	maxOutOfRange  = 0.5
+	headPercentile = 0.05
+	tailPercentile = 0.99
+	// windowMargin widens the windows to tolerate the small changes of the inter-arrival times
+	windowMargin = 0.1
+	// minPreWarm is the shortest pre-warm window, unloading an instance for a shorter time costs more than keeping it
+	minPreWarm = 10 * time.Second
+)
+
+var (
+	keepAlivePolicies = map[string]KeepAlivePolicy{
+		FixedKeepAlivePolicy:     fixedPolicy,
+		HistogramKeepAlivePolicy: histogramPolicy,
+	}
+)
+
+// KeepAlivePolicy chooses the keep-alive window of a function by the inter-arrival times of its requests
+type KeepAlivePolicy func(h *Histogram) runner.KeepAliveWindow
+
+// RegisterKeepAlivePolicy registers a custom KeepAlivePolicy which can be chosen by the startup parameter,
+// it should be called before the scheduler starts
+func RegisterKeepAlivePolicy(name string, policy KeepAlivePolicy) {
+	keepAlivePolicies[name] = policy
+}
+
+// getKeepAlivePolicy returns the KeepAlivePolicy chosen by the startup parameter,
+// the fixed policy is returned if it's unknown
+func getKeepAlivePolicy() KeepAlivePolicy {
+	policy, existed := keepAlivePolicies[viper.GetString(env.KeepAlivePolicy)]
+	if !existed {
+		return keepAlivePolicies[FixedKeepAlivePolicy]
+	}
+	return policy
+}
+
+// fixedPolicy keeps the instances for the global TTL
+func fixedPolicy(h *Histogram) runner.KeepAliveWindow {
+	return runner.KeepAliveWindow{
+		KeepAlive: viper.GetDuration(env.TTL),
+		Samples:   h.Samples(),
+	}
+}
+
+// histogramPolicy chooses the windows from the percentiles of the histogram,
+// it falls back to the fixed policy when the samples are too few or most of them are out of range
+func histogramPolicy(h *Histogram) runner.KeepAliveWindow {
+	samples, outOfRange := h.Samples(), h.OutOfRange()
+	if samples < minSamples || float64(outOfRange) > float64(samples)*maxOutOfRange {
+		return fixedPolicy(h)
+	}
+	head := time.Duration(float64(h.Percentile(headPercentile)) * (1 - windowMargin))
+	tail := time.Duration(float64(h.Percentile(tailPercentile)) * (1 + windowMargin))
+	if head < minPreWarm {
+		head = 0
+	}
+	return runner.KeepAliveWindow{
+		PreWarm:   head,
+		KeepAlive: tail - head,
+		Adaptive:  true,
+		Samples:   samples,
+	}
+}
+
+// Histogram records the latest inter-arrival times of the requests of a function
+type Histogram struct {
+	mu      sync.Locker
+	buckets []int
+	// history is a ring of the bucket indexes of the latest inter-arrival times,
+	// the index out of the buckets means the time is out of range. next is where the next one is written
+	history []int
+	next    int
+	// inRange is the number of the inter-arrival times in the buckets
+	inRange    int
+	outOfRange int
+	// last is when the latest request arrives, it's zero before the first request
+	last time.Time
+}
+
+// newHistogram returns an empty Histogram
+func newHistogram() *Histogram {
+	return &Histogram{
+		mu:      &sync.Mutex{},
+		buckets: make([]int, bucketNumber),
+		history: make([]int, 0, historySize),
+	}
+}
+
+// Record records a request arriving at the time (param1), the oldest inter-arrival time is forgotten
+// if the history is full
+func (h *Histogram) Record(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.last.IsZero() {
+		index := int(now.Sub(h.last) / bucketWidth)
+		if index < 0 {
+			index = 0
+		}
+		if index > len(h.buckets) {
+			index = len(h.buckets)
+		}
+		if len(h.history) < cap(h.history) {
+			h.history = append(h.history, index)
+		} else {
+			h.count(h.history[h.next], -1)
+			h.history[h.next] = index
+		}
+		h.next = (h.next + 1) % cap(h.history)
+		h.count(index, 1)
+	}
+	if now.After(h.last) {
+		h.last = now
+	}
+}
+
+// count adds the delta (param2) to the bucket of the index (param1)
+func (h *Histogram) count(index, delta int) {
+	if index >= len(h.buckets) {
+		h.outOfRange += delta
+		return
+	}
+	h.buckets[index] += delta
+	h.inRange += delta
+}
+
+// Samples returns the number of the inter-arrival times recorded
+func (h *Histogram) Samples() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.inRange + h.outOfRange
+}
+
+// OutOfRange returns the number of the inter-arrival times longer than the histogram range
+func (h *Histogram) OutOfRange() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.outOfRange
+}
+
+// Percentile returns the upper bound of the bucket where the percentile (param1) of the inter-arrival times
+// in range falls, it returns 0 if nothing is recorded
+func (h *Histogram) Percentile(p float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inRange == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(h.inRange)))
+	if rank < 1 {
+		rank = 1
+	}
+	count := 0
+	for i, n := range h.buckets {
+		count += n
+		if count >= rank {
+			return time.Duration(i+1) * bucketWidth
+		}
+	}
+	return time.Duration(len(h.buckets)) * bucketWidth
+}
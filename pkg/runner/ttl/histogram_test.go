@@ -0,0 +1,158 @@
+package ttl
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"github.com/tass-io/scheduler/pkg/event"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+)
+
+// fakeMetricsHandler records the events sent by the TTLManager
+type fakeMetricsHandler struct {
+	events chan event.ScheduleEvent
+}
+
+func (h *fakeMetricsHandler) AddEvent(e event.ScheduleEvent) {
+	h.events <- e
+}
+
+func (h *fakeMetricsHandler) GetSource() event.Source {
+	return event.MetricsSource
+}
+
+func (h *fakeMetricsHandler) Start() error {
+	return nil
+}
+
+func TestKeepAlivePolicy(t *testing.T) {
+	Convey("test the keep-alive policies", t, func() {
+		viper.Set(env.TTL, 20*time.Second)
+		viper.Set(env.KeepAlivePolicy, HistogramKeepAlivePolicy)
+		defer viper.Set(env.KeepAlivePolicy, "")
+		// periodic returns a histogram of n requests arriving every interval (param2)
+		periodic := func(n int, interval time.Duration) *Histogram {
+			h := newHistogram()
+			now := time.Now()
+			for i := 0; i < n; i++ {
+				h.Record(now.Add(time.Duration(i) * interval))
+			}
+			return h
+		}
+
+		Convey("the histogram records the inter-arrival times", func() {
+			h := newHistogram()
+			now := time.Now()
+			So(h.Percentile(0.5), ShouldEqual, 0)
+			h.Record(now)
+			h.Record(now.Add(1500 * time.Millisecond))
+			h.Record(now.Add(2500 * time.Millisecond))
+			h.Record(now.Add(2 * time.Hour))
+			So(h.Samples(), ShouldEqual, 3)
+			So(h.OutOfRange(), ShouldEqual, 1)
+			So(h.Percentile(0.5), ShouldEqual, 2*time.Second)
+		})
+
+		Convey("the histogram forgets the oldest inter-arrival times", func() {
+			h := newHistogram()
+			now := time.Now()
+			// record n requests arriving every interval (param2) after the latest one
+			record := func(n int, interval time.Duration) {
+				for i := 0; i < n; i++ {
+					now = now.Add(interval)
+					h.Record(now)
+				}
+			}
+			record(historySize+1, time.Second)
+			So(h.Samples(), ShouldEqual, historySize)
+			So(h.Percentile(0.5), ShouldEqual, 2*time.Second)
+			record(historySize/2+1, time.Minute)
+			So(h.Samples(), ShouldEqual, historySize)
+			So(h.Percentile(0.5), ShouldEqual, 61*time.Second)
+			record(historySize, 2*time.Hour)
+			So(h.Samples(), ShouldEqual, historySize)
+			So(h.OutOfRange(), ShouldEqual, historySize)
+			So(h.Percentile(0.5), ShouldEqual, 0)
+		})
+
+		Convey("the fixed policy keeps the instances for the TTL", func() {
+			viper.Set(env.KeepAlivePolicy, FixedKeepAlivePolicy)
+			window := getKeepAlivePolicy()(periodic(20, time.Minute))
+			So(window.Adaptive, ShouldBeFalse)
+			So(window.PreWarm, ShouldEqual, 0)
+			So(window.KeepAlive, ShouldEqual, 20*time.Second)
+			So(window.Samples, ShouldEqual, 19)
+		})
+
+		Convey("the histogram policy falls back to the TTL when the samples are sparse", func() {
+			window := getKeepAlivePolicy()(periodic(5, time.Minute))
+			So(window.Adaptive, ShouldBeFalse)
+			So(window.KeepAlive, ShouldEqual, 20*time.Second)
+			window = getKeepAlivePolicy()(periodic(20, 2*time.Hour))
+			So(window.Adaptive, ShouldBeFalse)
+		})
+
+		Convey("the histogram policy pre-warms the periodic function", func() {
+			window := getKeepAlivePolicy()(periodic(20, time.Minute))
+			// the inter-arrival times fall in the bucket [60s, 61s)
+			bound := float64(61 * time.Second)
+			So(window.Adaptive, ShouldBeTrue)
+			So(window.PreWarm, ShouldEqual, time.Duration(bound*0.9))
+			So(window.PreWarm+window.KeepAlive, ShouldEqual, time.Duration(bound*1.1))
+		})
+
+		Convey("the histogram policy keeps the frequent function warm", func() {
+			window := getKeepAlivePolicy()(periodic(20, 2*time.Second))
+			So(window.Adaptive, ShouldBeTrue)
+			So(window.PreWarm, ShouldEqual, 0)
+			bound := float64(3 * time.Second)
+			So(window.KeepAlive, ShouldEqual, time.Duration(bound*1.1))
+		})
+
+		Convey("the manager caches the keep-alive window", func() {
+			ttl := NewTTLManager("b")
+			now := time.Now()
+			for i := 0; i < 2*refreshSamples; i++ {
+				ttl.Arrive(now.Add(time.Duration(i) * time.Minute))
+			}
+			window := ttl.Window()
+			So(window.Adaptive, ShouldBeTrue)
+			So(window.Samples, ShouldEqual, 2*refreshSamples-1)
+			// the window is chosen again after refreshSamples requests
+			ttl.Arrive(now.Add(2 * time.Hour))
+			So(ttl.Window(), ShouldResemble, window)
+			ttl.Lock()
+			ttl.refreshed = time.Now().Add(-2 * refreshInterval)
+			ttl.Unlock()
+			So(ttl.Window().Samples, ShouldEqual, 2*refreshSamples)
+			ttl.Lock()
+			ttl.prewarm.Stop()
+			ttl.Unlock()
+		})
+
+		Convey("the manager pre-warms the function without instances", func() {
+			handler, ok := event.GetHandlerBySource(event.MetricsSource).(*fakeMetricsHandler)
+			if !ok {
+				handler = &fakeMetricsHandler{events: make(chan event.ScheduleEvent, 10)}
+				event.Register(event.MetricsSource, handler, 0, true)
+			}
+			ttl := NewTTLManager("a")
+			ttl.warmUp()
+			e := <-handler.events
+			So(e.Trend, ShouldEqual, event.Increase)
+			So(e.Target, ShouldEqual, 1)
+			ttl.Append(instance.NewMockInstance("a"))
+			e = <-handler.events
+			So(e.Trend, ShouldEqual, event.Decrease)
+			ttl.Lock()
+			So(ttl.prewarming, ShouldBeFalse)
+			ttl.Unlock()
+			// the function has an instance, so it's not pre-warmed again
+			ttl.warmUp()
+			So(handler.events, ShouldBeEmpty)
+		})
+	})
+}
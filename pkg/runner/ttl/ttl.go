@@ -5,15 +5,23 @@ import (
 	"sync"
 	"time"
 
-	"github.com/spf13/viper"
-	"github.com/tass-io/scheduler/pkg/env"
 	"github.com/tass-io/scheduler/pkg/event"
+	"github.com/tass-io/scheduler/pkg/runner"
 	"github.com/tass-io/scheduler/pkg/runner/instance"
 	"go.uber.org/zap"
 )
 
 var ErrTimerStopped = errors.New("timer has expired or been stopped")
 
+const (
+	// unloadDelay is the clock of the instances unloaded after their requests, the busy ones are checked again after it
+	unloadDelay = time.Second
+	// refreshSamples and refreshInterval are how often the keep-alive window is chosen again,
+	// the window is cached because the policy scans the whole histogram
+	refreshSamples  = 10
+	refreshInterval = time.Minute
+)
+
 // Manager is a center to records all instances live time for a specific function
 type Manager struct {
 	sync.Locker
@@ -21,6 +29,18 @@ type Manager struct {
 	timers       map[instance.Instance]*time.Timer
 	timeout      chan instance.Instance
 	append       chan instance.Instance
+	// minimum is the number of instances kept warm even if their clocks expire
+	minimum int
+	// histogram records the inter-arrival times of the requests of the function
+	histogram *Histogram
+	// window is the cached keep-alive window, it's chosen again after refreshSamples requests or refreshInterval
+	window    runner.KeepAliveWindow
+	refreshed time.Time
+	arrived   int
+	// prewarm warms up the function when the next request is expected,
+	// prewarming is true until the pre-warmed instance is appended
+	prewarm    *time.Timer
+	prewarming bool
 }
 
 // clean stops the clock for an instance
@@ -40,17 +60,121 @@ func (ttl *Manager) Release(ins instance.Instance) {
 	ttl.timeout <- ins
 }
 
+// SetMinimum sets the number of instances kept warm even if their clocks expire
+func (ttl *Manager) SetMinimum(minimum int) {
+	ttl.Lock()
+	defer ttl.Unlock()
+	ttl.minimum = minimum
+}
+
+// keepWarm returns whether releasing one more instance makes the function below the minimum
+func (ttl *Manager) keepWarm() bool {
+	ttl.Lock()
+	defer ttl.Unlock()
+	return len(ttl.timers) <= ttl.minimum
+}
+
+// Remove stops the clock of an instance which has exited by itself,
+// unlike Release, it doesn't generate a ttl event
+func (ttl *Manager) Remove(ins instance.Instance) {
+	ttl.clean(ins)
+}
+
 // Append appends a new instance clock in the TTLManager
 func (ttl *Manager) Append(ins instance.Instance) {
 	ttl.append <- ins
 }
 
-// start starts the instance clock in the TTLManager
-// TODO: When a request comes, update the timer
+// Window returns the keep-alive window chosen for the function
+func (ttl *Manager) Window() runner.KeepAliveWindow {
+	ttl.Lock()
+	defer ttl.Unlock()
+	return ttl.cachedWindow()
+}
+
+// cachedWindow returns the cached keep-alive window, it chooses the window again if the cache is stale.
+// cachedWindow must be called with the lock held
+func (ttl *Manager) cachedWindow() runner.KeepAliveWindow {
+	if ttl.arrived >= refreshSamples || time.Since(ttl.refreshed) > refreshInterval {
+		ttl.window = getKeepAlivePolicy()(ttl.histogram)
+		ttl.refreshed = time.Now()
+		ttl.arrived = 0
+	}
+	return ttl.window
+}
+
+// keepAlive returns the clock of an instance since it's started or since its latest request
+func (ttl *Manager) keepAlive() time.Duration {
+	ttl.Lock()
+	defer ttl.Unlock()
+	return ttl.clock()
+}
+
+// clock returns the clock of an instance by the cached window, it must be called with the lock held
+func (ttl *Manager) clock() time.Duration {
+	window := ttl.cachedWindow()
+	if window.PreWarm > 0 {
+		// the instance is unloaded after its requests and the function is pre-warmed before the next one
+		return unloadDelay
+	}
+	return window.KeepAlive
+}
+
+// Arrive records a request of the function arriving at the time (param1),
+// it schedules the pre-warm of the function if the keep-alive window has one
+func (ttl *Manager) Arrive(now time.Time) {
+	ttl.histogram.Record(now)
+	ttl.Lock()
+	defer ttl.Unlock()
+	ttl.arrived++
+	window := ttl.cachedWindow()
+	ttl.prewarming = false
+	if ttl.prewarm != nil {
+		ttl.prewarm.Stop()
+	}
+	if window.PreWarm <= 0 {
+		return
+	}
+	// the pre-warm timer is created once and reused by the requests
+	if ttl.prewarm == nil {
+		ttl.prewarm = time.AfterFunc(window.PreWarm, ttl.warmUp)
+	} else {
+		ttl.prewarm.Reset(window.PreWarm)
+	}
+}
+
+// warmUp creates an instance for the expected request if the function has none
+func (ttl *Manager) warmUp() {
+	ttl.Lock()
+	if len(ttl.timers) > 0 {
+		ttl.Unlock()
+		return
+	}
+	ttl.prewarming = true
+	ttl.Unlock()
+	zap.S().Infow("pre-warm function", "function", ttl.functionName)
+	event.GetHandlerBySource(event.MetricsSource).AddEvent(event.ScheduleEvent{
+		FunctionName: ttl.functionName,
+		Target:       1,
+		Trend:        event.Increase,
+		Source:       event.TTLSource,
+	})
+}
+
+// start starts the instance clock in the TTLManager,
+// the pre-warmed instance is kept for the whole keep-alive window
 func (ttl *Manager) start(ins instance.Instance) {
-	ttl.timers[ins] = time.NewTimer(viper.GetDuration(env.TTL))
+	ttl.Lock()
+	clock := ttl.clock()
+	if ttl.prewarming {
+		ttl.prewarming = false
+		clock = ttl.cachedWindow().KeepAlive
+	}
+	// the timer is passed to the goroutine, the instance may be removed from the timers before it runs
+	timer := time.NewTimer(clock)
+	ttl.timers[ins] = timer
+	ttl.Unlock()
 	go func() {
-		timer := ttl.timers[ins]
 		<-timer.C
 		ttl.Release(ins)
 	}()
@@ -65,15 +189,25 @@ func NewTTLManager(functionName string) *Manager {
 		timers:       make(map[instance.Instance]*time.Timer),
 		timeout:      make(chan instance.Instance, 10),
 		append:       make(chan instance.Instance, 10),
+		histogram:    newHistogram(),
 	}
+	ttl.window = getKeepAlivePolicy()(ttl.histogram)
+	ttl.refreshed = time.Now()
 	go func() {
 		for {
 			select {
 			case ins := <-ttl.timeout:
-				if ins.IsRunning() && ins.HasRequests() {
-					ttl.timers[ins].Reset(viper.GetDuration(env.TTL))
+				// busy instances and the minimum instances restart their clocks
+				if ins.IsRunning() && (ins.HasRequests() || ttl.keepWarm()) {
+					ttl.Lock()
+					timer, existed := ttl.timers[ins]
+					ttl.Unlock()
+					if !existed {
+						// the instance has been removed
+						continue
+					}
+					timer.Reset(ttl.keepAlive())
 					go func() {
-						timer := ttl.timers[ins]
 						<-timer.C
 						ttl.Release(ins)
 					}()
@@ -116,6 +250,6 @@ func (ttl *Manager) ResetInstanceTimer(ins instance.Instance) error {
 	if !stopSucc {
 		return ErrTimerStopped
 	}
-	timer.Reset(viper.GetDuration(env.TTL))
+	timer.Reset(ttl.keepAlive())
 	return nil
 }
@@ -4,8 +4,9 @@ package schedule
 type Scheduler interface {
 	// Refresh adjusts function instances number(param2) with upstream events
 	Refresh(functionName string, target int)
-	// ColdStartDone returns when the function process (param1) cold start stage is finished
-	ColdStartDone(functionName string)
+	// ColdStartDone returns when the function process (param1) cold start stage is finished,
+	// the error explains why the process can't be created locally
+	ColdStartDone(functionName string) error
 	// NewInstanceSetIfNotExist creates a not existed instance set struct for the function(param1)
 	NewInstanceSetIfNotExist(functionName string)
 }
@@ -1,6 +1,7 @@
 package span
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sync"
@@ -11,7 +12,8 @@ import (
 
 // Span is a context info for a request
 // scheduler plan to use jaeger implement tracing
-// may be the struct will put context.Context into
+// the context.Context of the request is also carried by the Span,
+// it's used to cancel the request when the client leaves or the deadline exceeds
 // all flows are the same level
 // all conditions in the "conditions" are the same level
 type Span struct {
@@ -19,6 +21,7 @@ type Span struct {
 	upstreamFlowName string
 	flowName         string
 	functionName     string
+	ctx              context.Context
 	root             opentracing.SpanContext
 	parent           opentracing.SpanContext
 	sp               opentracing.Span
@@ -34,6 +37,7 @@ func NewSpan(workflowName, upstreamFlowName, flowName, functioName string) *Span
 		upstreamFlowName: upstreamFlowName,
 		flowName:         flowName,
 		functionName:     functioName,
+		ctx:              context.Background(),
 		startOnce:        &sync.Once{},
 		finishOnce:       &sync.Once{},
 	}
@@ -54,6 +58,7 @@ func NewSpanFromTheSameFlowSpanAsParent(sp *Span) *Span {
 		upstreamFlowName: sp.upstreamFlowName,
 		flowName:         sp.flowName,
 		functionName:     sp.functionName,
+		ctx:              sp.ctx,
 		root:             sp.root,
 		parent:           parent,
 		startOnce:        &sync.Once{},
@@ -66,6 +71,7 @@ func NewSpanFromSpanSibling(sp *Span) *Span {
 	return &Span{
 		workflowName:     sp.workflowName,
 		upstreamFlowName: sp.flowName,
+		ctx:              sp.ctx,
 		root:             sp.root,
 		// FIXME: Can this be `sp.parent` ?
 		parent:     sp.root,
@@ -90,6 +96,11 @@ func (span *Span) GetFlowName() string {
 	return span.flowName
 }
 
+// GetContext returns the context.Context of the request
+func (span *Span) GetContext() context.Context {
+	return span.ctx
+}
+
 func (span *Span) GetRoot() opentracing.SpanContext {
 	return span.root
 }
@@ -110,6 +121,12 @@ func (span *Span) SetFlowName(flowName string) {
 	span.flowName = flowName
 }
 
+// SetContext sets the context.Context of the request,
+// all Spans derived from the Span later share the same context
+func (span *Span) SetContext(ctx context.Context) {
+	span.ctx = ctx
+}
+
 func (span *Span) SetRoot(root opentracing.SpanContext) {
 	span.root = root
 }
@@ -160,6 +177,22 @@ func (span *Span) Finish() {
 	})
 }
 
+// LogKV records key:value logging data about the span, it does nothing if the span is not started
+func (span *Span) LogKV(alternatingKeyValues ...interface{}) {
+	if span.sp == nil {
+		return
+	}
+	span.sp.LogKV(alternatingKeyValues...)
+}
+
+// SetTag adds a tag to the span, it does nothing if the span is not started
+func (span *Span) SetTag(key string, value interface{}) {
+	if span.sp == nil {
+		return
+	}
+	span.sp.SetTag(key, value)
+}
+
 // InjectRoot injects root span into http header
 func (span *Span) InjectRoot(header http.Header) {
 	if span.root == nil {
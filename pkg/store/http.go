@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+)
+
+// httpTimeout is the timeout of a request to the http and s3 code stores
+const httpTimeout = time.Minute
+
+// httpStore fetches the function code from plain http(s) urls, the layout is <url>/<namespace>/<name>,
+// the optional digest is at <url>/<namespace>/<name>.sha256. It's read-only.
+type httpStore struct {
+	url    string
+	client *http.Client
+}
+
+var _ CodeStore = &httpStore{}
+
+func newHTTPStore() (CodeStore, error) {
+	url := viper.GetString(env.CodeStoreURL)
+	if url == "" {
+		return nil, fmt.Errorf("the url of the http code store is not set")
+	}
+	return &httpStore{url: strings.TrimSuffix(url, "/"), client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+// Get downloads the code
+func (s *httpStore) Get(ns, name string) (string, error) {
+	content, found, err := s.get(s.objectURL(ns, name))
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%w: %s does not exist", ErrCodeNotFound, s.objectURL(ns, name))
+	}
+	return content, nil
+}
+
+// Set is not supported by the http code store
+func (s *httpStore) Set(ns, name, code string) error {
+	return ErrReadOnlyStore
+}
+
+// Digest downloads the digest, it's empty if the server doesn't provide it
+func (s *httpStore) Digest(ns, name string) (string, error) {
+	content, _, err := s.get(s.objectURL(ns, name) + digestSuffix)
+	return strings.TrimSpace(content), err
+}
+
+// List is not supported by the http code store, because a plain http server can't list the files
+func (s *httpStore) List(ns string) ([]CodeInfo, error) {
+	return nil, ErrNotSupported
+}
+
+// Delete is not supported by the http code store
+func (s *httpStore) Delete(ns, name string) error {
+	return ErrReadOnlyStore
+}
+
+// get downloads the url, it returns false if the url is not found
+func (s *httpStore) get(url string) (string, bool, error) {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("get %s error: %s", url, resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+	return string(content), true, nil
+}
+
+// objectURL returns the url of the code
+func (s *httpStore) objectURL(ns, name string) string {
+	return s.url + "/" + ns + "/" + name
+}
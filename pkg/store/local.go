@@ -0,0 +1,118 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+)
+
+// digestSuffix is the suffix of the digest file or object stored next to the function code
+const digestSuffix = ".sha256"
+
+// localStore stores the function code as files in a local directory, the layout is <dir>/<namespace>/<name>
+type localStore struct {
+	dir string
+}
+
+var _ CodeStore = &localStore{}
+
+func newLocalStore() (CodeStore, error) {
+	dir := viper.GetString(env.CodeStorePath)
+	if dir == "" {
+		return nil, fmt.Errorf("the path of the local code store is not set")
+	}
+	return &localStore{dir: dir}, nil
+}
+
+// Get reads the code file
+func (s *localStore) Get(ns, name string) (string, error) {
+	content, err := ioutil.ReadFile(s.path(ns, name))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrCodeNotFound, s.path(ns, name))
+	}
+	return string(content), err
+}
+
+// Set writes the digest file and then the code file, both are replaced atomically
+func (s *localStore) Set(ns, name, code string) error {
+	if err := os.MkdirAll(filepath.Join(s.dir, ns), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path(ns, name)+digestSuffix, Checksum(code)); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(ns, name), code)
+}
+
+// Digest reads the digest file
+func (s *localStore) Digest(ns, name string) (string, error) {
+	content, err := ioutil.ReadFile(s.path(ns, name) + digestSuffix)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(content)), err
+}
+
+// List reads the code files in the namespace directory
+func (s *localStore) List(ns string) ([]CodeInfo, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, ns))
+	if os.IsNotExist(err) {
+		return []CodeInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	infos := []CodeInfo{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), digestSuffix) || strings.HasPrefix(f.Name(), ".tmp-") {
+			continue
+		}
+		digest, err := s.Digest(ns, f.Name())
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, CodeInfo{Name: f.Name(), Size: f.Size(), Digest: digest})
+	}
+	return infos, nil
+}
+
+// Delete removes the code file and the digest file
+func (s *localStore) Delete(ns, name string) error {
+	if err := os.Remove(s.path(ns, name)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s does not exist", ErrCodeNotFound, s.path(ns, name))
+		}
+		return err
+	}
+	if err := os.Remove(s.path(ns, name) + digestSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path returns the path of the code file
+func (s *localStore) path(ns, name string) string {
+	return filepath.Join(s.dir, ns, name)
+}
+
+// writeFileAtomic writes the content to a temp file and renames it to the path
+func writeFileAtomic(path, content string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
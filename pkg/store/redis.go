@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+	"go.uber.org/zap"
+)
+
+var (
+	rdb  *redis.Client
+	once = &sync.Once{}
+)
+
+// getrdb initializes a redis client
+func getrdb() *redis.Client {
+	once.Do(func() {
+		zap.S().Debugw("get redis config from viper", "addr", viper.GetString(env.RedisIP)+":"+viper.GetString(env.RedisPort))
+		rdb = redis.NewClient(&redis.Options{
+			Addr:     viper.GetString(env.RedisIP) + ":" + viper.GetString(env.RedisPort),
+			Password: viper.GetString(env.RedisPassword),
+			DB:       viper.GetInt(env.DefaultDb),
+		})
+	})
+	return rdb
+}
+
+// redisStore stores the function code in redis, it's the default code store
+type redisStore struct{}
+
+var _ CodeStore = &redisStore{}
+
+func newRedisStore() (CodeStore, error) {
+	if viper.GetString(env.RedisIP) == "" {
+		return nil, fmt.Errorf("the address of the redis code store is not set")
+	}
+	return &redisStore{}, nil
+}
+
+// Get gets the function source code by name and namespace
+func (s *redisStore) Get(ns, name string) (string, error) {
+	key := buildKey(ns, name)
+	val, err := getrdb().Get(context.Background(), key).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("%w: %s does not exist", ErrCodeNotFound, key)
+	}
+	return val, err
+}
+
+// Set stores the function code and its digest in a transaction
+func (s *redisStore) Set(ns, name, code string) error {
+	key := buildKey(ns, name)
+	_, err := getrdb().TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Set(context.Background(), key, code, 0)
+		pipe.Set(context.Background(), digestKey(key), Checksum(code), 0)
+		return nil
+	})
+	return err
+}
+
+// Digest gets the digest stored with the function code
+func (s *redisStore) Digest(ns, name string) (string, error) {
+	val, err := getrdb().Get(context.Background(), digestKey(buildKey(ns, name))).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+// List scans the keys of the namespace and gets their sizes and digests
+func (s *redisStore) List(ns string) ([]CodeInfo, error) {
+	ctx := context.Background()
+	prefix := buildKey(ns, "")
+	infos := []CodeInfo{}
+	iter := getrdb().Scan(ctx, 0, prefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if strings.HasSuffix(key, digestKey("")) {
+			continue
+		}
+		size, err := getrdb().StrLen(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(key, prefix)
+		digest, err := s.Digest(ns, name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, CodeInfo{Name: name, Size: size, Digest: digest})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Delete deletes the code and its digest
+func (s *redisStore) Delete(ns, name string) error {
+	key := buildKey(ns, name)
+	n, err := getrdb().Del(context.Background(), key, digestKey(key)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s does not exist", ErrCodeNotFound, key)
+	}
+	return nil
+}
+
+// digestKey generates the key of the code digest by the code key
+// e.g. "namespace:name" -> "namespace:name:sha256"
+func digestKey(key string) string {
+	return key + ":sha256"
+}
@@ -0,0 +1,306 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tass-io/scheduler/pkg/env"
+)
+
+const (
+	// s3DigestHeader is the user metadata of the object storing the code digest
+	s3DigestHeader = "X-Amz-Meta-Sha256"
+	s3Service      = "s3"
+	s3Algorithm    = "AWS4-HMAC-SHA256"
+	s3DateFormat   = "20060102T150405Z"
+)
+
+// s3Store stores the function code in an S3-compatible object store like MinIO,
+// the object key is <namespace>/<name> in the bucket and the requests are path-style and signed by AWS Signature V4
+type s3Store struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+var _ CodeStore = &s3Store{}
+
+func newS3Store() (CodeStore, error) {
+	endpoint := viper.GetString(env.CodeStoreURL)
+	bucket := viper.GetString(env.S3Bucket)
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("the endpoint or the bucket of the s3 code store is not set")
+	}
+	return &s3Store{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		bucket:    bucket,
+		region:    viper.GetString(env.S3Region),
+		accessKey: viper.GetString(env.S3AccessKey),
+		secretKey: viper.GetString(env.S3SecretKey),
+		client:    &http.Client{Timeout: httpTimeout},
+		now:       time.Now,
+	}, nil
+}
+
+// Get gets the object
+func (s *s3Store) Get(ns, name string) (string, error) {
+	resp, err := s.do(http.MethodGet, s.key(ns, name), nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: %s/%s does not exist", ErrCodeNotFound, s.bucket, s.key(ns, name))
+	}
+	if err := checkS3Status(resp); err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	return string(content), err
+}
+
+// Set puts the object with the digest as its metadata
+func (s *s3Store) Set(ns, name, code string) error {
+	header := http.Header{s3DigestHeader: []string{Checksum(code)}}
+	resp, err := s.do(http.MethodPut, s.key(ns, name), nil, []byte(code), header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkS3Status(resp)
+}
+
+// Digest reads the metadata of the object by a HEAD request
+func (s *s3Store) Digest(ns, name string) (string, error) {
+	resp, err := s.do(http.MethodHead, s.key(ns, name), nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if err := checkS3Status(resp); err != nil {
+		return "", err
+	}
+	return resp.Header.Get(s3DigestHeader), nil
+}
+
+// s3ListResult is the response of ListObjectsV2
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key  string
+		Size int64
+	}
+}
+
+// List lists the objects with the namespace prefix by ListObjectsV2, the digests are read one by one
+func (s *s3Store) List(ns string) ([]CodeInfo, error) {
+	prefix := ns + "/"
+	infos := []CodeInfo{}
+	token := ""
+	for {
+		query := url.Values{"list-type": []string{"2"}, "prefix": []string{prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		result, err := s.list(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, prefix)
+			digest, err := s.Digest(ns, name)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, CodeInfo{Name: name, Size: object.Size, Digest: digest})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return infos, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// list sends a ListObjectsV2 request and decodes the response
+func (s *s3Store) list(query url.Values) (*s3ListResult, error) {
+	resp, err := s.do(http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkS3Status(resp); err != nil {
+		return nil, err
+	}
+	result := &s3ListResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Delete deletes the object, it checks the existence first because S3 deletes a missing object successfully
+func (s *s3Store) Delete(ns, name string) error {
+	headResp, err := s.do(http.MethodHead, s.key(ns, name), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	headResp.Body.Close()
+	if headResp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s/%s does not exist", ErrCodeNotFound, s.bucket, s.key(ns, name))
+	}
+	resp, err := s.do(http.MethodDelete, s.key(ns, name), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkS3Status(resp)
+}
+
+// key returns the object key of the code
+func (s *s3Store) key(ns, name string) string {
+	return ns + "/" + name
+}
+
+// do sends a signed request for the object key (param2), the request is for the bucket if the key is empty
+func (s *s3Store) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.bucket
+	u.RawPath = "/" + s3URIEncode(s.bucket, false)
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + s3URIEncode(key, false)
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body)
+	return s.client.Do(req)
+}
+
+// sign signs the request by AWS Signature V4 with the host, the x-amz-* headers and the payload hash
+func (s *s3Store) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format(s3DateFormat)
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lower := strings.ToLower(k); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{s3Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+// checkS3Status returns an error with the response body if the request fails
+func checkS3Status(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("s3 request %s %s error: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, content)
+}
+
+// s3CanonicalQuery returns the query sorted by the key with the values encoded
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3URIEncode encodes all characters except the unreserved ones, the slash is kept unless encodeSlash is set
+func s3URIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -1,50 +1,95 @@
 package store
 
 import (
-	"context"
 	"errors"
+	"fmt"
 	"sync"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/spf13/viper"
 	"github.com/tass-io/scheduler/pkg/env"
 	"go.uber.org/zap"
 )
 
 var (
-	rdb  *redis.Client
-	once = &sync.Once{}
+	ErrCodeNotFound  = errors.New("function code not found")
+	ErrReadOnlyStore = errors.New("code store is read-only")
+	ErrUnknownStore  = errors.New("unknown code store")
+	ErrNotSupported  = errors.New("operation not supported by the code store")
+
+	codeStore     CodeStore
+	codeStoreOnce = &sync.Once{}
+
+	// codeStores are the code store backends, the key is the value of the codeStore flag
+	codeStores = map[string]func() (CodeStore, error){
+		"redis": newRedisStore,
+		"local": newLocalStore,
+		"s3":    newS3Store,
+		"http":  newHTTPStore,
+	}
 )
 
-// getrdb initializes a redis client
-func getrdb() *redis.Client {
-	once.Do(func() {
-		zap.S().Debugw("get redis config from viper", "addr", viper.GetString(env.RedisIP)+":"+viper.GetString(env.RedisPort))
-		rdb = redis.NewClient(&redis.Options{
-			Addr:     viper.GetString(env.RedisIP) + ":" + viper.GetString(env.RedisPort),
-			Password: viper.GetString(env.RedisPassword),
-			DB:       viper.GetInt(env.DefaultDb),
-		})
+// CodeStore stores the function code by namespace and name
+type CodeStore interface {
+	// Get gets the function code, it returns ErrCodeNotFound if the code does not exist
+	Get(ns, name string) (string, error)
+	// Set stores the function code with its digest
+	Set(ns, name, code string) error
+	// Digest gets the digest of the function code without fetching the code,
+	// it returns an empty digest if the code is stored without the digest
+	Digest(ns, name string) (string, error)
+	// List lists the function code in the namespace
+	List(ns string) ([]CodeInfo, error)
+	// Delete deletes the function code with its digest, it returns ErrCodeNotFound if the code does not exist
+	Delete(ns, name string) error
+}
+
+// CodeInfo is the information of the stored function code
+type CodeInfo struct {
+	Name string
+	// Size is the size of the stored code in bytes
+	Size int64
+	// Digest is empty if the code is stored without the digest
+	Digest string
+}
+
+// GetCodeStore returns the CodeStore singleton chosen by the codeStore flag, redis by default
+func GetCodeStore() CodeStore {
+	codeStoreOnce.Do(func() {
+		s, err := NewCodeStore(viper.GetString(env.CodeStore))
+		if err != nil {
+			zap.S().Panicw("init code store error", "err", err)
+		}
+		codeStore = s
 	})
-	return rdb
+	return codeStore
+}
+
+// NewCodeStore returns the code store backend by name, the settings of the backend are from the flags
+func NewCodeStore(name string) (CodeStore, error) {
+	if name == "" {
+		name = "redis"
+	}
+	newStore, ok := codeStores[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStore, name)
+	}
+	return newStore()
 }
 
 // get gets the function source code by name and namespace
 var Get = func(ns, name string) (string, error) {
-	key := buildKey(ns, name)
-	val, err := getrdb().Get(context.Background(), key).Result()
-	if err == redis.Nil {
-		return "", errors.New(key + " does not exist")
-	}
-	return val, err
+	return GetCodeStore().Get(ns, name)
 }
 
 // set stores the function code by reading the path
-// The key is generated by name and namespace
+// The key is generated by name and namespace, the digest of the code is stored together
 var Set = func(ns, name, code string) error {
-	key := buildKey(ns, name)
-	err := getrdb().Set(context.Background(), key, code, 0).Err()
-	return err
+	return GetCodeStore().Set(ns, name, code)
+}
+
+// Checksum returns the hex sha256 digest of the code
+func Checksum(code string) string {
+	return sha256Hex([]byte(code))
 }
 
 // buildKey generates th Key by name and namespace
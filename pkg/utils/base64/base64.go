@@ -1,13 +1,24 @@
 package base64
 
 import (
+	"archive/zip"
 	"bufio"
+	"bytes"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
-// EncodeUserCode encodes the user code into base64
+// The user code is stored as a zip archive encoded into base64,
+// the function processes decode and unzip it, e.g. the Go runtime loads plugin.so in the archive.
+
+var ErrFileNotInCode = errors.New("file not found in the user code")
+
+// EncodeUserCode encodes the user code zip archive into base64
 func EncodeUserCode(name string) (string, error) {
 	f, err := os.Open(name)
 	if err != nil {
@@ -23,3 +34,94 @@ func EncodeUserCode(name string) (string, error) {
 	encoded := base64.StdEncoding.EncodeToString(content)
 	return encoded, nil
 }
+
+// EncodeUserFile zips the single file with its base name and encodes the zip into base64
+func EncodeUserFile(name string) (string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	if err := addFile(w, name, info, filepath.Base(name)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeUserFile decodes the user code and returns the content of the file (param2) in the zip archive
+func DecodeUserFile(code, name string) ([]byte, error) {
+	dec, err := base64.StdEncoding.DecodeString(code)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(bytes.NewReader(dec), int64(len(dec)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrFileNotInCode, name)
+}
+
+// EncodeUserDirectory zips the user code directory and encodes the zip into base64,
+// the paths in the zip are relative to the directory
+func EncodeUserDirectory(dir string) (string, error) {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		return addFile(w, path, info, filepath.ToSlash(rel))
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// addFile adds the file or the directory at the path (param2) to the zip archive with the name (param4)
+func addFile(w *zip.Writer, path string, info os.FileInfo, name string) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+		_, err = w.CreateHeader(header)
+		return err
+	}
+	header.Method = zip.Deflate
+	fw, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(fw, f)
+	return err
+}
@@ -0,0 +1,67 @@
+package base64
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncodeUserDirectory(t *testing.T) {
+	Convey("test the user code directory is zipped with the relative paths", t, func() {
+		dir, err := ioutil.TempDir("", "code")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.MkdirAll(filepath.Join(dir, "lib"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "index.js"), []byte("main"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "lib", "util.js"), []byte("util"), 0644), ShouldBeNil)
+
+		code, err := EncodeUserDirectory(dir)
+		So(err, ShouldBeNil)
+		dec, err := base64.StdEncoding.DecodeString(code)
+		So(err, ShouldBeNil)
+		r, err := zip.NewReader(bytes.NewReader(dec), int64(len(dec)))
+		So(err, ShouldBeNil)
+		files := map[string]string{}
+		for _, f := range r.File {
+			content := ""
+			if !f.FileInfo().IsDir() {
+				rc, err := f.Open()
+				So(err, ShouldBeNil)
+				b, err := ioutil.ReadAll(rc)
+				So(err, ShouldBeNil)
+				rc.Close()
+				content = string(b)
+			}
+			files[f.Name] = content
+		}
+		So(files, ShouldResemble, map[string]string{"index.js": "main", "lib/": "", "lib/util.js": "util"})
+	})
+}
+
+func TestEncodeUserFile(t *testing.T) {
+	Convey("test the single file is zipped with its name and decoded from the code", t, func() {
+		dir, err := ioutil.TempDir("", "code")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(ioutil.WriteFile(filepath.Join(dir, "plugin.so"), []byte("plugin"), 0644), ShouldBeNil)
+
+		code, err := EncodeUserFile(filepath.Join(dir, "plugin.so"))
+		So(err, ShouldBeNil)
+		plugin, err := DecodeUserFile(code, "plugin.so")
+		So(err, ShouldBeNil)
+		So(string(plugin), ShouldEqual, "plugin")
+		_, err = DecodeUserFile(code, "index.js")
+		So(errors.Is(err, ErrFileNotInCode), ShouldBeTrue)
+
+		// the plain base64 is not the user code
+		_, err = DecodeUserFile(base64.StdEncoding.EncodeToString([]byte("plugin")), "plugin.so")
+		So(err, ShouldNotBeNil)
+	})
+}
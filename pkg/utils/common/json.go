@@ -27,6 +27,15 @@ func GetValue(body map[string]interface{}, target string, obj interface{}) {
 		case *bool:
 			boolval := obj
 			*boolval = val.Bool()
+		case *float64:
+			floatval := obj
+			*floatval = val.Float()
+		case *[]interface{}:
+			arrval := obj
+			*arrval = nil
+			if val.IsArray() {
+				*arrval, _ = val.Value().([]interface{})
+			}
 		default:
 			zap.S().Panic(obj)
 		}
@@ -46,9 +55,26 @@ func GetValue(body map[string]interface{}, target string, obj interface{}) {
 			} else {
 				*boolval = false
 			}
+		case *float64:
+			floatval := obj
+			*floatval, _ = strconv.ParseFloat(target, 64)
+		case *[]interface{}:
+			arrval := obj
+			_ = json.Unmarshal([]byte(target), arrval)
 		default:
 			zap.S().Panic(obj)
 		}
 	}
 
 }
+
+// GetRawValue returns the raw value of the body by target and whether it exists,
+// the target is a constant string if it doesn't start with "$."
+func GetRawValue(body map[string]interface{}, target string) (interface{}, bool) {
+	if !strings.HasPrefix(target, "$.") {
+		return target, true
+	}
+	jsonString, _ := json.Marshal(body)
+	val := gjson.Get(string(jsonString), strings.TrimPrefix(target, "$."))
+	return val.Value(), val.Exists()
+}
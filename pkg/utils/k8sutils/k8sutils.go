@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -52,6 +53,8 @@ var (
 	workflowInformer        cache.SharedInformer
 	workflowRuntimeInformer cache.SharedInformer
 	functionInformer        cache.SharedInformer
+	// functionSyncTimeout is how long ListFunctionNames waits for the function informer
+	functionSyncTimeout = 10 * time.Second
 )
 var WithInjectData = func(objects *[]runtime.Object) {
 
@@ -202,6 +205,32 @@ func generateWorkflowObjectsByFile(fileName string, objects *[]runtime.Object) e
 	return err
 }
 
+// LoadWorkflowsFromFile returns the Workflows defined in a local file
+func LoadWorkflowsFromFile(fileName string) ([]*serverlessv1alpha1.Workflow, error) {
+	objects := []runtime.Object{}
+	if err := generateWorkflowObjectsByFile(fileName, &objects); err != nil && err != io.EOF {
+		return nil, err
+	}
+	workflows := make([]*serverlessv1alpha1.Workflow, 0, len(objects))
+	for _, obj := range objects {
+		workflows = append(workflows, obj.(*serverlessv1alpha1.Workflow))
+	}
+	return workflows, nil
+}
+
+// LoadFunctionsFromFile returns the Functions defined in a local file
+func LoadFunctionsFromFile(fileName string) ([]*serverlessv1alpha1.Function, error) {
+	objects := []runtime.Object{}
+	if err := generateFunctionObjectsByFile(fileName, &objects); err != nil && err != io.EOF {
+		return nil, err
+	}
+	functions := make([]*serverlessv1alpha1.Function, 0, len(objects))
+	for _, obj := range objects {
+		functions = append(functions, obj.(*serverlessv1alpha1.Function))
+	}
+	return functions, nil
+}
+
 // generateWorkflowRuntimeObjectsByFile generates a WorkflowRuntime object by file
 // this method is used when using the local environment, a parser for local files are needed
 func generateWorkflowRuntimeObjectsByFile(fileName string, objects *[]runtime.Object) error {
@@ -367,6 +396,26 @@ func GetWorkflowRuntimeByName(name string) (*serverlessv1alpha1.WorkflowRuntime,
 	return wfrt, true, nil
 }
 
+// ListFunctionNames returns the names of all Functions in the namespace,
+// it waits for the function informer synced at most functionSyncTimeout
+func ListFunctionNames() []string {
+	stop := make(chan struct{})
+	timer := time.AfterFunc(functionSyncTimeout, func() { close(stop) })
+	defer timer.Stop()
+	if !cache.WaitForCacheSync(stop, functionInformer.HasSynced) {
+		zap.S().Warn("function informer not synced")
+	}
+	names := []string{}
+	for _, key := range functionInformer.GetStore().ListKeys() {
+		_, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
 // GetFunctionByName returns a Function instance by the input name
 func GetFunctionByName(name string) (*serverlessv1alpha1.Function, bool, error) {
 	zap.S().Debugw("get function name", "name", name, "keys", functionInformer.GetStore().ListKeys())
@@ -0,0 +1,41 @@
+package resourceutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ParseCPU parses the cpu to millicores, it accepts the percentage of a core like "200%"
+// and the Kubernetes quantity like "2" or "500m", an empty cpu means nothing
+func ParseCPU(cpu string) (int64, error) {
+	if cpu == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(cpu, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(cpu, "%"), 64)
+		if err != nil || percent < 0 {
+			return 0, fmt.Errorf("invalid cpu %q", cpu)
+		}
+		return int64(percent * 10), nil
+	}
+	q, err := resource.ParseQuantity(cpu)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu %q: %v", cpu, err)
+	}
+	return q.MilliValue(), nil
+}
+
+// ParseMemory parses the Kubernetes quantity like "100Mi" to bytes, an empty memory means nothing
+func ParseMemory(memory string) (int64, error) {
+	if memory == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(memory)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory %q: %v", memory, err)
+	}
+	return q.Value(), nil
+}
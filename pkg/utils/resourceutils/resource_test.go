@@ -0,0 +1,35 @@
+package resourceutils
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+	Convey("test parsing the function resources", t, func() {
+		testcases := []struct {
+			cpu    string
+			expect int64
+		}{
+			{cpu: "", expect: 0},
+			{cpu: "200%", expect: 2000},
+			{cpu: "50%", expect: 500},
+			{cpu: "2", expect: 2000},
+			{cpu: "500m", expect: 500},
+		}
+		for _, testcase := range testcases {
+			cpu, err := ParseCPU(testcase.cpu)
+			So(err, ShouldBeNil)
+			So(cpu, ShouldEqual, testcase.expect)
+		}
+		_, err := ParseCPU("abc%")
+		So(err, ShouldNotBeNil)
+
+		memory, err := ParseMemory("100Mi")
+		So(err, ShouldBeNil)
+		So(memory, ShouldEqual, 100*1024*1024)
+		_, err = ParseMemory("100x")
+		So(err, ShouldNotBeNil)
+	})
+}
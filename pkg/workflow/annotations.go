@@ -0,0 +1,65 @@
+package workflow
+
+import (
+	"encoding/json"
+	"time"
+
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	"go.uber.org/zap"
+)
+
+// The Workflow CRD is defined in tass-operator, the scheduler specific settings
+// are declared as the annotations of the Workflow.
+// The Flow-level settings are JSON objects whose keys are the flow names.
+const (
+	// TimeoutAnnotation is the default execution timeout of the Workflow, e.g. "30s"
+	TimeoutAnnotation = "serverless.tass.io/timeout"
+	// RetryAnnotation declares the retry policies of Flows, e.g.
+	//   {"flow_a": {"maxAttempts": 3, "backoff": "100ms", "maxBackoff": "1s", "retryOn": ["function"]}}
+	RetryAnnotation = "serverless.tass.io/retry"
+	// CatchAnnotation declares the fallback Flows when Flows fail, e.g.
+	//   {"flow_a": {"next": "flow_a_fallback", "errorOn": ["function"]}}
+	CatchAnnotation = "serverless.tass.io/catch"
+	// JoinAnnotation declares the Flows which wait for all their parents, e.g.
+	//   {"flow_c": {}} or {"flow_c": {"parents": ["flow_a", "flow_b"]}}
+	JoinAnnotation = "serverless.tass.io/join"
+	// MapAnnotation declares the settings of the Map Flows, e.g.
+	//   {"flow_a": {"itemsPath": "$.items", "maxParallelism": 4}}
+	MapAnnotation = "serverless.tass.io/map"
+)
+
+// getTimeout returns the default execution timeout of the Workflow,
+// it returns 0 if the Workflow doesn't declare one or the value is invalid
+func getTimeout(wf *serverlessv1alpha1.Workflow) time.Duration {
+	raw, existed := wf.Annotations[TimeoutAnnotation]
+	if !existed {
+		return 0
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		zap.S().Warnw("invalid workflow timeout annotation", "workflow", wf.Name, "timeout", raw, "err", err)
+		return 0
+	}
+	return timeout
+}
+
+// getFlowAnnotation unmarshals the Flow setting in the annotation (param2) into obj,
+// it returns false if the annotation or the Flow setting doesn't exist
+func getFlowAnnotation(wf *serverlessv1alpha1.Workflow, key string, flowName string, obj interface{}) (bool, error) {
+	raw, existed := wf.Annotations[key]
+	if !existed {
+		return false, nil
+	}
+	settings := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+		return false, err
+	}
+	setting, existed := settings[flowName]
+	if !existed {
+		return false, nil
+	}
+	if err := json.Unmarshal(setting, obj); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+package workflow
+
+import (
+	"errors"
+
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+	"github.com/tass-io/scheduler/pkg/span"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	"go.uber.org/zap"
+)
+
+// the parameters keys of the fallback Flow
+const (
+	// CatchErrorKey holds the error details: flow, function, class and message
+	CatchErrorKey = "error"
+	// CatchInputKey holds the original parameters of the failed Flow
+	CatchInputKey = "input"
+)
+
+var ErrCatchSelf = errors.New("catch flow falls back to itself")
+
+// CatchPolicy is the error handling settings of a Flow,
+// when the Flow fails, the fallback Flow runs instead and the Workflow goes on from it
+type CatchPolicy struct {
+	// Next is the name of the fallback Flow
+	Next string `json:"next"`
+	// ErrorOn is the error classes to catch, all classes are caught if it's empty
+	ErrorOn []instance.ErrorClass `json:"errorOn,omitempty"`
+}
+
+// getCatchPolicy returns the catch policy of the Flow, it returns nil if the Flow has no catch policy
+func getCatchPolicy(wf *serverlessv1alpha1.Workflow, flowName string) (*CatchPolicy, error) {
+	policy := &CatchPolicy{}
+	existed, err := getFlowAnnotation(wf, CatchAnnotation, flowName, policy)
+	if err != nil || !existed {
+		return nil, err
+	}
+	if _, err := findFlowByName(wf, policy.Next); err != nil {
+		return nil, err
+	}
+	if policy.Next == flowName {
+		return nil, ErrCatchSelf
+	}
+	if err := validateErrorClasses(policy.ErrorOn); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// catch finishes the failed Flow span and runs the fallback Flow
+// if the failed Flow declares one and the error matches, otherwise it returns the original error.
+// The fallback Flow gets the error details and the original parameters.
+func (m *Manager) catch(sp *span.Span, parameters map[string]interface{},
+	wf *serverlessv1alpha1.Workflow, cause error) (map[string]interface{}, error) {
+
+	policy, err := getCatchPolicy(wf, sp.GetFlowName())
+	if err != nil {
+		zap.S().Errorw("get catch policy error", "flow", sp.GetFlowName(), "err", err)
+	}
+	if err != nil || policy == nil || !matchErrorClasses(policy.ErrorOn, cause) {
+		sp.Finish()
+		return nil, cause
+	}
+	zap.S().Infow("flow failed and goes to fallback", "flow", sp.GetFlowName(), "fallback", policy.Next, "err", cause)
+	sp.SetTag("catch.fallback", policy.Next)
+	// the failed Flow span is finished before the fallback Flow runs
+	sp.Finish()
+	// the join Flows don't wait for the failed Flow any more
+	if err := m.skip(sp, wf, []string{sp.GetFlowName()}); err != nil {
+		return nil, err
+	}
+
+	para := map[string]interface{}{
+		CatchErrorKey: map[string]interface{}{
+			"flow":     sp.GetFlowName(),
+			"function": sp.GetFunctionName(),
+			"class":    string(instance.ClassifyError(cause)),
+			"message":  cause.Error(),
+		},
+		CatchInputKey: parameters,
+	}
+	fallbackSp := span.NewSpanFromSpanSibling(sp)
+	fallbackSp.SetFlowName(policy.Next)
+	fallbackSp.Start(policy.Next)
+	return m.executeSpec(fallbackSp, para, wf)
+}
@@ -0,0 +1,227 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tass-io/scheduler/pkg/utils/common"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+)
+
+// the condition types beyond bool, string and int
+const (
+	Float  serverlessv1alpha1.CondType = "float"
+	Number serverlessv1alpha1.CondType = "number"
+)
+
+// the operators beyond the comparisons
+const (
+	// Exists is true if the target exists in the upstream response, the type is ignored
+	Exists serverlessv1alpha1.OperatorType = "exists"
+	// NotExists is true if the target doesn't exist in the upstream response, the type is ignored
+	NotExists serverlessv1alpha1.OperatorType = "notExists"
+	// In is true if the target is one of the comparison,
+	// the comparison is a JSON array like `["a", "b"]` or a path to an array
+	In serverlessv1alpha1.OperatorType = "in"
+	// Contains is true if the target array contains the comparison,
+	// or the target string contains the comparison substring
+	Contains serverlessv1alpha1.OperatorType = "contains"
+	// Matches is true if the target string matches the comparison regular expression
+	Matches serverlessv1alpha1.OperatorType = "matches"
+	// And is true if all the conditions are true,
+	// the comparison is the names of other conditions in the same Flow, like "cond_a,cond_b"
+	And serverlessv1alpha1.OperatorType = "and"
+	// Or is true if one of the conditions is true, the comparison is the same as And
+	Or serverlessv1alpha1.OperatorType = "or"
+	// Not is true if the condition is false, the comparison is the name of another condition
+	Not serverlessv1alpha1.OperatorType = "not"
+)
+
+var ErrInvalidCondition = errors.New("condition is invalid")
+
+// operators records the valid operators of each condition type
+var operators = map[serverlessv1alpha1.CondType][]serverlessv1alpha1.OperatorType{
+	serverlessv1alpha1.Bool: {serverlessv1alpha1.Eq, serverlessv1alpha1.Ne, In},
+	serverlessv1alpha1.String: {serverlessv1alpha1.Eq, serverlessv1alpha1.Ne, serverlessv1alpha1.Lt,
+		serverlessv1alpha1.Le, serverlessv1alpha1.Gt, serverlessv1alpha1.Ge, In, Contains, Matches},
+	serverlessv1alpha1.Int: {serverlessv1alpha1.Eq, serverlessv1alpha1.Ne, serverlessv1alpha1.Lt,
+		serverlessv1alpha1.Le, serverlessv1alpha1.Gt, serverlessv1alpha1.Ge, In, Contains},
+	Float: {serverlessv1alpha1.Eq, serverlessv1alpha1.Ne, serverlessv1alpha1.Lt,
+		serverlessv1alpha1.Le, serverlessv1alpha1.Gt, serverlessv1alpha1.Ge, In, Contains},
+	Number: {serverlessv1alpha1.Eq, serverlessv1alpha1.Ne, serverlessv1alpha1.Lt,
+		serverlessv1alpha1.Le, serverlessv1alpha1.Gt, serverlessv1alpha1.Ge, In, Contains},
+}
+
+// isCompoundOperator returns whether the operator composes other conditions
+func isCompoundOperator(op serverlessv1alpha1.OperatorType) bool {
+	return op == And || op == Or || op == Not
+}
+
+// subConditionNames returns the names of the conditions composed by a compound condition
+func subConditionNames(condition *serverlessv1alpha1.Condition) []string {
+	names := []string{}
+	for _, name := range strings.Split(condition.Comparison, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateCondition checks whether the type and the operator of the condition are valid,
+// for a compound condition, the composed conditions must exist in the Flow without cycles
+func validateCondition(condition *serverlessv1alpha1.Condition, flow *serverlessv1alpha1.Flow) error {
+	return validateConditionWithPath(condition, flow, map[string]bool{})
+}
+
+func validateConditionWithPath(condition *serverlessv1alpha1.Condition,
+	flow *serverlessv1alpha1.Flow, path map[string]bool) error {
+
+	if path[condition.Name] {
+		return fmt.Errorf("%w: condition %s composes itself", ErrInvalidCondition, condition.Name)
+	}
+	switch {
+	case condition.Operator == Exists || condition.Operator == NotExists:
+		return nil
+	case isCompoundOperator(condition.Operator):
+		names := subConditionNames(condition)
+		if len(names) == 0 || (condition.Operator == Not && len(names) != 1) {
+			return fmt.Errorf("%w: condition %s has invalid composed conditions %q",
+				ErrInvalidCondition, condition.Name, condition.Comparison)
+		}
+		path[condition.Name] = true
+		defer delete(path, condition.Name)
+		for _, name := range names {
+			sub := findConditionByName(name, flow)
+			if sub == nil {
+				return fmt.Errorf("%w: condition %s not found", ErrInvalidCondition, name)
+			}
+			if err := validateConditionWithPath(sub, flow, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ops, existed := operators[condition.Type]
+	if !existed {
+		return fmt.Errorf("%w: condition %s has unsupported type %q", ErrInvalidCondition, condition.Name, condition.Type)
+	}
+	for _, op := range ops {
+		if op == condition.Operator {
+			if op == Matches && !strings.HasPrefix(condition.Comparison, "$.") {
+				if _, err := regexp.Compile(condition.Comparison); err != nil {
+					return fmt.Errorf("%w: condition %s: %v", ErrInvalidCondition, condition.Name, err)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: condition %s has unsupported operator %q for type %q",
+		ErrInvalidCondition, condition.Name, condition.Operator, condition.Type)
+}
+
+// executeExtendedConditionLogic evaluates the conditions with the operators beyond the comparisons,
+// the condition should be validated before
+func executeExtendedConditionLogic(condition *serverlessv1alpha1.Condition,
+	flow *serverlessv1alpha1.Flow, functionResult map[string]interface{}) (bool, error) {
+
+	switch condition.Operator {
+	case Exists, NotExists:
+		_, existed := common.GetRawValue(functionResult, condition.Target)
+		return existed == (condition.Operator == Exists), nil
+	case And, Or, Not:
+		for _, name := range subConditionNames(condition) {
+			result, err := executeConditionLogic(findConditionByName(name, flow), flow, functionResult)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case condition.Operator == Not:
+				return !result, nil
+			case condition.Operator == And && !result:
+				return false, nil
+			case condition.Operator == Or && result:
+				return true, nil
+			}
+		}
+		return condition.Operator == And, nil
+	case In:
+		left, _ := common.GetRawValue(functionResult, condition.Target)
+		list, err := getList(functionResult, condition.Comparison)
+		if err != nil {
+			return false, err
+		}
+		return containsValue(list, left, condition.Type)
+	case Contains:
+		left, _ := common.GetRawValue(functionResult, condition.Target)
+		right, _ := common.GetRawValue(functionResult, condition.Comparison)
+		if list, ok := left.([]interface{}); ok {
+			return containsValue(list, right, condition.Type)
+		}
+		return strings.Contains(fmt.Sprint(left), fmt.Sprint(right)), nil
+	case Matches:
+		left, _ := common.GetRawValue(functionResult, condition.Target)
+		pattern, _ := common.GetRawValue(functionResult, condition.Comparison)
+		return regexp.MatchString(fmt.Sprint(pattern), fmt.Sprint(left))
+	}
+	return false, fmt.Errorf("%w: unsupported operator %q", ErrInvalidCondition, condition.Operator)
+}
+
+// getList returns the array of a JSON array constant or a path to an array
+func getList(functionResult map[string]interface{}, target string) ([]interface{}, error) {
+	var list []interface{}
+	if strings.HasPrefix(target, "$.") {
+		common.GetValue(functionResult, target, &list)
+		return list, nil
+	}
+	if err := json.Unmarshal([]byte(target), &list); err != nil {
+		return nil, fmt.Errorf("%w: %s is not a JSON array: %v", ErrInvalidCondition, target, err)
+	}
+	return list, nil
+}
+
+// containsValue returns whether the list has an element equal to the value after converting to the type
+func containsValue(list []interface{}, value interface{}, condType serverlessv1alpha1.CondType) (bool, error) {
+	v, err := convertValue(value, condType)
+	if err != nil {
+		return false, nil
+	}
+	for _, element := range list {
+		e, err := convertValue(element, condType)
+		if err != nil {
+			continue
+		}
+		if e == v {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// convertValue converts the raw JSON value to the comparable value of the type
+func convertValue(value interface{}, condType serverlessv1alpha1.CondType) (interface{}, error) {
+	switch condType {
+	case serverlessv1alpha1.String:
+		return fmt.Sprint(value), nil
+	case serverlessv1alpha1.Bool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+		return strconv.ParseBool(fmt.Sprint(value))
+	case serverlessv1alpha1.Int:
+		if v, ok := value.(float64); ok {
+			return int(v), nil
+		}
+		return strconv.Atoi(fmt.Sprint(value))
+	case Float, Number:
+		if v, ok := value.(float64); ok {
+			return v, nil
+		}
+		return strconv.ParseFloat(fmt.Sprint(value), 64)
+	}
+	return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidCondition, condType)
+}
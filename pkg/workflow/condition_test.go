@@ -0,0 +1,77 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+)
+
+func TestExecuteConditionLogic(t *testing.T) {
+	newCondition := func(name string, condType serverlessv1alpha1.CondType, op serverlessv1alpha1.OperatorType,
+		target string, comparison string) *serverlessv1alpha1.Condition {
+
+		return &serverlessv1alpha1.Condition{
+			Name:       name,
+			Type:       condType,
+			Operator:   op,
+			Target:     target,
+			Comparison: comparison,
+		}
+	}
+	flow := &serverlessv1alpha1.Flow{
+		Name: "conditions",
+		Conditions: []*serverlessv1alpha1.Condition{
+			newCondition("high_score", Float, serverlessv1alpha1.Gt, "$.score", "0.8"),
+			newCondition("vip", serverlessv1alpha1.String, In, "$.level", `["gold", "platinum"]`),
+			newCondition("has_coupon", "", Exists, "$.coupon", ""),
+			newCondition("high_or_vip", "", Or, "", "high_score,vip"),
+			newCondition("both", "", And, "", "high_or_vip,has_coupon"),
+			newCondition("not_both", "", Not, "", "both"),
+			newCondition("self", "", And, "", "self"),
+		},
+	}
+	result := map[string]interface{}{
+		"score":  0.5,
+		"level":  "gold",
+		"tags":   []interface{}{"new", "mobile"},
+		"email":  "someone@tass.io",
+		"coupon": "abc",
+	}
+
+	testcases := []struct {
+		caseName  string
+		condition *serverlessv1alpha1.Condition
+		expect    bool
+		expectErr error
+	}{
+		{"float gt", flow.Conditions[0], false, nil},
+		{"number le", newCondition("n", Number, serverlessv1alpha1.Le, "$.score", "0.5"), true, nil},
+		{"string in", flow.Conditions[1], true, nil},
+		{"int in", newCondition("i", serverlessv1alpha1.Int, In, "$.missing", "[1, 2]"), false, nil},
+		{"exists", flow.Conditions[2], true, nil},
+		{"not exists", newCondition("ne", "", NotExists, "$.coupon", ""), false, nil},
+		{"array contains", newCondition("c", serverlessv1alpha1.String, Contains, "$.tags", "mobile"), true, nil},
+		{"string contains", newCondition("c", serverlessv1alpha1.String, Contains, "$.email", "@tass"), true, nil},
+		{"matches", newCondition("m", serverlessv1alpha1.String, Matches, "$.email", `^\w+@tass\.io$`), true, nil},
+		{"or", flow.Conditions[3], true, nil},
+		{"and", flow.Conditions[4], true, nil},
+		{"not", flow.Conditions[5], false, nil},
+		{"composition cycle", flow.Conditions[6], false, ErrInvalidCondition},
+		{"unsupported type", newCondition("u", "date", serverlessv1alpha1.Eq, "$.score", "1"), false, ErrInvalidCondition},
+		{"unsupported operator", newCondition("u", serverlessv1alpha1.Bool, Matches, "$.score", "1"), false, ErrInvalidCondition},
+		{"invalid regex", newCondition("r", serverlessv1alpha1.String, Matches, "$.email", "("), false, ErrInvalidCondition},
+	}
+	for _, testcase := range testcases {
+		Convey(testcase.caseName, t, func() {
+			ok, err := executeConditionLogic(testcase.condition, flow, result)
+			if testcase.expectErr != nil {
+				So(errors.Is(err, testcase.expectErr), ShouldBeTrue)
+				return
+			}
+			So(err, ShouldBeNil)
+			So(ok, ShouldEqual, testcase.expect)
+		})
+	}
+}
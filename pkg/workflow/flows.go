@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/avast/retry-go"
+	"github.com/tass-io/scheduler/pkg/execution"
 	"github.com/tass-io/scheduler/pkg/middleware"
 	"github.com/tass-io/scheduler/pkg/span"
 	"github.com/tass-io/scheduler/pkg/utils/common"
@@ -19,6 +21,9 @@ var (
 	ErrNoStartFound     = errors.New("no start found")
 	ErrInvalidStatement = errors.New("statement is invalid")
 	ErrFlowNotFound     = errors.New("flow not found")
+	// errJoinedElsewhere is returned by a branch whose results go to join Flows,
+	// the join Flow results are merged into the execution result so the branch has no result of its own
+	errJoinedElsewhere = errors.New("joined elsewhere")
 )
 
 // parallelConditions just handles Flow.Outputs and Condition.Flows, they do the same logic
@@ -28,6 +33,9 @@ func (m *Manager) parallelConditions(sp *span.Span, para map[string]interface{},
 	if len(nexts) == 0 {
 		return nil, nil
 	}
+	// the pending branches are cancelled as soon as one of them fails
+	group, ctx := newBranchGroup(sp.GetContext())
+	defer group.cancel()
 	promises := []*CondPromise{}
 	flow := wf.Spec.Spec[target]
 	for _, next := range nexts {
@@ -35,8 +43,9 @@ func (m *Manager) parallelConditions(sp *span.Span, para map[string]interface{},
 		// however, next will finaly do the m.executeCondition
 		// so this newSp should not be the next sp
 		newSp := span.NewSpan(sp.GetFlowName(), sp.GetUpstreamFlowName(), sp.GetFlowName(), sp.GetFunctionName())
+		newSp.SetContext(ctx)
 		cond := findConditionByName(next, &flow)
-		p := NewCondPromise(m.executeCondition, next)
+		p := NewCondPromise(group.condition(m.executeCondition), next)
 		zap.S().Debugw("call condition with parameter", "flow", newSp.GetFlowName(), "parameters", para, "target", target)
 		newSp.Start(next)
 		p.Run(newSp, cond, wf, target, para)
@@ -48,9 +57,12 @@ func (m *Manager) parallelConditions(sp *span.Span, para map[string]interface{},
 	for _, p := range promises {
 		resp, err := p.GetResult()
 		zap.S().Debugw("get resp with function", "function", p.name, "resp", resp)
+		if err == errJoinedElsewhere {
+			continue
+		}
 		if err != nil {
 			zap.S().Errorw("get error from condition promise", "err", err)
-			return nil, err
+			return nil, group.firstErr(err)
 		}
 		if resp != nil {
 			finalResult[p.name] = resp
@@ -59,41 +71,142 @@ func (m *Manager) parallelConditions(sp *span.Span, para map[string]interface{},
 	return finalResult, nil
 }
 
+// flowRun is a Flow to execute and its parameters
+type flowRun struct {
+	index int
+	para  map[string]interface{}
+	// join is true if the Flow is a join Flow, its result is recorded in the join table
+	join bool
+}
+
 // parallelFlowsWithSpan executes Flows in parallel,
-// it's called when a Flow finishes execution and goes to the next Flows in parallel
+// it's called when a Flow finishes execution and goes to the next Flows in parallel.
+// It returns errJoinedElsewhere if all the next Flows are join Flows.
 func (m *Manager) parallelFlowsWithSpan(sp *span.Span, para map[string]interface{},
 	wf *serverlessv1alpha1.Workflow, nexts []int) (map[string]interface{}, error) {
 
 	if len(nexts) == 0 {
 		return nil, nil
 	}
-	promises := []*FlowPromise{}
+	runs := make([]flowRun, 0, len(nexts))
+	joined := false
 	for _, next := range nexts {
+		nextPara, join, ready, err := m.join(sp, para, wf, next)
+		if err != nil {
+			return nil, err
+		}
+		joined = joined || join
+		if !ready {
+			// the join Flow is executed by the last arrived parent
+			continue
+		}
+		runs = append(runs, flowRun{index: next, para: nextPara, join: join})
+	}
+	finalResult, err := m.runFlows(sp, wf, runs)
+	if err == errJoinedElsewhere || (err == nil && len(finalResult) == 0 && joined) {
+		return nil, errJoinedElsewhere
+	}
+	return finalResult, err
+}
+
+// runFlows executes the Flows in parallel and returns their results keyed by the flow names,
+// the results of the join Flows are recorded in the join table instead.
+// It returns errJoinedElsewhere if all the results go to join Flows.
+func (m *Manager) runFlows(sp *span.Span, wf *serverlessv1alpha1.Workflow,
+	runs []flowRun) (map[string]interface{}, error) {
+
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	// the pending branches are cancelled as soon as one of them fails
+	group, ctx := newBranchGroup(sp.GetContext())
+	defer group.cancel()
+	promises := []*FlowPromise{}
+	for _, run := range runs {
 		newSp := span.NewSpanFromSpanSibling(sp)
-		newSp.SetFlowName(wf.Spec.Spec[next].Name)
-		p := NewFlowPromise(m.executeSpec, newSp.GetFlowName())
-		zap.S().Debugw("call function with parameter", "flow", newSp.GetFlowName(), "parameters", para)
+		newSp.SetContext(ctx)
+		newSp.SetFlowName(wf.Spec.Spec[run.index].Name)
+		p := NewFlowPromise(group.flow(m.executeSpec), newSp.GetFlowName())
+		zap.S().Debugw("call function with parameter", "flow", newSp.GetFlowName(), "parameters", run.para)
 		newSp.Start(newSp.GetFlowName())
-		p.Run(para, wf, newSp)
+		p.Run(run.para, wf, newSp)
 		promises = append(promises, p)
 	}
 
 	finalResult := make(map[string]interface{}, len(promises))
-
-	for _, p := range promises {
+	joined := false
+	for i, p := range promises {
 		resp, err := p.GetResult()
 		zap.S().Debugw("get resp with function", "function", p.name, "resp", resp)
+		if err == errJoinedElsewhere {
+			joined = true
+			continue
+		}
 		if err != nil {
 			zap.S().Errorw("get error from function promise", "err", err)
-			return nil, err
+			return nil, group.firstErr(err)
+		}
+		if runs[i].join {
+			getJoinTable(sp.GetContext()).finish(p.name, resp)
+			joined = true
+			continue
 		}
 		if resp != nil {
 			finalResult[p.name] = resp
 		}
 	}
+	if len(finalResult) == 0 && joined {
+		return nil, errJoinedElsewhere
+	}
 	return finalResult, nil
 }
 
+// join returns the parameters of the next Flow, whether it's a join Flow and whether it's ready to execute,
+// a normal Flow is always ready, a join Flow is ready when all its parents have arrived or been skipped
+func (m *Manager) join(sp *span.Span, para map[string]interface{},
+	wf *serverlessv1alpha1.Workflow, next int) (map[string]interface{}, bool, bool, error) {
+
+	flowName := wf.Spec.Spec[next].Name
+	policy, err := getJoinPolicy(wf, flowName)
+	if err != nil {
+		zap.S().Errorw("get join policy error", "flow", flowName, "err", err)
+		return nil, false, false, err
+	}
+	if policy == nil {
+		return para, false, true, nil
+	}
+	table := getJoinTable(sp.GetContext())
+	if table == nil {
+		zap.S().Warnw("no join table in the context, execute without join", "flow", flowName)
+		return para, false, true, nil
+	}
+	merged, ready := table.arrive(flowName, policy.Parents, sp.GetFlowName(), para)
+	zap.S().Debugw("join flow arrived", "flow", flowName, "upstream", sp.GetFlowName(), "ready", ready)
+	return merged, true, ready, nil
+}
+
+// skip marks the flows (param3) not executed in this execution,
+// the join Flows which become ready because of them are executed here
+func (m *Manager) skip(sp *span.Span, wf *serverlessv1alpha1.Workflow, flows []string) error {
+	table := getJoinTable(sp.GetContext())
+	if table == nil || len(flows) == 0 {
+		return nil
+	}
+	ready := table.skip(wf, flows)
+	runs := make([]flowRun, 0, len(ready))
+	for name, para := range ready {
+		i, err := findFlowByName(wf, name)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, flowRun{index: i, para: para, join: true})
+	}
+	if _, err := m.runFlows(sp, wf, runs); err != nil && err != errJoinedElsewhere {
+		return err
+	}
+	return nil
+}
+
 // parallelFlows is an encapsulation of parallelFlowsWithSpan
 func (m *Manager) parallelFlows(sp *span.Span, para map[string]interface{},
 	wf *serverlessv1alpha1.Workflow, nexts []int) (map[string]interface{}, error) {
@@ -111,6 +224,13 @@ func (m *Manager) executeSpec(sp *span.Span, parameters map[string]interface{},
 	// 	return nil, err
 	// }
 
+	// stop here if the request has been cancelled or the deadline exceeds
+	if err := sp.GetContext().Err(); err != nil {
+		zap.S().Warnw("executeSpec context done", "flow", sp.GetFlowName(), "err", err)
+		sp.Finish()
+		return nil, err
+	}
+
 	var targetFlowIndex int
 	var err error
 	if sp.GetFunctionName() == "" {
@@ -131,11 +251,19 @@ func (m *Manager) executeSpec(sp *span.Span, parameters map[string]interface{},
 	// execute the function and get results
 	// enter in rootspan if not from promise
 	// FIXME: targetFlowIndex now is redundant here
-	result, err := m.executeRunFunction(sp, parameters, wf, targetFlowIndex)
+	recorder := execution.GetRecorder(sp.GetContext())
+	record := recorder.FlowStart(sp.GetFlowName(), sp.GetFunctionName())
+	var result map[string]interface{}
+	if wf.Spec.Spec[targetFlowIndex].Statement == Map {
+		result, err = m.executeMap(sp, parameters, wf, targetFlowIndex)
+	} else {
+		result, err = m.executeRunFunctionWithRetry(sp, parameters, wf, targetFlowIndex)
+	}
+	recorder.FlowEnd(record, err)
 	zap.S().Debugw("executeRunFunction", "result", result)
 	if err != nil {
 		zap.S().Errorw("executeRunFunction error", "err", err)
-		return nil, err
+		return m.catch(sp, parameters, wf, err)
 	}
 	// find next Flows after the execution
 	// pay attention !!! here may change result
@@ -161,6 +289,35 @@ func (m *Manager) executeSpec(sp *span.Span, parameters map[string]interface{},
 	return finalResult, err
 }
 
+// executeRunFunctionWithRetry runs function with the retry policy of the Flow,
+// the number of attempts is recorded in the flow span
+func (m *Manager) executeRunFunctionWithRetry(sp *span.Span, parameters map[string]interface{},
+	wf *serverlessv1alpha1.Workflow, targetFlowIndex int) (map[string]interface{}, error) {
+
+	policy, err := getRetryPolicy(wf, sp.GetFlowName())
+	if err != nil {
+		zap.S().Errorw("get retry policy error", "flow", sp.GetFlowName(), "err", err)
+		return nil, err
+	}
+	if policy == nil {
+		return m.executeRunFunction(sp, parameters, wf, targetFlowIndex)
+	}
+
+	var result map[string]interface{}
+	var attempts uint
+	err = retry.Do(func() error {
+		attempts++
+		var err error
+		result, err = m.executeRunFunction(sp, parameters, wf, targetFlowIndex)
+		return err
+	}, policy.options(sp)...)
+	sp.SetTag("retry.attempts", attempts)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // executeRunFunction runs function without other workflow logic, middlewares are injected here.
 func (m *Manager) executeRunFunction(sp *span.Span, parameters map[string]interface{},
 	wf *serverlessv1alpha1.Workflow, _ int) (map[string]interface{}, error) {
@@ -170,7 +327,6 @@ func (m *Manager) executeRunFunction(sp *span.Span, parameters map[string]interf
 	midResult, decision, err := m.middleware(middlewareSpan, parameters)
 	middlewareSpan.Finish()
 	if err != nil {
-		sp.Finish()
 		return nil, err
 	}
 	zap.S().Infow("get middleware result", "result", midResult)
@@ -221,7 +377,7 @@ func (m *Manager) findNext(sp *span.Span,
 	now := wf.Spec.Spec[target]
 	var err error
 	switch now.Statement {
-	case serverlessv1alpha1.Direct:
+	case serverlessv1alpha1.Direct, Map:
 		nexts := make([]int, 0, len(now.Outputs))
 		for _, name := range now.Outputs {
 			n, err := findFlowByName(wf, name)
@@ -263,7 +419,13 @@ func (m *Manager) findNext(sp *span.Span,
 func (m *Manager) executeCondition(sp *span.Span, condition *serverlessv1alpha1.Condition,
 	wf *serverlessv1alpha1.Workflow, target int, functionResult map[string]interface{}) (map[string]interface{}, error) {
 
-	branchRes := executeConditionLogic(condition, functionResult)
+	flow := wf.Spec.Spec[target]
+	branchRes, err := executeConditionLogic(condition, &flow, functionResult)
+	if err != nil {
+		zap.S().Errorw("execute condition logic error", "condition", condition.Name, "err", err)
+		return nil, err
+	}
+	execution.GetRecorder(sp.GetContext()).Branch(flow.Name, condition.Name, branchRes)
 	var next *serverlessv1alpha1.Next
 	if branchRes {
 		next = &condition.Destination.IsTrue
@@ -271,6 +433,17 @@ func (m *Manager) executeCondition(sp *span.Span, condition *serverlessv1alpha1.
 		next = &condition.Destination.IsFalse
 	}
 
+	// the flows only in the untaken branch are never executed in this execution
+	var untaken *serverlessv1alpha1.Next
+	if branchRes {
+		untaken = &condition.Destination.IsFalse
+	} else {
+		untaken = &condition.Destination.IsTrue
+	}
+	if err := m.skip(sp, wf, exclude(destinationFlows(&flow, untaken), destinationFlows(&flow, next))); err != nil {
+		return nil, err
+	}
+
 	// parallel execute flows and conditions
 	// FIXME: Now not parallel execution
 	mergedResult := map[string]interface{}{}
@@ -292,6 +465,9 @@ func (m *Manager) executeCondition(sp *span.Span, condition *serverlessv1alpha1.
 	}
 	zap.S().Debugw("after conditions flows", "flows", flowsNum)
 	flowsResult, err := m.parallelFlows(sp, functionResult, wf, flowsNum)
+	if err == errJoinedElsewhere {
+		flowsResult, err = nil, nil
+	}
 	if err != nil {
 		zap.S().Errorw("error at parallel flow", "err", err)
 	} else if flowsResult != nil {
@@ -316,7 +492,21 @@ func (m *Manager) executeCondition(sp *span.Span, condition *serverlessv1alpha1.
 // we can see that the type is `int`, the comparison is converted to 5 as int,
 // the target is converted to an "int" by reading the upstream response.
 // Then it starts the "compare" and returns the result.
-func executeConditionLogic(condition *serverlessv1alpha1.Condition, functionResult map[string]interface{}) bool {
+//
+// The operators beyond the comparisons, like `exists`, `in` and `and`, are handled by executeExtendedConditionLogic.
+// It returns an ErrInvalidCondition error if the type and the operator are not supported.
+func executeConditionLogic(condition *serverlessv1alpha1.Condition,
+	flow *serverlessv1alpha1.Flow, functionResult map[string]interface{}) (bool, error) {
+
+	if err := validateCondition(condition, flow); err != nil {
+		return false, err
+	}
+	switch condition.Operator {
+	case serverlessv1alpha1.Eq, serverlessv1alpha1.Ne, serverlessv1alpha1.Lt,
+		serverlessv1alpha1.Le, serverlessv1alpha1.Gt, serverlessv1alpha1.Ge:
+	default:
+		return executeExtendedConditionLogic(condition, flow, functionResult)
+	}
 
 	var leftValue interface{}
 	var rightValue interface{}
@@ -330,6 +520,9 @@ func executeConditionLogic(condition *serverlessv1alpha1.Condition, functionResu
 	case serverlessv1alpha1.Int:
 		leftValue = new(int)
 		rightValue = new(int)
+	case Float, Number:
+		leftValue = new(float64)
+		rightValue = new(float64)
 	}
 
 	// get the real values
@@ -340,28 +533,34 @@ func executeConditionLogic(condition *serverlessv1alpha1.Condition, functionResu
 	return compare(leftValue, rightValue, condition.Operator)
 }
 
-// compare compares different type values and ops
-func compare(left interface{}, right interface{}, op serverlessv1alpha1.OperatorType) bool {
+// compare compares different type values and ops,
+// it returns an ErrInvalidCondition error if the type is not supported
+func compare(left interface{}, right interface{}, op serverlessv1alpha1.OperatorType) (bool, error) {
 	switch left := left.(type) {
 	case *int:
 		right := right.(*int)
 		result := compareInt(*left, *right, op)
 		zap.S().Debugw("get compareInt result at compare", "result", result)
-		return result
+		return result, nil
+	case *float64:
+		right := right.(*float64)
+		result := compareFloat(*left, *right, op)
+		zap.S().Debugw("get compareFloat result at compare", "result", result)
+		return result, nil
 	case *string:
 		right := right.(*string)
 		result := compareString(*left, *right, op)
 		zap.S().Debugw("get compareString result at compare", "result", result)
-		return result
+		return result, nil
 	case *bool:
 		right := right.(*bool)
 		result := compareBool(*left, *right, op)
 		zap.S().Debugw("get compareBool result at compare", "result", result)
-		return result
+		return result, nil
 	default:
-		zap.S().Panic(op)
+		zap.S().Errorw("unsupported condition type", "op", op, "left", left)
+		return false, ErrInvalidCondition
 	}
-	return false
 }
 
 // compareInt is a helper function for int comparaion,
@@ -388,6 +587,28 @@ func compareInt(left int, right int, op serverlessv1alpha1.OperatorType) bool {
 	}
 }
 
+// compareFloat is a helper function for float comparaion,
+// it converts the OperatorType to real float comparaion
+func compareFloat(left float64, right float64, op serverlessv1alpha1.OperatorType) bool {
+	switch op {
+	case serverlessv1alpha1.Eq:
+		return left == right
+	case serverlessv1alpha1.Ne:
+		return left != right
+	case serverlessv1alpha1.Lt:
+		return left < right
+	case serverlessv1alpha1.Le:
+		return left <= right
+	case serverlessv1alpha1.Gt:
+		return left > right
+	case serverlessv1alpha1.Ge:
+		return left >= right
+	default:
+		zap.S().Warnw("invalid operator, return false instead", "op", op)
+		return false
+	}
+}
+
 // compareString is a helper function for string comparaion,
 // it converts the OperatorType to real string comparaion
 func compareString(left string, right string, op serverlessv1alpha1.OperatorType) bool {
@@ -0,0 +1,237 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tass-io/scheduler/pkg/predictmodel"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+)
+
+// GraphFormat is the output format of the Workflow graph
+type GraphFormat string
+
+const (
+	DOT     GraphFormat = "dot"
+	Mermaid GraphFormat = "mermaid"
+)
+
+var ErrUnknownGraphFormat = errors.New("unknown graph format")
+
+// graphNode is a Flow or a condition in the Workflow graph
+type graphNode struct {
+	id          string
+	label       []string
+	role        serverlessv1alpha1.Role
+	isCondition bool
+}
+
+// graphEdge is a Direct output, a condition destination or a fallback in the Workflow graph
+type graphEdge struct {
+	from   string
+	to     string
+	label  string
+	dashed bool
+}
+
+// RenderGraph renders the Workflow as a Graphviz DOT or a Mermaid flowchart.
+// The graph includes the Direct edges, the Switch conditions and the Start/End roles,
+// if the model is not nil, the flow probabilities and the average exec/coldstart time are overlaid.
+func RenderGraph(wf *serverlessv1alpha1.Workflow, format GraphFormat, model *predictmodel.Model) (string, error) {
+	nodes, edges := buildGraph(wf, model)
+	switch format {
+	case DOT:
+		return renderDOT(wf.Name, nodes, edges), nil
+	case Mermaid:
+		return renderMermaid(nodes, edges), nil
+	default:
+		return "", ErrUnknownGraphFormat
+	}
+}
+
+// buildGraph converts the Workflow to nodes and edges, the ids of Flows are "f<index>",
+// the ids of conditions are "f<index>c<index>"
+func buildGraph(wf *serverlessv1alpha1.Workflow, model *predictmodel.Model) ([]*graphNode, []*graphEdge) {
+	flowIDs := make(map[string]string, len(wf.Spec.Spec))
+	for i, flow := range wf.Spec.Spec {
+		flowIDs[flow.Name] = fmt.Sprintf("f%d", i)
+	}
+	nodes := []*graphNode{}
+	edges := []*graphEdge{}
+	// probabilityLabel returns the probability label of the edge from the flow to the next flow
+	probabilityLabel := func(from string, to string) string {
+		if model == nil || model.Flows[to] == nil {
+			return ""
+		}
+		for _, path := range model.Flows[to].Paths {
+			if path.From == from {
+				return fmt.Sprintf("p=%.2f", path.Probability)
+			}
+		}
+		return ""
+	}
+	joinLabels := func(labels ...string) string {
+		nonEmpty := []string{}
+		for _, label := range labels {
+			if label != "" {
+				nonEmpty = append(nonEmpty, label)
+			}
+		}
+		return strings.Join(nonEmpty, " ")
+	}
+	flowEdge := func(from *serverlessv1alpha1.Flow, fromID string, to string, label string) {
+		toID, existed := flowIDs[to]
+		if !existed {
+			return
+		}
+		edges = append(edges, &graphEdge{from: fromID, to: toID, label: joinLabels(label, probabilityLabel(from.Name, to))})
+	}
+
+	for i := range wf.Spec.Spec {
+		flow := &wf.Spec.Spec[i]
+		id := flowIDs[flow.Name]
+		node := &graphNode{id: id, role: flow.Role, label: []string{flow.Name, "fn: " + flow.Function}}
+		if flow.Statement == Map {
+			if policy, err := getMapPolicy(wf, flow.Name); err == nil {
+				node.label = append(node.label, "map: "+policy.ItemsPath)
+			}
+		}
+		if model != nil && model.Flows[flow.Name] != nil {
+			obj := model.Flows[flow.Name]
+			node.label = append(node.label, fmt.Sprintf("p=%.2f exec=%v coldstart=%v",
+				obj.Probability, obj.AvgExec, obj.AvgColdStart))
+		}
+		nodes = append(nodes, node)
+
+		for _, next := range flow.Outputs {
+			flowEdge(flow, id, next, "")
+		}
+		conditionIDs := make(map[string]string, len(flow.Conditions))
+		for j, condition := range flow.Conditions {
+			conditionIDs[condition.Name] = fmt.Sprintf("%sc%d", id, j)
+		}
+		for _, condition := range flow.Conditions {
+			cid := conditionIDs[condition.Name]
+			nodes = append(nodes, &graphNode{id: cid, isCondition: true, label: []string{conditionLabel(condition)}})
+			if condition.Name == RootCondition {
+				edges = append(edges, &graphEdge{from: id, to: cid})
+			}
+			for _, dest := range []struct {
+				next  serverlessv1alpha1.Next
+				label string
+			}{{condition.Destination.IsTrue, "true"}, {condition.Destination.IsFalse, "false"}} {
+				for _, name := range dest.next.Flows {
+					flowEdge(flow, cid, name, dest.label)
+				}
+				for _, name := range dest.next.Conditions {
+					if nextID, existed := conditionIDs[name]; existed {
+						edges = append(edges, &graphEdge{from: cid, to: nextID, label: dest.label})
+					}
+				}
+			}
+		}
+		if policy, err := getCatchPolicy(wf, flow.Name); err == nil && policy != nil {
+			edges = append(edges, &graphEdge{from: id, to: flowIDs[policy.Next], label: "catch", dashed: true})
+		}
+	}
+	return nodes, edges
+}
+
+// conditionLabel returns the label of a condition, e.g. "root: $.a gt 5"
+func conditionLabel(condition *serverlessv1alpha1.Condition) string {
+	switch {
+	case condition.Operator == Exists || condition.Operator == NotExists:
+		return fmt.Sprintf("%s: %s %s", condition.Name, condition.Operator, condition.Target)
+	case isCompoundOperator(condition.Operator):
+		return fmt.Sprintf("%s: %s(%s)", condition.Name, condition.Operator, condition.Comparison)
+	default:
+		return fmt.Sprintf("%s: %s %s %s (%s)",
+			condition.Name, condition.Target, condition.Operator, condition.Comparison, condition.Type)
+	}
+}
+
+// renderDOT renders the graph in Graphviz DOT,
+// the start Flows are bold, the end Flows have double borders and the conditions are diamonds
+func renderDOT(name string, nodes []*graphNode, edges []*graphEdge) string {
+	escape := func(s string) string {
+		return strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`)
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "digraph \"%s\" {\n", escape(name))
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range nodes {
+		labels := make([]string, 0, len(node.label))
+		for _, label := range node.label {
+			labels = append(labels, escape(label))
+		}
+		attrs := []string{fmt.Sprintf("label=\"%s\"", strings.Join(labels, `\n`))}
+		if node.isCondition {
+			attrs = append(attrs, "shape=diamond")
+		} else {
+			attrs = append(attrs, "shape=box")
+		}
+		if node.role == serverlessv1alpha1.Start || node.role == serverlessv1alpha1.Orphan {
+			attrs = append(attrs, "style=bold")
+		}
+		if node.role == serverlessv1alpha1.End || node.role == serverlessv1alpha1.Orphan {
+			attrs = append(attrs, "peripheries=2")
+		}
+		fmt.Fprintf(b, "  %s [%s];\n", node.id, strings.Join(attrs, ", "))
+	}
+	for _, edge := range edges {
+		attrs := []string{}
+		if edge.label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=\"%s\"", escape(edge.label)))
+		}
+		if edge.dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+		if len(attrs) == 0 {
+			fmt.Fprintf(b, "  %s -> %s;\n", edge.from, edge.to)
+		} else {
+			fmt.Fprintf(b, "  %s -> %s [%s];\n", edge.from, edge.to, strings.Join(attrs, ", "))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderMermaid renders the graph in a Mermaid flowchart,
+// the start Flows are stadiums, the end Flows are subroutines and the conditions are rhombuses
+func renderMermaid(nodes []*graphNode, edges []*graphEdge) string {
+	escape := func(s string) string {
+		return strings.ReplaceAll(s, `"`, "#quot;")
+	}
+	b := &strings.Builder{}
+	b.WriteString("flowchart LR\n")
+	for _, node := range nodes {
+		labels := make([]string, 0, len(node.label))
+		for _, label := range node.label {
+			labels = append(labels, escape(label))
+		}
+		label := "\"" + strings.Join(labels, "<br/>") + "\""
+		switch {
+		case node.isCondition:
+			fmt.Fprintf(b, "  %s{%s}\n", node.id, label)
+		case node.role == serverlessv1alpha1.Start || node.role == serverlessv1alpha1.Orphan:
+			fmt.Fprintf(b, "  %s([%s])\n", node.id, label)
+		case node.role == serverlessv1alpha1.End:
+			fmt.Fprintf(b, "  %s[[%s]]\n", node.id, label)
+		default:
+			fmt.Fprintf(b, "  %s[%s]\n", node.id, label)
+		}
+	}
+	for _, edge := range edges {
+		arrow := "-->"
+		if edge.dashed {
+			arrow = "-.->"
+		}
+		if edge.label == "" {
+			fmt.Fprintf(b, "  %s %s %s\n", edge.from, arrow, edge.to)
+		} else {
+			fmt.Fprintf(b, "  %s %s|\"%s\"| %s\n", edge.from, arrow, escape(edge.label), edge.to)
+		}
+	}
+	return b.String()
+}
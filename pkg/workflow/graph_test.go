@@ -0,0 +1,81 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tass-io/scheduler/pkg/predictmodel"
+	"github.com/tass-io/scheduler/pkg/predictmodel/store"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderGraph(t *testing.T) {
+	wf := &serverlessv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "graph"},
+		Spec: serverlessv1alpha1.WorkflowSpec{
+			Spec: []serverlessv1alpha1.Flow{
+				{
+					Name:      "start",
+					Function:  "fn_start",
+					Statement: serverlessv1alpha1.Switch,
+					Role:      serverlessv1alpha1.Start,
+					Conditions: []*serverlessv1alpha1.Condition{
+						{
+							Name:       "root",
+							Type:       serverlessv1alpha1.Int,
+							Operator:   serverlessv1alpha1.Gt,
+							Target:     "$.a",
+							Comparison: "5",
+							Destination: serverlessv1alpha1.Destination{
+								IsTrue:  serverlessv1alpha1.Next{Flows: []string{"end"}},
+								IsFalse: serverlessv1alpha1.Next{},
+							},
+						},
+					},
+				},
+				{
+					Name:      "end",
+					Function:  "fn_end",
+					Statement: serverlessv1alpha1.Direct,
+					Role:      serverlessv1alpha1.End,
+				},
+			},
+		},
+	}
+	model := &predictmodel.Model{
+		Start: "start",
+		Flows: map[string]*store.Object{
+			"end": {
+				Flow:        "end",
+				AvgExec:     10 * time.Millisecond,
+				Probability: 0.5,
+				Paths:       []store.Path{{From: "start", Probability: 0.5}},
+			},
+		},
+	}
+
+	Convey("render the workflow as dot", t, func() {
+		graph, err := RenderGraph(wf, DOT, nil)
+		So(err, ShouldBeNil)
+		So(graph, ShouldContainSubstring, `f0 [label="start\nfn: fn_start", shape=box, style=bold];`)
+		So(graph, ShouldContainSubstring, `f0c0 [label="root: $.a gt 5 (int)", shape=diamond];`)
+		So(graph, ShouldContainSubstring, `f0 -> f0c0;`)
+		So(graph, ShouldContainSubstring, `f0c0 -> f1 [label="true"];`)
+		So(graph, ShouldContainSubstring, `peripheries=2`)
+	})
+
+	Convey("render the workflow as mermaid with the model overlay", t, func() {
+		graph, err := RenderGraph(wf, Mermaid, model)
+		So(err, ShouldBeNil)
+		So(graph, ShouldStartWith, "flowchart LR\n")
+		So(graph, ShouldContainSubstring, `f1[["end<br/>fn: fn_end<br/>p=0.50 exec=10ms coldstart=0s"]]`)
+		So(graph, ShouldContainSubstring, `f0c0 -->|"true p=0.50"| f1`)
+	})
+
+	Convey("unknown format", t, func() {
+		_, err := RenderGraph(wf, "svg", nil)
+		So(err, ShouldEqual, ErrUnknownGraphFormat)
+	})
+}
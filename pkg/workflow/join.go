@@ -0,0 +1,223 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+)
+
+// JoinPolicy is the fan-in settings of a Flow which has several parents.
+// A join Flow waits for all its parents in one execution and then runs once,
+// its parameters are the parents results keyed by the parent flow names.
+// Note that all parents should be executed in the same scheduler.
+type JoinPolicy struct {
+	// Parents is the flows to wait for, it's all the upstream flows in the Workflow by default.
+	// The parents in the untaken switch branches and the failed parents caught by fallbacks are skipped,
+	// set it when some parents are fallback flows which may not be executed
+	Parents []string `json:"parents,omitempty"`
+}
+
+// getJoinPolicy returns the join policy of the Flow, it returns nil if the Flow is not a join Flow
+func getJoinPolicy(wf *serverlessv1alpha1.Workflow, flowName string) (*JoinPolicy, error) {
+	policy := &JoinPolicy{}
+	existed, err := getFlowAnnotation(wf, JoinAnnotation, flowName, policy)
+	if err != nil || !existed {
+		return nil, err
+	}
+	if len(policy.Parents) == 0 {
+		policy.Parents = findParents(wf, flowName)
+	}
+	for _, parent := range policy.Parents {
+		if _, err := findFlowByName(wf, parent); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// findParents returns the flows which output to the Flow directly or by conditions
+func findParents(wf *serverlessv1alpha1.Workflow, flowName string) []string {
+	parents := []string{}
+	for _, flow := range wf.Spec.Spec {
+		for _, next := range flowNexts(&flow) {
+			if next == flowName {
+				parents = append(parents, flow.Name)
+				break
+			}
+		}
+	}
+	return parents
+}
+
+// flowNexts returns the flows which the Flow outputs to directly or by conditions
+func flowNexts(flow *serverlessv1alpha1.Flow) []string {
+	nexts := append([]string{}, flow.Outputs...)
+	for _, condition := range flow.Conditions {
+		nexts = append(nexts, condition.Destination.IsTrue.Flows...)
+		nexts = append(nexts, condition.Destination.IsFalse.Flows...)
+	}
+	return nexts
+}
+
+// destinationFlows returns the flows in the destination (param2) and in its nested conditions
+func destinationFlows(flow *serverlessv1alpha1.Flow, next *serverlessv1alpha1.Next) []string {
+	flows := append([]string{}, next.Flows...)
+	for _, name := range next.Conditions {
+		if condition := findConditionByName(name, flow); condition != nil {
+			flows = append(flows, destinationFlows(flow, &condition.Destination.IsTrue)...)
+			flows = append(flows, destinationFlows(flow, &condition.Destination.IsFalse)...)
+		}
+	}
+	return flows
+}
+
+// exclude returns the flows which are not in the excluded ones
+func exclude(flows, excluded []string) []string {
+	result := make([]string, 0, len(flows))
+	for _, flow := range flows {
+		found := false
+		for _, e := range excluded {
+			if e == flow {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, flow)
+		}
+	}
+	return result
+}
+
+type joinTableKey struct{}
+
+// joinTable records the arrived parents results of join Flows in one execution
+type joinTable struct {
+	sync.Mutex
+	// key is the join flow name, value is the parents results keyed by the parent flow names
+	arrivals map[string]map[string]interface{}
+	// skipped is the flows which are not executed in this execution, like the ones in the untaken switch branches,
+	// a join Flow doesn't wait for the skipped parents
+	skipped map[string]bool
+	// results is the join Flows results, they're merged into the execution result
+	results map[string]interface{}
+}
+
+// withJoinTable returns a context carrying a new joinTable for an execution
+func withJoinTable(parent context.Context) context.Context {
+	return context.WithValue(parent, joinTableKey{}, &joinTable{
+		arrivals: make(map[string]map[string]interface{}),
+		skipped:  make(map[string]bool),
+		results:  make(map[string]interface{}),
+	})
+}
+
+// getJoinTable returns the joinTable of the execution, it returns nil if the context has no joinTable
+func getJoinTable(ctx context.Context) *joinTable {
+	t, _ := ctx.Value(joinTableKey{}).(*joinTable)
+	return t
+}
+
+// arrive records the result of a parent, it returns the merged parameters and true
+// when all parents have arrived or been skipped, the records are cleaned so that the Flow can be joined again
+func (t *joinTable) arrive(flowName string, parents []string, upstream string,
+	result map[string]interface{}) (map[string]interface{}, bool) {
+
+	t.Lock()
+	defer t.Unlock()
+	arrived, existed := t.arrivals[flowName]
+	if !existed {
+		arrived = make(map[string]interface{}, len(parents))
+		t.arrivals[flowName] = arrived
+	}
+	arrived[upstream] = result
+	return t.complete(flowName, parents)
+}
+
+// complete returns the merged parameters and true if some parents of the join Flow have arrived
+// and the others are skipped, the caller must hold the lock
+func (t *joinTable) complete(flowName string, parents []string) (map[string]interface{}, bool) {
+	arrived := t.arrivals[flowName]
+	if len(arrived) == 0 {
+		return nil, false
+	}
+	for _, parent := range parents {
+		if _, ok := arrived[parent]; !ok && !t.skipped[parent] {
+			return nil, false
+		}
+	}
+	delete(t.arrivals, flowName)
+	return arrived, true
+}
+
+// skip marks the flows (param2) and the flows only reachable from them as skipped,
+// it returns the merged parameters of the join Flows which become ready because their other parents are skipped
+func (t *joinTable) skip(wf *serverlessv1alpha1.Workflow, flows []string) map[string]map[string]interface{} {
+	if _, existed := wf.Annotations[JoinAnnotation]; !existed {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	ready := map[string]map[string]interface{}{}
+	queue := append([]string{}, flows...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if t.skipped[name] {
+			continue
+		}
+		t.skipped[name] = true
+		i, err := findFlowByName(wf, name)
+		if err != nil {
+			continue
+		}
+		for _, next := range flowNexts(&wf.Spec.Spec[i]) {
+			parents := findParents(wf, next)
+			if policy, err := getJoinPolicy(wf, next); err == nil && policy != nil {
+				parents = policy.Parents
+				if merged, ok := t.complete(next, parents); ok {
+					ready[next] = merged
+					continue
+				}
+			}
+			if t.allSkipped(parents) {
+				queue = append(queue, next)
+			}
+		}
+	}
+	return ready
+}
+
+// allSkipped returns whether all the flows are skipped, the caller must hold the lock
+func (t *joinTable) allSkipped(flows []string) bool {
+	for _, flow := range flows {
+		if !t.skipped[flow] {
+			return false
+		}
+	}
+	return true
+}
+
+// finish records the result of a join Flow
+func (t *joinTable) finish(flowName string, result map[string]interface{}) {
+	t.Lock()
+	defer t.Unlock()
+	t.results[flowName] = result
+}
+
+// merge merges the join Flows results into the execution result (param1) keyed by the join flow names
+func (t *joinTable) merge(result map[string]interface{}) map[string]interface{} {
+	t.Lock()
+	defer t.Unlock()
+	if len(t.results) == 0 {
+		return result
+	}
+	if result == nil {
+		result = make(map[string]interface{}, len(t.results))
+	}
+	for name, res := range t.results {
+		result[name] = res
+	}
+	return result
+}
@@ -22,6 +22,7 @@ var (
 	manager             *Manager
 	_                   = &sync.Once{}
 	ErrWorkflowNotFound = errors.New("workflow not found")
+	ErrWorkflowTimeout  = errors.New("workflow execution timeout")
 )
 
 // InitManager inits a workflow manager,
@@ -38,6 +39,13 @@ type Manager struct {
 	events                   map[event.Source]event.Handler
 	middlewares              map[middleware.Source]middleware.Handler
 	orderedMiddlewareSources []middleware.Source // in increasing order
+	validated                sync.Map            // key is the workflow name, value is a validation
+}
+
+// validation is the validation result of a Workflow in a specific resource version
+type validation struct {
+	resourceVersion string
+	err             error
 }
 
 // GetManager returns a Manager instance
@@ -106,7 +114,9 @@ func (m *Manager) getWorkflowByName(name string) (*serverlessv1alpha1.Workflow,
 }
 
 // handleWorkflow is the core function in manager,
-// it executes Workflow defined logic, calls runner.Run and returns the final result
+// it executes Workflow defined logic, calls runner.Run and returns the final result.
+// If the request has no deadline, the Workflow default timeout is applied,
+// when the deadline exceeds, it returns an ErrWorkflowTimeout error.
 func (m *Manager) handleWorkflow(sp *span.Span, parameters map[string]interface{}) (map[string]interface{}, error) {
 	// sp is root as parent
 	workflowName := sp.GetWorkflowName()
@@ -118,6 +128,9 @@ func (m *Manager) handleWorkflow(sp *span.Span, parameters map[string]interface{
 		zap.S().Errorw("workflow not found", "workflow", workflowName)
 		return nil, ErrWorkflowNotFound
 	}
+	if err := m.validate(workflow); err != nil {
+		return nil, err
+	}
 	if sp.GetFlowName() == "" {
 		flowName, functionName, err := findStart(workflow)
 		sp.SetFlowName(flowName)
@@ -126,13 +139,56 @@ func (m *Manager) handleWorkflow(sp *span.Span, parameters map[string]interface{
 			return nil, err
 		}
 	}
+	if _, hasDeadline := sp.GetContext().Deadline(); !hasDeadline {
+		if timeout := getTimeout(workflow); timeout > 0 {
+			ctx, cancel := context.WithTimeout(sp.GetContext(), timeout)
+			defer cancel()
+			sp.SetContext(ctx)
+		}
+	}
+	// the join Flows states are scoped in this execution
+	sp.SetContext(withJoinTable(sp.GetContext()))
 	sp.Start("")
 	err = m.preparePrescheduleSuite(workflowName)
 	if err != nil {
 		return nil, err
 	}
 	// flow level span here
-	return m.executeSpec(sp, parameters, workflow) // Start and Finish not symmetric
+	result, err := m.executeSpec(sp, parameters, workflow) // Start and Finish not symmetric
+	if err == errJoinedElsewhere {
+		result, err = nil, nil
+	}
+	if err == nil {
+		result = getJoinTable(sp.GetContext()).merge(result)
+	}
+	if err != nil && sp.GetContext().Err() == context.DeadlineExceeded {
+		zap.S().Errorw("workflow execution timeout", "workflow", workflowName, "err", err)
+		return nil, ErrWorkflowTimeout
+	}
+	return result, err
+}
+
+// validate validates the Workflow when it's first loaded or updated,
+// the result is cached by the resource version so that bad specs fail fast.
+// The Functions may be created after the Workflow, so the missing functions are only warned.
+func (m *Manager) validate(wf *serverlessv1alpha1.Workflow) error {
+	if v, ok := m.validated.Load(wf.Name); ok && v.(validation).resourceVersion == wf.ResourceVersion {
+		return v.(validation).err
+	}
+	warnings, err := Validate(wf, nil)
+	if err != nil {
+		zap.S().Errorw("workflow validation failed", "workflow", wf.Name, "err", err)
+	}
+	for _, warning := range warnings {
+		zap.S().Warnw("workflow validation warning", "workflow", wf.Name, "warning", warning)
+	}
+	for _, flow := range wf.Spec.Spec {
+		if _, existed, _ := k8sutils.GetFunctionByName(flow.Function); !existed {
+			zap.S().Warnw("function of flow not found", "workflow", wf.Name, "flow", flow.Name, "function", flow.Function)
+		}
+	}
+	m.validated.Store(wf.Name, validation{resourceVersion: wf.ResourceVersion, err: err})
+	return err
 }
 
 // GetRunner returns the manager runner
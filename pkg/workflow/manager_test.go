@@ -1,6 +1,8 @@
 package workflow
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,6 +11,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/spf13/viper"
 	"github.com/tass-io/scheduler/pkg/runner"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
 	"github.com/tass-io/scheduler/pkg/span"
 	"github.com/tass-io/scheduler/pkg/utils/k8sutils"
 	_ "github.com/tass-io/scheduler/pkg/utils/log"
@@ -444,3 +447,592 @@ func TestManager(t *testing.T) {
 		}
 	})
 }
+
+// BlockingFakeRunner blocks until the request context is done
+type BlockingFakeRunner struct {
+	SimpleFakeRunner
+}
+
+func (r *BlockingFakeRunner) Run(
+	sp *span.Span, parameters map[string]interface{}) (result map[string]interface{}, err error) {
+
+	<-sp.GetContext().Done()
+	return nil, sp.GetContext().Err()
+}
+
+func TestManagerTimeout(t *testing.T) {
+	Convey("test workflow timeout with the workflow annotation", t, func() {
+		helper.GetMasterRunner = func() runner.Runner {
+			return &BlockingFakeRunner{}
+		}
+		k8sutils.WithInjectData = func(objects *[]runtime.Object) {
+			workflow := &serverlessv1alpha1.Workflow{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: WorkflowAPIVersion,
+					Kind:       WorkflowKind,
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "timeout",
+					Namespace:   "default",
+					Annotations: map[string]string{TimeoutAnnotation: "100ms"},
+				},
+				Spec: serverlessv1alpha1.WorkflowSpec{
+					Spec: []serverlessv1alpha1.Flow{
+						{
+							Name:       "timeout_start",
+							Function:   "timeout_start",
+							Outputs:    []string{},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.Orphan,
+						},
+					},
+				},
+			}
+			*objects = append(*objects, workflow)
+		}
+		viper.Set("local", true)
+		k8sutils.Prepare()
+		mgr := NewManager()
+		time.Sleep(500 * time.Millisecond)
+		start := time.Now()
+		_, err := mgr.Invoke(span.NewSpan("timeout", "", "", ""), map[string]interface{}{})
+		So(err, ShouldEqual, ErrWorkflowTimeout)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
+
+// CancelFakeRunner fails the "cancel_fail" Flow and blocks the others until the request context is done
+type CancelFakeRunner struct {
+	SimpleFakeRunner
+}
+
+func (r *CancelFakeRunner) Run(
+	sp *span.Span, parameters map[string]interface{}) (result map[string]interface{}, err error) {
+
+	switch sp.GetFlowName() {
+	case "cancel_start":
+		return parameters, nil
+	case "cancel_fail":
+		return nil, &instance.FunctionError{Message: "boom"}
+	}
+	<-sp.GetContext().Done()
+	return nil, sp.GetContext().Err()
+}
+
+func TestManagerCancel(t *testing.T) {
+	Convey("test the pending branches are cancelled when one of them fails", t, func() {
+		helper.GetMasterRunner = func() runner.Runner {
+			return &CancelFakeRunner{}
+		}
+		k8sutils.WithInjectData = func(objects *[]runtime.Object) {
+			workflow := &serverlessv1alpha1.Workflow{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: WorkflowAPIVersion,
+					Kind:       WorkflowKind,
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cancel",
+					Namespace: "default",
+				},
+				Spec: serverlessv1alpha1.WorkflowSpec{
+					Spec: []serverlessv1alpha1.Flow{
+						{
+							Name:       "cancel_start",
+							Function:   "cancel_start",
+							Outputs:    []string{"cancel_slow", "cancel_fail"},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.Start,
+						},
+						{
+							Name:       "cancel_slow",
+							Function:   "cancel_slow",
+							Outputs:    []string{},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.End,
+						},
+						{
+							Name:       "cancel_fail",
+							Function:   "cancel_fail",
+							Outputs:    []string{},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.End,
+						},
+					},
+				},
+			}
+			*objects = append(*objects, workflow)
+		}
+		viper.Set("local", true)
+		k8sutils.Prepare()
+		mgr := NewManager()
+		time.Sleep(500 * time.Millisecond)
+		start := time.Now()
+		_, err := mgr.Invoke(span.NewSpan("cancel", "", "", ""), map[string]interface{}{})
+		// the failure is reported rather than the cancellation of the slow branch
+		So(err, ShouldResemble, &instance.FunctionError{Message: "boom"})
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
+
+// FlakyFakeRunner fails with the error for the first failures calls
+type FlakyFakeRunner struct {
+	SimpleFakeRunner
+	failures int
+	err      error
+	calls    int
+}
+
+func (r *FlakyFakeRunner) Run(
+	sp *span.Span, parameters map[string]interface{}) (result map[string]interface{}, err error) {
+
+	r.calls++
+	if r.calls <= r.failures {
+		return nil, r.err
+	}
+	return map[string]interface{}{"calls": r.calls}, nil
+}
+
+func TestManagerRetry(t *testing.T) {
+	testcases := []struct {
+		caseName    string
+		annotation  string
+		runner      *FlakyFakeRunner
+		expectErr   bool
+		expectCalls int
+	}{
+		{
+			caseName:    "retry function errors until success",
+			annotation:  `{"retry_start": {"maxAttempts": 3, "backoff": "10ms", "retryOn": ["function"]}}`,
+			runner:      &FlakyFakeRunner{failures: 2, err: &instance.FunctionError{Message: "flaky"}},
+			expectErr:   false,
+			expectCalls: 3,
+		},
+		{
+			caseName:    "give up after max attempts",
+			annotation:  `{"retry_start": {"maxAttempts": 2, "backoff": "10ms", "backoffType": "fixed"}}`,
+			runner:      &FlakyFakeRunner{failures: 5, err: &instance.FunctionError{Message: "flaky"}},
+			expectErr:   true,
+			expectCalls: 2,
+		},
+		{
+			caseName:    "system errors are not retried",
+			annotation:  `{"retry_start": {"maxAttempts": 3, "backoff": "10ms", "retryOn": ["function"]}}`,
+			runner:      &FlakyFakeRunner{failures: 1, err: instance.ErrInstanceNotService},
+			expectErr:   true,
+			expectCalls: 1,
+		},
+	}
+
+	for _, testcase := range testcases {
+		Convey(testcase.caseName, t, func() {
+			helper.GetMasterRunner = func() runner.Runner {
+				return testcase.runner
+			}
+			k8sutils.WithInjectData = func(objects *[]runtime.Object) {
+				workflow := &serverlessv1alpha1.Workflow{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: WorkflowAPIVersion,
+						Kind:       WorkflowKind,
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "retry",
+						Namespace:   "default",
+						Annotations: map[string]string{RetryAnnotation: testcase.annotation},
+					},
+					Spec: serverlessv1alpha1.WorkflowSpec{
+						Spec: []serverlessv1alpha1.Flow{
+							{
+								Name:       "retry_start",
+								Function:   "retry_start",
+								Outputs:    []string{},
+								Conditions: []*serverlessv1alpha1.Condition{},
+								Statement:  serverlessv1alpha1.Direct,
+								Role:       serverlessv1alpha1.Orphan,
+							},
+						},
+					},
+				}
+				*objects = append(*objects, workflow)
+			}
+			viper.Set("local", true)
+			k8sutils.Prepare()
+			mgr := NewManager()
+			time.Sleep(500 * time.Millisecond)
+			_, err := mgr.Invoke(span.NewSpan("retry", "", "", ""), map[string]interface{}{})
+			if testcase.expectErr {
+				So(err, ShouldEqual, testcase.runner.err)
+			} else {
+				So(err, ShouldBeNil)
+			}
+			So(testcase.runner.calls, ShouldEqual, testcase.expectCalls)
+		})
+	}
+}
+
+// FailingFakeRunner fails with a function error when runs the "catch_start" Flow
+type FailingFakeRunner struct {
+	SimpleFakeRunner
+}
+
+func (r *FailingFakeRunner) Run(
+	sp *span.Span, parameters map[string]interface{}) (result map[string]interface{}, err error) {
+
+	if sp.GetFlowName() == "catch_start" {
+		return nil, &instance.FunctionError{Message: "model unavailable"}
+	}
+	return parameters, nil
+}
+
+func TestManagerCatch(t *testing.T) {
+	testcases := []struct {
+		caseName   string
+		annotation string
+		expectErr  bool
+	}{
+		{
+			caseName:   "fallback flow runs with the error details",
+			annotation: `{"catch_start": {"next": "catch_fallback"}}`,
+			expectErr:  false,
+		},
+		{
+			caseName:   "system errors are not caught",
+			annotation: `{"catch_start": {"next": "catch_fallback", "errorOn": ["system"]}}`,
+			expectErr:  true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		Convey(testcase.caseName, t, func() {
+			helper.GetMasterRunner = func() runner.Runner {
+				return &FailingFakeRunner{}
+			}
+			k8sutils.WithInjectData = func(objects *[]runtime.Object) {
+				workflow := &serverlessv1alpha1.Workflow{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: WorkflowAPIVersion,
+						Kind:       WorkflowKind,
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "catch",
+						Namespace:   "default",
+						Annotations: map[string]string{CatchAnnotation: testcase.annotation},
+					},
+					Spec: serverlessv1alpha1.WorkflowSpec{
+						Spec: []serverlessv1alpha1.Flow{
+							{
+								Name:       "catch_start",
+								Function:   "catch_start",
+								Outputs:    []string{"catch_end"},
+								Conditions: []*serverlessv1alpha1.Condition{},
+								Statement:  serverlessv1alpha1.Direct,
+								Role:       serverlessv1alpha1.Start,
+							},
+							{
+								Name:       "catch_end",
+								Function:   "catch_end",
+								Outputs:    []string{},
+								Conditions: []*serverlessv1alpha1.Condition{},
+								Statement:  serverlessv1alpha1.Direct,
+								Role:       serverlessv1alpha1.End,
+							},
+							{
+								Name:       "catch_fallback",
+								Function:   "catch_fallback",
+								Outputs:    []string{},
+								Conditions: []*serverlessv1alpha1.Condition{},
+								Statement:  serverlessv1alpha1.Direct,
+								Role:       serverlessv1alpha1.End,
+							},
+						},
+					},
+				}
+				*objects = append(*objects, workflow)
+			}
+			viper.Set("local", true)
+			k8sutils.Prepare()
+			mgr := NewManager()
+			time.Sleep(500 * time.Millisecond)
+			para := map[string]interface{}{"a": "b"}
+			result, err := mgr.Invoke(span.NewSpan("catch", "", "", ""), para)
+			if testcase.expectErr {
+				So(err, ShouldNotBeNil)
+				return
+			}
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, map[string]interface{}{
+				CatchErrorKey: map[string]interface{}{
+					"flow":     "catch_start",
+					"function": "catch_start",
+					"class":    string(instance.FunctionErrorClass),
+					"message":  "model unavailable",
+				},
+				CatchInputKey: para,
+			})
+		})
+	}
+}
+
+// JoinFakeRunner records the parameters of the "join_end" Flow
+type JoinFakeRunner struct {
+	SimpleFakeRunner
+	lock   sync.Mutex
+	joined []map[string]interface{}
+}
+
+func (r *JoinFakeRunner) Run(
+	sp *span.Span, parameters map[string]interface{}) (result map[string]interface{}, err error) {
+
+	if sp.GetFlowName() == "join_end" {
+		r.lock.Lock()
+		r.joined = append(r.joined, parameters)
+		r.lock.Unlock()
+		return map[string]interface{}{"joined": true}, nil
+	}
+	return map[string]interface{}{sp.GetFlowName(): sp.GetFlowName()}, nil
+}
+
+func TestManagerJoin(t *testing.T) {
+	Convey("test the join flow runs once with all parents results", t, func() {
+		r := &JoinFakeRunner{}
+		helper.GetMasterRunner = func() runner.Runner {
+			return r
+		}
+		k8sutils.WithInjectData = func(objects *[]runtime.Object) {
+			workflow := &serverlessv1alpha1.Workflow{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: WorkflowAPIVersion,
+					Kind:       WorkflowKind,
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "join",
+					Namespace:   "default",
+					Annotations: map[string]string{JoinAnnotation: `{"join_end": {}}`},
+				},
+				Spec: serverlessv1alpha1.WorkflowSpec{
+					Spec: []serverlessv1alpha1.Flow{
+						{
+							Name:       "join_start",
+							Function:   "join_start",
+							Outputs:    []string{"join_branch_1", "join_branch_2"},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.Start,
+						},
+						{
+							Name:       "join_branch_1",
+							Function:   "join_branch_1",
+							Outputs:    []string{"join_end"},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+						},
+						{
+							Name:       "join_branch_2",
+							Function:   "join_branch_2",
+							Outputs:    []string{"join_end"},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+						},
+						{
+							Name:       "join_end",
+							Function:   "join_end",
+							Outputs:    []string{},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.End,
+						},
+					},
+				},
+			}
+			*objects = append(*objects, workflow)
+		}
+		viper.Set("local", true)
+		k8sutils.Prepare()
+		mgr := NewManager()
+		time.Sleep(500 * time.Millisecond)
+		result, err := mgr.Invoke(span.NewSpan("join", "", "", ""), map[string]interface{}{})
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, map[string]interface{}{
+			"join_end": map[string]interface{}{"joined": true},
+		})
+		So(len(r.joined), ShouldEqual, 1)
+		So(r.joined[0], ShouldResemble, map[string]interface{}{
+			"join_branch_1": map[string]interface{}{"join_branch_1": "join_branch_1"},
+			"join_branch_2": map[string]interface{}{"join_branch_2": "join_branch_2"},
+		})
+	})
+}
+
+func TestManagerJoinSkipped(t *testing.T) {
+	Convey("test the join flow doesn't wait for the parent in the untaken switch branch", t, func() {
+		r := &JoinFakeRunner{}
+		helper.GetMasterRunner = func() runner.Runner {
+			return r
+		}
+		k8sutils.WithInjectData = func(objects *[]runtime.Object) {
+			workflow := &serverlessv1alpha1.Workflow{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: WorkflowAPIVersion,
+					Kind:       WorkflowKind,
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "join-skipped",
+					Namespace:   "default",
+					Annotations: map[string]string{JoinAnnotation: `{"join_end": {}}`},
+				},
+				Spec: serverlessv1alpha1.WorkflowSpec{
+					Spec: []serverlessv1alpha1.Flow{
+						{
+							Name:       "join_start",
+							Function:   "join_start",
+							Outputs:    []string{"join_branch_1", "join_switch"},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.Start,
+						},
+						{
+							Name:       "join_branch_1",
+							Function:   "join_branch_1",
+							Outputs:    []string{"join_end"},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+						},
+						{
+							Name:     "join_switch",
+							Function: "join_switch",
+							Outputs:  []string{},
+							Conditions: []*serverlessv1alpha1.Condition{
+								{
+									Name:       "root",
+									Type:       "string",
+									Operator:   "eq",
+									Target:     "tass",
+									Comparison: "tass",
+									Destination: serverlessv1alpha1.Destination{
+										IsTrue: serverlessv1alpha1.Next{},
+										IsFalse: serverlessv1alpha1.Next{
+											Flows: []string{"join_branch_2"},
+										},
+									},
+								},
+							},
+							Statement: serverlessv1alpha1.Switch,
+						},
+						{
+							Name:       "join_branch_2",
+							Function:   "join_branch_2",
+							Outputs:    []string{"join_end"},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+						},
+						{
+							Name:       "join_end",
+							Function:   "join_end",
+							Outputs:    []string{},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  serverlessv1alpha1.Direct,
+							Role:       serverlessv1alpha1.End,
+						},
+					},
+				},
+			}
+			*objects = append(*objects, workflow)
+		}
+		viper.Set("local", true)
+		k8sutils.Prepare()
+		mgr := NewManager()
+		time.Sleep(500 * time.Millisecond)
+		result, err := mgr.Invoke(span.NewSpan("join-skipped", "", "", ""), map[string]interface{}{})
+		So(err, ShouldBeNil)
+		So(result["join_end"], ShouldResemble, map[string]interface{}{"joined": true})
+		So(len(r.joined), ShouldEqual, 1)
+		So(r.joined[0], ShouldResemble, map[string]interface{}{
+			"join_branch_1": map[string]interface{}{"join_branch_1": "join_branch_1"},
+		})
+	})
+}
+
+// MapFakeRunner doubles the item of the "map_items" Flow
+type MapFakeRunner struct {
+	SimpleFakeRunner
+}
+
+func (r *MapFakeRunner) Run(
+	sp *span.Span, parameters map[string]interface{}) (result map[string]interface{}, err error) {
+
+	if sp.GetFlowName() == "map_items" {
+		if parameters[MapItemKey] == float64(-1) {
+			// the item waits for the failure of its sibling and returns the wrapped cancellation
+			<-sp.GetContext().Done()
+			return nil, fmt.Errorf("item cancelled: %w", sp.GetContext().Err())
+		}
+		if parameters[MapItemKey] == float64(0) {
+			return nil, &instance.FunctionError{Message: "boom"}
+		}
+		return map[string]interface{}{
+			"index":  parameters[MapIndexKey],
+			"double": parameters[MapItemKey].(float64) * 2,
+		}, nil
+	}
+	return parameters, nil
+}
+
+func TestManagerMap(t *testing.T) {
+	Convey("test the map flow invokes the function per item in order", t, func() {
+		helper.GetMasterRunner = func() runner.Runner {
+			return &MapFakeRunner{}
+		}
+		k8sutils.WithInjectData = func(objects *[]runtime.Object) {
+			workflow := &serverlessv1alpha1.Workflow{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: WorkflowAPIVersion,
+					Kind:       WorkflowKind,
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "map",
+					Namespace: "default",
+					Annotations: map[string]string{
+						MapAnnotation: `{"map_items": {"itemsPath": "$.numbers", "maxParallelism": 2}}`,
+					},
+				},
+				Spec: serverlessv1alpha1.WorkflowSpec{
+					Spec: []serverlessv1alpha1.Flow{
+						{
+							Name:       "map_items",
+							Function:   "map_items",
+							Outputs:    []string{},
+							Conditions: []*serverlessv1alpha1.Condition{},
+							Statement:  Map,
+							Role:       serverlessv1alpha1.Orphan,
+						},
+					},
+				},
+			}
+			*objects = append(*objects, workflow)
+		}
+		viper.Set("local", true)
+		k8sutils.Prepare()
+		mgr := NewManager()
+		time.Sleep(500 * time.Millisecond)
+		para := map[string]interface{}{"numbers": []int{1, 2, 3}}
+		result, err := mgr.Invoke(span.NewSpan("map", "", "", ""), para)
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, map[string]interface{}{
+			MapResultsKey: []interface{}{
+				map[string]interface{}{"index": 0, "double": float64(2)},
+				map[string]interface{}{"index": 1, "double": float64(4)},
+				map[string]interface{}{"index": 2, "double": float64(6)},
+			},
+		})
+
+		_, err = mgr.Invoke(span.NewSpan("map", "", "", ""), map[string]interface{}{})
+		So(err, ShouldEqual, ErrMapItemsNotFound)
+
+		// the failure is reported rather than the cancellation of the item before it
+		_, err = mgr.Invoke(span.NewSpan("map", "", "", ""), map[string]interface{}{"numbers": []int{-1, 0}})
+		So(err, ShouldResemble, &instance.FunctionError{Message: "boom"})
+	})
+}
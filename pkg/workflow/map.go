@@ -0,0 +1,127 @@
+package workflow
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/tass-io/scheduler/pkg/span"
+	"github.com/tass-io/scheduler/pkg/utils/common"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	"go.uber.org/zap"
+)
+
+// Map is a Flow statement beyond Direct and Switch,
+// the function of a Map Flow is invoked once per element of an array in the parameters,
+// the next Flows are handled like Direct.
+// The settings are declared in the MapAnnotation.
+const Map serverlessv1alpha1.StatementType = "map"
+
+// the parameters and result keys of a Map Flow
+const (
+	// MapItemKey holds the element in the parameters of each invocation
+	MapItemKey = "item"
+	// MapIndexKey holds the element index in the parameters of each invocation
+	MapIndexKey = "index"
+	// MapResultsKey holds the results of all invocations in order
+	MapResultsKey = "items"
+)
+
+var ErrMapItemsNotFound = errors.New("map items not found")
+
+// MapPolicy is the settings of a Map Flow
+type MapPolicy struct {
+	// ItemsPath is the path of the array in the parameters, e.g. "$.items"
+	ItemsPath string `json:"itemsPath"`
+	// MaxParallelism limits the concurrent invocations, no limitation if it's 0
+	MaxParallelism int `json:"maxParallelism,omitempty"`
+}
+
+// getMapPolicy returns the map policy of the Flow
+func getMapPolicy(wf *serverlessv1alpha1.Workflow, flowName string) (*MapPolicy, error) {
+	policy := &MapPolicy{}
+	existed, err := getFlowAnnotation(wf, MapAnnotation, flowName, policy)
+	if err != nil {
+		return nil, err
+	}
+	if !existed {
+		return nil, errors.New("map flow " + flowName + " has no map settings")
+	}
+	if !strings.HasPrefix(policy.ItemsPath, "$.") {
+		return nil, errors.New("map itemsPath must start with $.")
+	}
+	if policy.MaxParallelism < 0 {
+		return nil, errors.New("map maxParallelism must not be negative")
+	}
+	return policy, nil
+}
+
+// executeMap invokes the function of the Map Flow once per element with the max parallelism,
+// each invocation has its own span and its own retry attempts.
+// It fails when one of the invocations fails, and the pending invocations are cancelled.
+func (m *Manager) executeMap(sp *span.Span, parameters map[string]interface{},
+	wf *serverlessv1alpha1.Workflow, target int) (map[string]interface{}, error) {
+
+	policy, err := getMapPolicy(wf, sp.GetFlowName())
+	if err != nil {
+		zap.S().Errorw("get map policy error", "flow", sp.GetFlowName(), "err", err)
+		return nil, err
+	}
+	var items []interface{}
+	common.GetValue(parameters, policy.ItemsPath, &items)
+	if items == nil {
+		zap.S().Errorw("map items not found", "flow", sp.GetFlowName(), "path", policy.ItemsPath)
+		return nil, ErrMapItemsNotFound
+	}
+	sp.SetTag("map.items", len(items))
+
+	parallelism := policy.MaxParallelism
+	if parallelism == 0 || parallelism > len(items) {
+		parallelism = len(items)
+	}
+	// the first failure cancels the other items and is reported rather than their cancellation
+	group, ctx := newBranchGroup(sp.GetContext())
+	defer group.cancel()
+	results := make([]interface{}, len(items))
+	tokens := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+	for i, item := range items {
+		// stop dispatching when one of the invocations fails or the request is cancelled
+		select {
+		case tokens <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, item interface{}) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			itemSp := span.NewSpanFromTheSameFlowSpanAsParent(sp)
+			itemSp.SetContext(ctx)
+			itemSp.Start(sp.GetFunctionName() + "-item")
+			itemSp.SetTag("map.index", i)
+			defer itemSp.Finish()
+			itemPara := map[string]interface{}{MapItemKey: item, MapIndexKey: i}
+			result, err := m.executeRunFunctionWithRetry(itemSp, itemPara, wf, target)
+			if err != nil {
+				zap.S().Debugw("map item execution error", "flow", sp.GetFlowName(), "index", i, "err", err)
+			}
+			results[i] = result
+			group.fail(err)
+		}(i, item)
+	}
+	wg.Wait()
+
+	if err := sp.GetContext().Err(); err != nil {
+		return nil, err
+	}
+	if err := group.firstErr(nil); err != nil {
+		zap.S().Errorw("map flow execution error", "flow", sp.GetFlowName(), "err", err)
+		return nil, err
+	}
+	return map[string]interface{}{MapResultsKey: results}, nil
+}
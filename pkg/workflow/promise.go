@@ -1,6 +1,7 @@
 package workflow
 
 import (
+	"context"
 	"sync"
 
 	"github.com/tass-io/scheduler/pkg/span"
@@ -80,3 +81,63 @@ func (p *CondPromise) GetResult() (map[string]interface{}, error) {
 	p.wg.Wait()
 	return p.res, p.err
 }
+
+// branchGroup cancels the pending branches once one of them fails and keeps the first error,
+// so that the caller reports the failure rather than the cancellation of the other branches
+type branchGroup struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	err    error
+}
+
+// newBranchGroup returns a branchGroup and the context of its branches
+func newBranchGroup(parent context.Context) (*branchGroup, context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+	return &branchGroup{cancel: cancel}, ctx
+}
+
+// flow wraps the function of a Flow branch to cancel the other branches when it fails
+func (g *branchGroup) flow(f execFunc) execFunc {
+	return func(sp *span.Span, para map[string]interface{},
+		wf *serverlessv1alpha1.Workflow) (map[string]interface{}, error) {
+
+		res, err := f(sp, para, wf)
+		g.fail(err)
+		return res, err
+	}
+}
+
+// condition wraps the function of a Condition branch to cancel the other branches when it fails
+func (g *branchGroup) condition(f execCondFunc) execCondFunc {
+	return func(sp *span.Span, condition *serverlessv1alpha1.Condition, wf *serverlessv1alpha1.Workflow,
+		target int, functionResult map[string]interface{}) (map[string]interface{}, error) {
+
+		res, err := f(sp, condition, wf, target, functionResult)
+		g.fail(err)
+		return res, err
+	}
+}
+
+// fail records the first error and cancels the branches,
+// it does nothing if err is nil or the branch result goes to join Flows
+func (g *branchGroup) fail(err error) {
+	if err == nil || err == errJoinedElsewhere {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.err == nil {
+		g.err = err
+		g.cancel()
+	}
+}
+
+// firstErr returns the first error of the branches, err (param1) is returned if no branch has failed
+func (g *branchGroup) firstErr(err error) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.err != nil {
+		return g.err
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/tass-io/scheduler/pkg/runner/instance"
+	"github.com/tass-io/scheduler/pkg/span"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	"go.uber.org/zap"
+)
+
+// BackoffType is the way to calculate the delay between two attempts
+type BackoffType string
+
+const (
+	FixedBackoff       BackoffType = "fixed"
+	ExponentialBackoff BackoffType = "exponential"
+)
+
+// RetryPolicy is the retry settings of a Flow
+type RetryPolicy struct {
+	// MaxAttempts is the max number of executions, including the first one
+	MaxAttempts uint `json:"maxAttempts"`
+	// Backoff is the delay before the first retry, e.g. "100ms"
+	Backoff string `json:"backoff,omitempty"`
+	// MaxBackoff limits the delay between two attempts, no limitation if it's empty
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+	// BackoffType is "exponential" by default
+	BackoffType BackoffType `json:"backoffType,omitempty"`
+	// RetryOn is the error classes to retry, all classes are retried if it's empty
+	RetryOn []instance.ErrorClass `json:"retryOn,omitempty"`
+}
+
+// getRetryPolicy returns the retry policy of the Flow, it returns nil if the Flow has no retry policy
+func getRetryPolicy(wf *serverlessv1alpha1.Workflow, flowName string) (*RetryPolicy, error) {
+	policy := &RetryPolicy{}
+	existed, err := getFlowAnnotation(wf, RetryAnnotation, flowName, policy)
+	if err != nil || !existed {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// validate checks whether the fields of the policy are valid
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts == 0 {
+		return errors.New("retry maxAttempts must be greater than 0")
+	}
+	for _, d := range []string{p.Backoff, p.MaxBackoff} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return err
+		}
+	}
+	switch p.BackoffType {
+	case "", FixedBackoff, ExponentialBackoff:
+	default:
+		return errors.New("unknown retry backoffType " + string(p.BackoffType))
+	}
+	return validateErrorClasses(p.RetryOn)
+}
+
+// shouldRetry returns whether the error is retryable by the policy
+func (p *RetryPolicy) shouldRetry(err error) bool {
+	return matchErrorClasses(p.RetryOn, err)
+}
+
+// options converts the policy to retry options, the attempts are logged in the flow span
+func (p *RetryPolicy) options(sp *span.Span) []retry.Option {
+	backoff, _ := time.ParseDuration(p.Backoff)
+	delayType := retry.BackOffDelay
+	if p.BackoffType == FixedBackoff {
+		delayType = retry.FixedDelay
+	}
+	opts := []retry.Option{
+		retry.Attempts(p.MaxAttempts),
+		retry.Delay(backoff),
+		retry.DelayType(delayType),
+		retry.RetryIf(p.shouldRetry),
+		retry.OnRetry(func(n uint, err error) {
+			zap.S().Warnw("flow execution failed and retries", "flow", sp.GetFlowName(), "attempt", n+1, "err", err)
+			sp.LogKV("event", "retry", "attempt", n+1, "error", err.Error())
+		}),
+		retry.Context(sp.GetContext()),
+		retry.LastErrorOnly(true),
+	}
+	if maxBackoff, err := time.ParseDuration(p.MaxBackoff); err == nil {
+		opts = append(opts, retry.MaxDelay(maxBackoff))
+	}
+	return opts
+}
+
+// matchErrorClasses returns whether the class of the error is in the classes,
+// all classes are matched if the classes are empty.
+// The context errors never match because the request has been cancelled or timeout
+func matchErrorClasses(classes []instance.ErrorClass, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if len(classes) == 0 {
+		return true
+	}
+	class := instance.ClassifyError(err)
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// validateErrorClasses checks whether all the classes are known
+func validateErrorClasses(classes []instance.ErrorClass) error {
+	for _, class := range classes {
+		if class != instance.FunctionErrorClass && class != instance.SystemErrorClass {
+			return errors.New("unknown error class " + string(class))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+)
+
+// AllowCyclesAnnotation declares whether the Workflow may have cycles, like the loop Workflows.
+// The cycles are only warned by default, they're accepted silently when its value is "true"
+// and rejected when its value is "false"
+const AllowCyclesAnnotation = "serverless.tass.io/allowCycles"
+
+// ValidationError records all the problems found in a Workflow
+type ValidationError struct {
+	WorkflowName string
+	Problems     []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("workflow %s is invalid: %s", e.WorkflowName, strings.Join(e.Problems, "; "))
+}
+
+// FunctionExists returns whether the function exists
+type FunctionExists func(name string) bool
+
+// Validate statically checks the Workflow, it returns the warnings
+// and a ValidationError with all the problems found.
+// The checks are:
+// 1. there is exactly one Start or Orphan Flow;
+// 2. the Flow names are unique and the statements are valid;
+// 3. all Outputs, condition destinations and fallback Flows refer to existing Flows or conditions;
+// 4. the Switch Flows have a root condition, and the conditions have valid type and operator;
+// 5. the Flow settings in the annotations are valid;
+// 6. the referenced functions exist, it's skipped when fnExists is nil;
+// 7. all Flows are reachable from the start, the cycles are warned or rejected as AllowCyclesAnnotation declares;
+// 8. no fallback Flow leads back to the Flow it catches, even if the cycles are allowed,
+// because the failure would be caught again and again.
+func Validate(wf *serverlessv1alpha1.Workflow, fnExists FunctionExists) ([]string, error) {
+	problems := []string{}
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	warnings := []string{}
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	starts := []string{}
+	names := make(map[string]bool, len(wf.Spec.Spec))
+	for _, flow := range wf.Spec.Spec {
+		if names[flow.Name] {
+			report("flow %s is duplicated", flow.Name)
+		}
+		names[flow.Name] = true
+		if flow.Role == serverlessv1alpha1.Start || flow.Role == serverlessv1alpha1.Orphan {
+			starts = append(starts, flow.Name)
+		}
+	}
+	if len(starts) != 1 {
+		report("workflow should have exactly one start or orphan flow, but got %v", starts)
+	}
+
+	for i := range wf.Spec.Spec {
+		flow := &wf.Spec.Spec[i]
+		for _, next := range flow.Outputs {
+			if !names[next] {
+				report("flow %s outputs to flow %s which is not found", flow.Name, next)
+			}
+		}
+		switch flow.Statement {
+		case serverlessv1alpha1.Direct:
+		case serverlessv1alpha1.Switch:
+			if findRootCondition(flow) == nil {
+				report("switch flow %s has no %s condition", flow.Name, RootCondition)
+			}
+		case Map:
+			if _, err := getMapPolicy(wf, flow.Name); err != nil {
+				report("flow %s: %v", flow.Name, err)
+			}
+		default:
+			report("flow %s has invalid statement %q", flow.Name, flow.Statement)
+		}
+		for _, condition := range flow.Conditions {
+			if err := validateCondition(condition, flow); err != nil {
+				report("flow %s: %v", flow.Name, err)
+			}
+			for _, next := range []serverlessv1alpha1.Next{condition.Destination.IsTrue, condition.Destination.IsFalse} {
+				for _, name := range next.Flows {
+					if !names[name] {
+						report("condition %s in flow %s goes to flow %s which is not found", condition.Name, flow.Name, name)
+					}
+				}
+				for _, name := range next.Conditions {
+					if findConditionByName(name, flow) == nil {
+						report("condition %s in flow %s goes to condition %s which is not found",
+							condition.Name, flow.Name, name)
+					}
+				}
+			}
+		}
+		if _, err := getRetryPolicy(wf, flow.Name); err != nil {
+			report("flow %s has invalid retry policy: %v", flow.Name, err)
+		}
+		if _, err := getCatchPolicy(wf, flow.Name); err != nil {
+			report("flow %s has invalid catch policy: %v", flow.Name, err)
+		}
+		if _, err := getJoinPolicy(wf, flow.Name); err != nil {
+			report("flow %s has invalid join policy: %v", flow.Name, err)
+		}
+		if fnExists != nil && !fnExists(flow.Function) {
+			report("function %s of flow %s is not found", flow.Function, flow.Name)
+		}
+	}
+
+	if len(starts) == 1 {
+		graph := flowGraph(wf)
+		reached := map[string]bool{}
+		visiting := map[string]bool{}
+		var cycles []string
+		var visit func(name string)
+		visit = func(name string) {
+			reached[name] = true
+			visiting[name] = true
+			for _, next := range graph[name] {
+				if visiting[next] {
+					cycles = append(cycles, name+" -> "+next)
+				} else if !reached[next] {
+					visit(next)
+				}
+			}
+			visiting[name] = false
+		}
+		visit(starts[0])
+		for _, flow := range wf.Spec.Spec {
+			if !reached[flow.Name] {
+				report("flow %s is unreachable from the start flow %s", flow.Name, starts[0])
+			}
+		}
+		if len(cycles) > 0 {
+			switch wf.Annotations[AllowCyclesAnnotation] {
+			case "true":
+			case "false":
+				report("workflow has cycles %v, but the %s annotation is false", cycles, AllowCyclesAnnotation)
+			default:
+				warn("workflow has cycles %v, set the %s annotation to true if they are intended",
+					cycles, AllowCyclesAnnotation)
+			}
+		}
+		for _, flow := range wf.Spec.Spec {
+			if catch, err := getCatchPolicy(wf, flow.Name); err == nil && catch != nil &&
+				reachable(graph, catch.Next, flow.Name) {
+				report("fallback flow %s of flow %s leads back to it", catch.Next, flow.Name)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return warnings, nil
+	}
+	return warnings, &ValidationError{WorkflowName: wf.Name, Problems: problems}
+}
+
+// reachable returns whether the Flow (param3) is reachable from the Flow (param2) in the graph
+func reachable(graph map[string][]string, from, to string) bool {
+	visited := map[string]bool{}
+	queue := []string{from}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == to {
+			return true
+		}
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		queue = append(queue, graph[name]...)
+	}
+	return false
+}
+
+// flowGraph returns the edges between Flows, including the Outputs,
+// the flows in the condition destinations and the fallback Flows
+func flowGraph(wf *serverlessv1alpha1.Workflow) map[string][]string {
+	graph := make(map[string][]string, len(wf.Spec.Spec))
+	for _, flow := range wf.Spec.Spec {
+		nexts := flowNexts(&flow)
+		if catch, err := getCatchPolicy(wf, flow.Name); err == nil && catch != nil {
+			nexts = append(nexts, catch.Next)
+		}
+		graph[flow.Name] = nexts
+	}
+	return graph
+}
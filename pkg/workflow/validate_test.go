@@ -0,0 +1,149 @@
+package workflow
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	serverlessv1alpha1 "github.com/tass-io/tass-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidate(t *testing.T) {
+	newFlow := func(name string, role serverlessv1alpha1.Role, outputs ...string) serverlessv1alpha1.Flow {
+		return serverlessv1alpha1.Flow{
+			Name:       name,
+			Function:   name,
+			Outputs:    outputs,
+			Conditions: []*serverlessv1alpha1.Condition{},
+			Statement:  serverlessv1alpha1.Direct,
+			Role:       role,
+		}
+	}
+	switchFlow := newFlow("switch", serverlessv1alpha1.Start)
+	switchFlow.Statement = serverlessv1alpha1.Switch
+	switchFlow.Conditions = []*serverlessv1alpha1.Condition{
+		{
+			Name:       "not_root",
+			Type:       serverlessv1alpha1.Bool,
+			Operator:   serverlessv1alpha1.Gt,
+			Target:     "$.a",
+			Comparison: "true",
+			Destination: serverlessv1alpha1.Destination{
+				IsTrue: serverlessv1alpha1.Next{Flows: []string{"missing"}},
+			},
+		},
+	}
+
+	testcases := []struct {
+		caseName    string
+		flows       []serverlessv1alpha1.Flow
+		annotations map[string]string
+		fnExists    FunctionExists
+		problems    int
+		warnings    int
+	}{
+		{
+			caseName: "valid workflow",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Start, "end"),
+				newFlow("end", serverlessv1alpha1.End),
+			},
+			problems: 0,
+		},
+		{
+			caseName: "no start and unknown output",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("mid", "", "missing"),
+			},
+			problems: 2,
+		},
+		{
+			caseName: "switch without root, invalid operator and unknown destination",
+			flows:    []serverlessv1alpha1.Flow{switchFlow},
+			problems: 3,
+		},
+		{
+			caseName: "unreachable flow and missing function",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Orphan),
+				newFlow("alone", serverlessv1alpha1.End),
+			},
+			fnExists: func(name string) bool { return name == "start" },
+			problems: 2,
+		},
+		{
+			caseName: "fallback flow is reachable",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Orphan),
+				newFlow("fallback", serverlessv1alpha1.End),
+			},
+			annotations: map[string]string{CatchAnnotation: `{"start": {"next": "fallback"}}`},
+			problems:    0,
+		},
+		{
+			caseName: "fallback to itself",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Orphan),
+			},
+			annotations: map[string]string{CatchAnnotation: `{"start": {"next": "start"}}`},
+			problems:    1,
+		},
+		{
+			caseName: "fallback leads back even if the cycles are allowed",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Start, "end"),
+				newFlow("end", serverlessv1alpha1.End),
+				newFlow("fallback", "", "retry"),
+				newFlow("retry", serverlessv1alpha1.End, "start"),
+			},
+			annotations: map[string]string{
+				CatchAnnotation:       `{"start": {"next": "fallback"}}`,
+				AllowCyclesAnnotation: "true",
+			},
+			problems: 1,
+		},
+		{
+			caseName: "cycle is warned by default",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Start, "loop"),
+				newFlow("loop", "", "start"),
+			},
+			problems: 0,
+			warnings: 1,
+		},
+		{
+			caseName: "intended cycle",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Start, "loop"),
+				newFlow("loop", "", "start"),
+			},
+			annotations: map[string]string{AllowCyclesAnnotation: "true"},
+			problems:    0,
+		},
+		{
+			caseName: "cycle is rejected",
+			flows: []serverlessv1alpha1.Flow{
+				newFlow("start", serverlessv1alpha1.Start, "loop"),
+				newFlow("loop", "", "start"),
+			},
+			annotations: map[string]string{AllowCyclesAnnotation: "false"},
+			problems:    1,
+		},
+	}
+	for _, testcase := range testcases {
+		Convey(testcase.caseName, t, func() {
+			wf := &serverlessv1alpha1.Workflow{
+				ObjectMeta: metav1.ObjectMeta{Name: "validate", Annotations: testcase.annotations},
+				Spec:       serverlessv1alpha1.WorkflowSpec{Spec: testcase.flows},
+			}
+			warnings, err := Validate(wf, testcase.fnExists)
+			So(len(warnings), ShouldEqual, testcase.warnings)
+			if testcase.problems == 0 {
+				So(err, ShouldBeNil)
+				return
+			}
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+			So(len(err.(*ValidationError).Problems), ShouldEqual, testcase.problems)
+		})
+	}
+}
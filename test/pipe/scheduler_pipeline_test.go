@@ -1,6 +1,7 @@
 package pipe
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -16,7 +17,7 @@ import (
 type PipeMockInstance struct {
 }
 
-func (p *PipeMockInstance) Invoke(parameters map[string]interface{}) (map[string]interface{}, error) {
+func (p *PipeMockInstance) Invoke(_ context.Context, parameters map[string]interface{}) (map[string]interface{}, error) {
 	return parameters, nil
 }
 
@@ -24,6 +25,10 @@ func (p *PipeMockInstance) Score() int {
 	return 1
 }
 
+func (p *PipeMockInstance) Metrics() instance.Metrics {
+	return instance.Metrics{}
+}
+
 func (p *PipeMockInstance) Release() {}
 
 func (p *PipeMockInstance) IsRunning() bool {
@@ -40,6 +45,10 @@ func (p *PipeMockInstance) HasRequests() bool {
 
 func (p *PipeMockInstance) InitDone() {}
 
+func (p *PipeMockInstance) Done() <-chan struct{} {
+	return nil
+}
+
 func TestSchedulerPipeline(t *testing.T) {
 	fnscheduler.NewInstance = func(functionName string) instance.Instance {
 		return &PipeMockInstance{}
@@ -1,6 +1,7 @@
 package switch_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -19,7 +20,7 @@ type switchMockInstance struct {
 }
 
 func (s *switchMockInstance) Invoke(
-	parameters map[string]interface{}) (map[string]interface{}, error) {
+	_ context.Context, parameters map[string]interface{}) (map[string]interface{}, error) {
 
 	parameters[s.name] = s.name
 	return parameters, nil
@@ -29,6 +30,10 @@ func (s *switchMockInstance) Score() int {
 	return 0
 }
 
+func (s *switchMockInstance) Metrics() instance.Metrics {
+	return instance.Metrics{}
+}
+
 func (s *switchMockInstance) Release() {}
 
 func (s *switchMockInstance) IsRunning() bool {
@@ -45,6 +50,10 @@ func (s *switchMockInstance) HasRequests() bool {
 
 func (s *switchMockInstance) InitDone() {}
 
+func (s *switchMockInstance) Done() <-chan struct{} {
+	return nil
+}
+
 func TestSchedulerSwitch(t *testing.T) {
 	testcases := []struct {
 		skipped      bool